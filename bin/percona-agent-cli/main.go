@@ -256,7 +256,7 @@ func (cli *Cli) send(args []string) {
 	}
 	if len(args) == 4 {
 		switch args[1] {
-		case "Update":
+		case "Update", "GetMetrics", "GetHistory", "SendSnapshot":
 			cmd.Data = []byte(args[3])
 		case "GetInfo":
 			si := &proto.ServiceInstance{}
@@ -287,6 +287,13 @@ func (cli *Cli) send(args []string) {
 			return
 		}
 		fmt.Printf("%#v\n", v)
+	case "GetMetrics", "GetHistory":
+		var out bytes.Buffer
+		if err := json.Indent(&out, reply.Data, "", "  "); err != nil {
+			fmt.Printf("Invalid %s reply: %s\n", cmd.Cmd, err)
+			return
+		}
+		fmt.Println(out.String())
 	}
 }
 
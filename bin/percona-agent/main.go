@@ -144,6 +144,9 @@ func run() error {
 	 */
 
 	logChan := make(chan *proto.LogEntry, log.BUFFER_SIZE*3)
+	pct.Metrics.SetGauge("log/chan", func() float64 {
+		return float64(len(logChan))
+	})
 
 	// Log websocket client, possibly disabled later.
 	logClient, err := client.NewWebsocketClient(pct.NewLogger(logChan, "log-ws"), api, "log")
@@ -290,6 +290,31 @@ func (s *DiskvSpoolerTestSuite) TestSpoolGzipData(t *C) {
 	spool.Stop()
 }
 
+func (s *DiskvSpoolerTestSuite) TestFileGauges(t *C) {
+	// Files from a previous run are counted on start.
+	for _, file := range []string{"mm_1", "mm_2"} {
+		if err := ioutil.WriteFile(filepath.Join(s.dataDir, file), []byte("12345"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spool := data.NewDiskvSpooler(s.logger, s.dataDir, "localhost")
+	err := spool.Start(data.NewJsonSerializer())
+	t.Assert(err, IsNil)
+	defer spool.Stop()
+
+	gauges := pct.Metrics.Gauges()
+	t.Check(gauges["spool/files"], Equals, float64(2))
+	t.Check(gauges["spool/bytes"], Equals, float64(10))
+
+	// Removing a file updates the counts without re-reading the dir.
+	err = spool.Remove("mm_1")
+	t.Assert(err, IsNil)
+	gauges = pct.Metrics.Gauges()
+	t.Check(gauges["spool/files"], Equals, float64(1))
+	t.Check(gauges["spool/bytes"], Equals, float64(5))
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Sender test suite
 /////////////////////////////////////////////////////////////////////////////
@@ -160,6 +160,7 @@ func (s *Sender) send() {
 		s.status.Update("data-sender", "Sending "+file)
 		if err := s.client.SendBytes(data); err != nil {
 			s.logger.Warn(err)
+			pct.Metrics.Inc("sender/errors", 1)
 			continue
 		}
 
@@ -167,10 +168,12 @@ func (s *Sender) send() {
 		resp := &proto.Response{}
 		if err := s.client.Recv(resp, 5); err != nil {
 			s.logger.Warn(err)
+			pct.Metrics.Inc("sender/errors", 1)
 			continue
 		}
 		s.logger.Debug(fmt.Sprintf("send:resp:%+v", resp.Code))
 		if resp.Code != 200 && resp.Code != 201 {
+			pct.Metrics.Inc("sender/errors", 1)
 			if resp.Code >= 400 && resp.Code < 500 {
 				// Something on our side is broken.
 				if n400Err < maxWarnErr {
@@ -192,6 +195,7 @@ func (s *Sender) send() {
 		s.status.Update("data-sender", "Removing "+file)
 		s.spool.Remove(file)
 		s.logger.Info("Sent and removed", file)
+		pct.Metrics.Inc("sender/sent", 1)
 	}
 
 	if n400Err > maxWarnErr {
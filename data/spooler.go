@@ -24,7 +24,9 @@ import (
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/pct"
 	"github.com/peterbourgon/diskv"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -58,6 +60,10 @@ type DiskvSpooler struct {
 	cache    *diskv.Diskv
 	status   *pct.Status
 	mux      *sync.Mutex
+	// Spooled files, for the spool/files and spool/bytes gauges
+	nFiles   float64
+	nBytes   float64
+	countMux *sync.Mutex
 }
 
 func NewDiskvSpooler(logger *pct.Logger, dataDir string, hostname string) *DiskvSpooler {
@@ -70,6 +76,7 @@ func NewDiskvSpooler(logger *pct.Logger, dataDir string, hostname string) *Diskv
 		sync:     pct.NewSyncChan(),
 		status:   pct.NewStatus([]string{"data-spooler"}),
 		mux:      new(sync.Mutex),
+		countMux: new(sync.Mutex),
 	}
 	return s
 }
@@ -98,12 +105,33 @@ func (s *DiskvSpooler) Start(sz Serializer) error {
 		IndexLess:    func(a, b string) bool { return a < b },
 	})
 
+	// Spool metrics for the mm agent monitor.  Files are sent and removed
+	// by the sender, so they're its backlog.  Files left from a previous run
+	// are counted once here, then writes and removes keep the counts.
+	s.countFiles()
+	pct.Metrics.SetGauge("spool/data_chan", func() float64 {
+		return float64(len(s.dataChan))
+	})
+	pct.Metrics.SetGauge("spool/files", func() float64 {
+		s.countMux.Lock()
+		defer s.countMux.Unlock()
+		return s.nFiles
+	})
+	pct.Metrics.SetGauge("spool/bytes", func() float64 {
+		s.countMux.Lock()
+		defer s.countMux.Unlock()
+		return s.nBytes
+	})
+
 	go s.run()
 	s.logger.Info("Started")
 	return nil
 }
 
 func (s *DiskvSpooler) Stop() error {
+	pct.Metrics.RemoveGauge("spool/data_chan")
+	pct.Metrics.RemoveGauge("spool/files")
+	pct.Metrics.RemoveGauge("spool/bytes")
 	s.sync.Stop()
 	s.sync.Wait()
 	s.sz = nil
@@ -153,6 +181,7 @@ func (s *DiskvSpooler) Write(service string, data interface{}) error {
 	case <-time.After(100 * time.Millisecond):
 		// Let caller decide what to do.
 		s.logger.Debug("write:timeout")
+		pct.Metrics.Inc("spool/write_timeouts", 1)
 		return ErrSpoolTimeout
 	}
 
@@ -168,13 +197,48 @@ func (s *DiskvSpooler) Read(file string) ([]byte, error) {
 }
 
 func (s *DiskvSpooler) Remove(file string) error {
-	return s.cache.Erase(file)
+	var size int64
+	if fi, err := os.Stat(filepath.Join(s.dataDir, file)); err == nil {
+		size = fi.Size()
+	}
+	if err := s.cache.Erase(file); err != nil {
+		return err
+	}
+	s.count(-1, -size)
+	return nil
 }
 
 /////////////////////////////////////////////////////////////////////////////
 // Implementation
 /////////////////////////////////////////////////////////////////////////////
 
+// countFiles sets the number and total size (bytes) of spooled files.
+func (s *DiskvSpooler) countFiles() {
+	fileInfo, err := ioutil.ReadDir(s.dataDir)
+	if err != nil {
+		s.logger.Warn(err)
+	}
+	var n, bytes float64
+	for _, fi := range fileInfo {
+		if fi.Mode().IsRegular() {
+			n++
+			bytes += float64(fi.Size())
+		}
+	}
+	s.countMux.Lock()
+	defer s.countMux.Unlock()
+	s.nFiles = n
+	s.nBytes = bytes
+}
+
+// count adds files and bytes, which are negative when files are removed.
+func (s *DiskvSpooler) count(files, bytes int64) {
+	s.countMux.Lock()
+	defer s.countMux.Unlock()
+	s.nFiles += float64(files)
+	s.nBytes += float64(bytes)
+}
+
 // @goroutine[1]
 func (s *DiskvSpooler) run() {
 	defer func() {
@@ -204,7 +268,9 @@ func (s *DiskvSpooler) run() {
 
 			if err := s.cache.Write(key, bytes); err != nil {
 				s.logger.Error(err)
+				continue
 			}
+			s.count(1, int64(len(bytes)))
 		case <-s.sync.StopChan:
 			s.sync.Graceful()
 			return
@@ -0,0 +1,96 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent_test
+
+import (
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/agent"
+	"github.com/percona/percona-agent/pct"
+	. "launchpad.net/gocheck"
+	"testing"
+	"time"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type AgentTestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&AgentTestSuite{})
+
+func (s *AgentTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 100)
+	s.logger = pct.NewLogger(s.logChan, "mm-agent-test")
+}
+
+// --------------------------------------------------------------------------
+
+func (s *AgentTestSuite) TestRegistryMetrics(t *C) {
+	r := pct.NewMetricRegistry()
+	r.Inc("mm/lost_reports", 2)
+	r.SetGauge("spool/files", func() float64 { return 10 })
+	r.SetGauge("log/chan", func() float64 { return 3 })
+	t.Check(agent.RegistryMetrics(r), DeepEquals, []mm.Metric{
+		{Name: "agent/log/chan", Type: "gauge", Number: 3},
+		{Name: "agent/mm/lost_reports", Type: "counter", Number: 2},
+		{Name: "agent/spool/files", Type: "gauge", Number: 10},
+	})
+}
+
+func (s *AgentTestSuite) TestCollect(t *C) {
+	config := &agent.Config{
+		Config: mm.Config{
+			ServiceInstance: proto.ServiceInstance{Service: "agent"},
+			Collect:         1,
+			Report:          60,
+		},
+	}
+	m := agent.NewMonitor("mm-agent", config, s.logger)
+
+	tickChan := make(chan time.Time)
+	collectionChan := make(chan *mm.Collection, 1)
+	err := m.Start(tickChan, collectionChan)
+	t.Assert(err, IsNil)
+	defer m.Stop()
+
+	pct.Metrics.SetGauge("test/gauge", func() float64 { return 42 })
+	defer pct.Metrics.RemoveGauge("test/gauge")
+
+	now := time.Now()
+	tickChan <- now
+	var c *mm.Collection
+	select {
+	case c = <-collectionChan:
+	case <-time.After(1 * time.Second):
+		t.Fatal("No collection")
+	}
+	t.Check(c.Service, Equals, "agent")
+	t.Check(c.Ts, Equals, now.UTC().Unix())
+
+	metrics := make(map[string]mm.Metric)
+	for _, m := range c.Metrics {
+		metrics[m.Name] = m
+	}
+	t.Check(metrics["agent/runtime/goroutines"].Number > 0, Equals, true)
+	t.Check(metrics["agent/runtime/heap_alloc"].Number > 0, Equals, true)
+	t.Check(metrics["agent/runtime/gc"].Type, Equals, "counter")
+	t.Check(metrics["agent/test/gauge"], DeepEquals, mm.Metric{Name: "agent/test/gauge", Type: "gauge", Number: 42})
+}
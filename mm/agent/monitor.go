@@ -0,0 +1,214 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agent
+
+import (
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/pct"
+	"runtime"
+	"sort"
+	"time"
+)
+
+/**
+ * Monitor reports metrics about the agent itself: Go runtime stats and the
+ * metrics in pct.Metrics, like spool files and lost reports.  All metrics
+ * are prefixed agent/.
+ */
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	// --
+	tickChan       chan time.Time
+	collectionChan chan *mm.Collection
+	status         *pct.Status
+	sync           *pct.SyncChan
+	running        bool
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		// --
+		status: pct.NewStatus([]string{name}),
+		sync:   pct.NewSyncChan(),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, collectionChan chan *mm.Collection) error {
+	m.logger.Debug("Start:call")
+	defer m.logger.Debug("Start:return")
+
+	if m.running {
+		return pct.ServiceIsRunningError{m.name}
+	}
+
+	m.tickChan = tickChan
+	m.collectionChan = collectionChan
+
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	m.logger.Debug("Stop:call")
+	defer m.logger.Debug("Stop:return")
+
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+
+	m.config = nil // no config if not running
+	m.running = false
+	m.logger.Info("Stopped")
+
+	// Do not update status to "Stopped" here; run() does that on return.
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[1]
+func (m *Monitor) run() {
+	m.logger.Debug("run:call")
+	defer func() {
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+		m.logger.Debug("run:return")
+	}()
+
+	var lastTs int64
+	var lastError string
+	for {
+		t := time.Unix(lastTs, 0)
+		if lastError == "" {
+			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s)", t))
+		} else {
+			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s, error: %s)", t, lastError))
+		}
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:collect:start")
+			m.status.Update(m.name, "Running")
+
+			c := &mm.Collection{
+				ServiceInstance: proto.ServiceInstance{
+					Service:    m.config.Service,
+					InstanceId: m.config.InstanceId,
+				},
+				Ts:      now.UTC().Unix(),
+				Metrics: RuntimeMetrics(),
+			}
+			c.Metrics = append(c.Metrics, RegistryMetrics(pct.Metrics)...)
+
+			// Send the metrics to an mm.Aggregator.
+			lastError = ""
+			select {
+			case m.collectionChan <- c:
+				lastTs = c.Ts
+			case <-time.After(500 * time.Millisecond):
+				// lost collection
+				m.logger.Debug("Lost agent metrics; timeout spooling after 500ms")
+				pct.Metrics.Inc("mm/lost_collections", 1)
+				lastError = "Spool timeout"
+			}
+
+			m.logger.Debug("run:collect:stop")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+// RuntimeMetrics returns Go runtime metrics: goroutines, memory, and GC.
+// GC pause times are seconds.
+func RuntimeMetrics() []mm.Metric {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	var lastPause float64
+	if ms.NumGC > 0 {
+		lastPause = float64(ms.PauseNs[(ms.NumGC+255)%256]) / 1e9
+	}
+	return []mm.Metric{
+		{Name: "agent/runtime/goroutines", Type: "gauge", Number: float64(runtime.NumGoroutine())},
+		{Name: "agent/runtime/sys", Type: "gauge", Number: float64(ms.Sys)},
+		{Name: "agent/runtime/heap_alloc", Type: "gauge", Number: float64(ms.HeapAlloc)},
+		{Name: "agent/runtime/heap_sys", Type: "gauge", Number: float64(ms.HeapSys)},
+		{Name: "agent/runtime/heap_objects", Type: "gauge", Number: float64(ms.HeapObjects)},
+		{Name: "agent/runtime/gc", Type: "counter", Number: float64(ms.NumGC)},
+		{Name: "agent/runtime/gc_pause", Type: "counter", Number: float64(ms.PauseTotalNs) / 1e9},
+		{Name: "agent/runtime/gc_last_pause", Type: "gauge", Number: lastPause},
+	}
+}
+
+// RegistryMetrics returns the registry's counters and gauges, sorted by name.
+func RegistryMetrics(r *pct.MetricRegistry) []mm.Metric {
+	metrics := []mm.Metric{}
+	for name, val := range r.Counters() {
+		metrics = append(metrics, mm.Metric{Name: "agent/" + name, Type: "counter", Number: val})
+	}
+	for name, val := range r.Gauges() {
+		metrics = append(metrics, mm.Metric{Name: "agent/" + name, Type: "gauge", Number: val})
+	}
+	sort.Sort(byName(metrics))
+	return metrics
+}
+
+type byName []mm.Metric
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i].Name < a[j].Name }
@@ -18,31 +18,122 @@
 package mm
 
 import (
+	"fmt"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/data"
 	"github.com/percona/percona-agent/pct"
 	"math"
+	"sync"
 	"time"
 )
 
+// Most intervals an aggregator keeps open waiting for late collections.
+const MAX_OPEN_INTERVALS = 5
+
+// Intervals a metric is reported stale before it's forgotten, see coverage().
+const MAX_STALE_INTERVALS = 1
+
+// An interval that hasn't been reported yet, see Aggregator.run().
+type openInterval struct {
+	ts       int64     // start, Unix ts
+	startTs  time.Time // start, UTC
+	stats    []*InstanceStats
+	received map[string]uint // collections per instance for this interval
+	late     map[string]uint // collections for past intervals
+}
+
+func newOpenInterval(interval, ts int64, prev []*InstanceStats) *openInterval {
+	// If an instance sends no collections, it's still reported; see coverage().
+	stats := make([]*InstanceStats, len(prev))
+	for n := range prev {
+		stats[n] = &InstanceStats{
+			ServiceInstance: prev[n].ServiceInstance,
+			Stats:           make(map[string]*Stats),
+		}
+	}
+	iv := &openInterval{
+		ts:       ts,
+		startTs:  GoTime(interval, ts),
+		stats:    stats,
+		received: make(map[string]uint),
+		late:     make(map[string]uint),
+	}
+	return iv
+}
+
+// A monitor sending collections to an aggregator, see Aggregator.Expect().
+type expectation struct {
+	proto.ServiceInstance
+	collect int64 // seconds
+	since   int64 // Unix ts
+}
+
+// ticks returns how many collections are expected in the interval [begin, end).
+// Monitors collect on synchronized ticks, i.e. multiples of the collect interval.
+func (e expectation) ticks(begin, end int64) uint {
+	if e.collect <= 0 {
+		return 0
+	}
+	if e.since > begin {
+		begin = e.since
+	}
+	first := ((begin + e.collect - 1) / e.collect) * e.collect
+	if first >= end {
+		return 0
+	}
+	return uint((end-1-first)/e.collect + 1)
+}
+
+// A metric an instance reported before, see Aggregator.coverage().
+type knownMetric struct {
+	metricType string
+	stale      uint // intervals in a row not reported
+}
+
+// The latest raw value of a histogram metric, see Aggregator.add().
+type rawHistogram struct {
+	ts     int64
+	metric Metric
+}
+
+func instanceKey(si proto.ServiceInstance) string {
+	return fmt.Sprintf("%s-%d", si.Service, si.InstanceId)
+}
+
 type Aggregator struct {
 	logger         *pct.Logger
 	interval       int64
 	collectionChan chan *Collection
 	spool          data.Spooler
+	alerter        *Alerter // optional
 	// --
-	sync    *pct.SyncChan
-	running bool
+	sync     *pct.SyncChan
+	running  bool
+	expected map[string]expectation             // keyed on monitor name
+	known    map[string]map[string]*knownMetric // instance: metric name
+	grace    int64                              // seconds to wait for late collections
+	mux      *sync.Mutex                        // guards expected, known, grace, and rollups
+	recent   *CollectionBuffer
+	rollups  []*rollup
+	// Latest histograms, keyed on instance and metric name: @goroutine[1] only
+	lastHist map[string]map[string]*rawHistogram
 }
 
-func NewAggregator(logger *pct.Logger, interval int64, collectionChan chan *Collection, spool data.Spooler) *Aggregator {
+func NewAggregator(logger *pct.Logger, interval int64, collectionChan chan *Collection, spool data.Spooler, alerter *Alerter) *Aggregator {
 	a := &Aggregator{
 		logger:         logger,
 		interval:       interval,
 		collectionChan: collectionChan,
 		spool:          spool,
+		alerter:        alerter,
+		// --
+		sync:     pct.NewSyncChan(),
+		expected: make(map[string]expectation),
+		known:    make(map[string]map[string]*knownMetric),
+		mux:      &sync.Mutex{},
+		recent:   NewCollectionBuffer(COLLECTION_BUFFER_SIZE),
 		// --
-		sync: pct.NewSyncChan(),
+		lastHist: make(map[string]map[string]*rawHistogram),
 	}
 	return a
 }
@@ -63,6 +154,83 @@ func (a *Aggregator) Stop() {
 	a.sync.Wait()
 }
 
+/**
+ * Expect tells the aggregator that the named monitor sends collections for
+ * the service instance every collect seconds since the given time.  Reports
+ * for the instance then have Coverage, and metrics it stops sending are
+ * reported as stale.  Calling Expect again, e.g. when the monitor restarts,
+ * forgets the instance's metrics so ones no longer collected aren't stale.
+ */
+// @goroutine[0]
+func (a *Aggregator) Expect(name string, si proto.ServiceInstance, collect uint, since time.Time) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.expected[name] = expectation{
+		ServiceInstance: si,
+		collect:         int64(collect),
+		since:           since.Unix(),
+	}
+	delete(a.known, instanceKey(si))
+}
+
+/**
+ * SetGrace sets how many seconds past the end of an interval the aggregator
+ * waits for its collections before reporting it, unless all expected ones
+ * arrive sooner.  Time is measured by collection timestamps, so an interval
+ * is reported when a collection at least grace seconds after it arrives.
+ * Monitors sharing the aggregator can set different grace windows, so the
+ * largest is used.  The default, zero, reports an interval as soon as the
+ * first collection for the next one arrives.
+ */
+// @goroutine[0]
+func (a *Aggregator) SetGrace(grace uint) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if int64(grace) > a.grace {
+		a.grace = int64(grace)
+	}
+}
+
+/**
+ * AddRollup makes the aggregator also report every interval seconds by
+ * merging its reports, see rollup.  The interval must be a multiple of the
+ * aggregator's interval.  Like the grace window, rollups are shared by all
+ * monitors, so they're only added, never removed.
+ */
+// @goroutine[0]
+func (a *Aggregator) AddRollup(interval uint) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if int64(interval) <= a.interval || int64(interval)%a.interval != 0 {
+		a.logger.Warn(fmt.Sprintf("Invalid rollup interval %d for %d second reports", interval, a.interval))
+		return
+	}
+	for _, r := range a.rollups {
+		if r.interval == int64(interval) {
+			return
+		}
+	}
+	a.rollups = append(a.rollups, newRollup(int64(interval)))
+	a.logger.Info("Added", interval, "second rollup")
+}
+
+// Remove stops expecting collections from the named monitor.
+// @goroutine[0]
+func (a *Aggregator) Remove(name string) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if e, ok := a.expected[name]; ok {
+		delete(a.known, instanceKey(e.ServiceInstance))
+		delete(a.expected, name)
+	}
+}
+
+// Recent returns the buffer of the most recent collections the aggregator received.
+// @goroutine[0]
+func (a *Aggregator) Recent() *CollectionBuffer {
+	return a.recent
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Implementation
 /////////////////////////////////////////////////////////////////////////////
@@ -74,79 +242,70 @@ func (a *Aggregator) run() {
 		a.sync.Done()
 	}()
 
-	var curInterval int64
-	var startTs time.Time
-	cur := []*InstanceStats{}
+	open := []*openInterval{}      // oldest first
+	var last []*InstanceStats      // stats of the last reported interval
+	lastTs := int64(-1)            // start of the last reported interval, -1 if none
+	pendingLate := []*Collection{} // late collections while no interval is open
 
 	for {
 		select {
 		case collection := <-a.collectionChan:
-			interval := (collection.Ts / a.interval) * a.interval
-			if curInterval == 0 {
-				curInterval = interval
-				startTs = GoTime(a.interval, interval)
-				a.logger.Debug("Start first interval", startTs)
-			}
-			if interval > curInterval {
-				// Metrics for next interval have arrived.  Process and spool
-				// the current interval, then advance to this interval.
-				a.report(startTs, cur)
-
-				// Init next stats based on current ones to avoid re-creating them.
-				// todo: what if metrics from an instance aren't collected?
-				next := make([]*InstanceStats, len(cur))
-				for n := range cur {
-					i := &InstanceStats{
-						ServiceInstance: cur[n].ServiceInstance,
-						Stats:           make(map[string]*Stats),
-					}
-					next[n] = i
-				}
-				cur = next
-				curInterval = interval
-				startTs = GoTime(a.interval, interval)
-				a.logger.Debug("Start interval", startTs)
-			} else if interval < curInterval {
-				t := GoTime(a.interval, interval)
-				a.logger.Info("Lost collection for interval", t, "; current interval is", startTs)
+			a.recent.Add(collection)
+			if a.alerter != nil {
+				a.alerter.Collection(collection)
 			}
+			ts := (collection.Ts / a.interval) * a.interval
+			key := instanceKey(collection.ServiceInstance)
 
-			// Each collection is from a specific service instance.
-			// Find the stats for this instance, create if they don't exist.
-			var is *InstanceStats
-			for _, i := range cur {
-				if collection.Service == i.Service && collection.InstanceId == i.InstanceId {
-					is = i
-					break
+			// Find the collection's interval in open, or where it goes.
+			var iv *openInterval
+			n := 0
+			for n < len(open) && open[n].ts < ts {
+				n++
+			}
+			if n < len(open) && open[n].ts == ts {
+				iv = open[n]
+			} else if ts > lastTs {
+				// Metrics for a new interval have arrived, usually the latest
+				// but it can be between open intervals if its collections were
+				// delayed.  Init its stats based on the previous ones to avoid
+				// re-creating them.
+				prev := last
+				if n > 0 {
+					prev = open[n-1].stats
+				}
+				iv = newOpenInterval(a.interval, ts, prev)
+				open = append(open, nil)
+				copy(open[n+1:], open[n:])
+				open[n] = iv
+				a.logger.Debug("Start interval", iv.startTs)
+				if len(open) == 1 {
+					for _, c := range pendingLate {
+						a.addLate(iv, c)
+					}
+					pendingLate = pendingLate[:0]
 				}
 			}
 
-			if is == nil {
-				// New service instance, create stats for it.
-				is = &InstanceStats{
-					ServiceInstance: proto.ServiceInstance{
-						Service:    collection.Service,
-						InstanceId: collection.InstanceId,
-					},
-					Stats: make(map[string]*Stats),
-				}
-				cur = append(cur, is)
+			if iv != nil {
+				iv.received[key]++
+				a.add(iv, collection)
+			} else if len(open) > 0 {
+				// The collection's interval was already reported, so it's
+				// late.  Never re-open the interval: it'd be reported twice.
+				a.addLate(open[len(open)-1], collection)
+			} else {
+				// Count it in the next interval.
+				pendingLate = append(pendingLate, collection)
 			}
 
-			// Add each metric in the collection to its Stats.
-			for _, metric := range collection.Metrics {
-				stats, haveStats := is.Stats[metric.Name]
-				if !haveStats {
-					// New metric, create stats for it.
-					var err error
-					stats, err = NewStats(metric.Type)
-					if err != nil {
-						a.logger.Error(metric.Name, "invalid:", err.Error())
-						continue
-					}
-					is.Stats[metric.Name] = stats
-				}
-				stats.Add(&metric, collection.Ts)
+			// Process and spool intervals that are complete or past the grace
+			// window, oldest first.
+			for len(open) > 0 && a.done(open[0], collection.Ts, len(open)) {
+				a.report(open[0])
+				last = open[0].stats
+				lastTs = open[0].ts
+				open = open[1:]
 			}
 		case <-a.sync.StopChan:
 			return
@@ -154,21 +313,256 @@ func (a *Aggregator) run() {
 	}
 }
 
+/**
+ * addLate counts a collection for an already reported interval in cur, the
+ * latest interval, and adds its gauges to it.  Other metric types are dropped:
+ * an older counter or histogram value looks like a reset, and an older string
+ * would replace the latest one.
+ */
+// @goroutine[1]
+func (a *Aggregator) addLate(cur *openInterval, collection *Collection) {
+	t := GoTime(a.interval, (collection.Ts/a.interval)*a.interval)
+	a.logger.Info("Lost collection for interval", t, "; current interval is", cur.startTs)
+	cur.late[instanceKey(collection.ServiceInstance)]++
+	gauges := &Collection{
+		ServiceInstance: collection.ServiceInstance,
+		Ts:              collection.Ts,
+	}
+	for _, m := range collection.Metrics {
+		if m.Type == "gauge" {
+			gauges.Metrics = append(gauges.Metrics, m)
+		}
+	}
+	a.add(cur, gauges)
+}
+
 // @goroutine[1]
-func (a *Aggregator) report(startTs time.Time, is []*InstanceStats) {
-	a.logger.Debug("Summarize metrics for", startTs)
+func (a *Aggregator) add(iv *openInterval, collection *Collection) {
+	// Each collection is from a specific service instance.
+	// Find the stats for this instance, create if they don't exist.
+	var is *InstanceStats
+	for _, i := range iv.stats {
+		if collection.Service == i.Service && collection.InstanceId == i.InstanceId {
+			is = i
+			break
+		}
+	}
+
+	if is == nil {
+		// New service instance, create stats for it.
+		is = &InstanceStats{
+			ServiceInstance: proto.ServiceInstance{
+				Service:    collection.Service,
+				InstanceId: collection.InstanceId,
+			},
+			Stats: make(map[string]*Stats),
+		}
+		iv.stats = append(iv.stats, is)
+	}
+
+	hists, ok := a.lastHist[instanceKey(is.ServiceInstance)]
+	if !ok {
+		hists = make(map[string]*rawHistogram)
+		a.lastHist[instanceKey(is.ServiceInstance)] = hists
+	}
+
+	// Add each metric in the collection to its Stats.
+	for _, metric := range collection.Metrics {
+		stats, haveStats := is.Stats[metric.Name]
+		if !haveStats {
+			// New metric, create stats for it.
+			var err error
+			stats, err = NewStats(metric.Type)
+			if err != nil {
+				a.logger.Error(metric.Name, "invalid:", err.Error())
+				continue
+			}
+			is.Stats[metric.Name] = stats
+
+			// A histogram's first value in an interval is only its baseline,
+			// so start from the latest value in the previous interval, else
+			// values between the two are lost.  An older value is not used:
+			// the monitor was stopped or restarted.
+			if prev, ok := hists[metric.Name]; ok && metric.Type == "histogram" && prev.ts < collection.Ts && prev.ts >= iv.ts-a.interval {
+				stats.Add(&prev.metric, prev.ts)
+			}
+		}
+		stats.Add(&metric, collection.Ts)
+
+		if metric.Type == "histogram" {
+			if prev, ok := hists[metric.Name]; !ok || collection.Ts > prev.ts {
+				m := metric
+				m.Counts = append([]float64{}, metric.Counts...)
+				hists[metric.Name] = &rawHistogram{ts: collection.Ts, metric: m}
+			}
+		}
+	}
+}
+
+/**
+ * done returns true if the interval can be reported: now (the latest
+ * collection ts) is past the end of the interval plus the grace window,
+ * all expected collections for the interval have been received, or there
+ * are too many open intervals.
+ */
+// @goroutine[1]
+func (a *Aggregator) done(iv *openInterval, now int64, nOpen int) bool {
+	if nOpen > MAX_OPEN_INTERVALS {
+		return true
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	end := iv.ts + a.interval
+	if now >= end+a.grace {
+		return true
+	}
+
+	expected := make(map[string]uint)
+	var total uint
+	for _, e := range a.expected {
+		n := e.ticks(iv.ts, end)
+		expected[instanceKey(e.ServiceInstance)] += n
+		total += n
+	}
+	if total == 0 {
+		return false // nothing expected, wait for the grace window
+	}
+	for key, n := range expected {
+		if iv.received[key] < n {
+			return false
+		}
+	}
+	return true
+}
+
+// @goroutine[1]
+func (a *Aggregator) report(iv *openInterval) {
+	a.logger.Debug("Summarize metrics for", iv.startTs)
+	is := a.coverage(iv.startTs, iv.stats, iv.received, iv.late)
 	for _, i := range is {
 		for _, s := range i.Stats {
 			s.Summarize()
 		}
 	}
 	report := &Report{
-		Ts:       startTs,
+		Ts:       iv.startTs,
 		Duration: uint(a.interval),
 		Stats:    is,
 	}
 	if err := a.spool.Write("mm", report); err != nil {
 		a.logger.Warn("Lost report:", err)
+		pct.Metrics.Inc("mm/lost_reports", 1)
+	}
+	if a.alerter != nil {
+		a.alerter.Report(report)
+	}
+
+	a.mux.Lock()
+	rollups := a.rollups
+	a.mux.Unlock()
+	for _, r := range rollups {
+		rollupReports, err := r.add(report)
+		if err != nil {
+			a.logger.Warn(err)
+			continue
+		}
+		for _, rollupReport := range rollupReports {
+			if err := a.spool.Write("mm", rollupReport); err != nil {
+				a.logger.Warn("Lost rollup report:", err)
+				pct.Metrics.Inc("mm/lost_reports", 1)
+			}
+		}
+	}
+}
+
+/**
+ * coverage sets the Coverage of expected instances, adding ones that sent no
+ * collections, and adds stale Stats for metrics that an instance reported
+ * before but not in this interval.  A metric is stale for MAX_STALE_INTERVALS,
+ * then it's forgotten, else metrics that are gone would be reported forever.
+ */
+// @goroutine[1]
+func (a *Aggregator) coverage(startTs time.Time, is []*InstanceStats, received, late map[string]uint) []*InstanceStats {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	begin := startTs.Unix()
+	end := begin + a.interval
+	coverage := make(map[string]*Coverage)
+	for _, e := range a.expected {
+		key := instanceKey(e.ServiceInstance)
+		c, ok := coverage[key]
+		if !ok {
+			c = &Coverage{}
+			coverage[key] = c
+			have := false
+			for _, i := range is {
+				if instanceKey(i.ServiceInstance) == key {
+					have = true
+					break
+				}
+			}
+			if !have {
+				is = append(is, &InstanceStats{
+					ServiceInstance: proto.ServiceInstance{
+						Service:    e.Service,
+						InstanceId: e.InstanceId,
+					},
+					Stats: make(map[string]*Stats),
+				})
+			}
+		}
+		c.Expected += e.ticks(begin, end)
+	}
+
+	for _, i := range is {
+		key := instanceKey(i.ServiceInstance)
+		if c, ok := coverage[key]; ok {
+			c.Received = received[key]
+			c.Late = late[key] // gauges counted in this interval's stats, see run()
+			c.setMissing()
+			i.Coverage = c
+		}
+
+		known, ok := a.known[key]
+		if !ok {
+			known = make(map[string]*knownMetric)
+			a.known[key] = known
+		}
+		for metric, k := range known {
+			if _, ok := i.Stats[metric]; ok {
+				continue
+			}
+			if k.stale >= MAX_STALE_INTERVALS {
+				delete(known, metric)
+				continue
+			}
+			k.stale++
+			s, _ := NewStats(k.metricType)
+			s.Stale = true
+			i.Stats[metric] = s
+		}
+		for metric, s := range i.Stats {
+			if !s.Stale {
+				known[metric] = &knownMetric{metricType: s.metricType}
+			}
+		}
+	}
+	return is
+}
+
+// setMissing sets the reasons collections are missing.
+func (c *Coverage) setMissing() {
+	c.Missing = nil
+	if c.Received == 0 && c.Expected > 0 {
+		c.Missing = append(c.Missing, "No collections")
+	} else if c.Received < c.Expected {
+		c.Missing = append(c.Missing, fmt.Sprintf("%d of %d collections not received", c.Expected-c.Received, c.Expected))
+	}
+	if c.Late > 0 {
+		c.Missing = append(c.Missing, fmt.Sprintf("%d collections for past intervals arrived late", c.Late))
 	}
 }
 
@@ -0,0 +1,321 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mm
+
+import (
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/data"
+	"github.com/percona/percona-agent/pct"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+/**
+ * An AlertRule is a threshold on a metric, e.g. "mysql/threads_running Pct95
+ * > 50 for 3 intervals" is:
+ *
+ *   AlertRule{Name: "threads", Metric: "mysql/threads_running", Stat: "Pct95",
+ *             Op: ">", Value: 50, For: 3}
+ *
+ * Metric can be a path.Match pattern, e.g. net/eth?/rx_errors.  Rules are
+ * evaluated on each Report.  If Raw is true, the rule is evaluated on each
+ * Collection instead, using the raw value of gauge metrics (Stat is ignored),
+ * so For is a number of collections.  The alert opens when the condition is
+ * true For consecutive intervals, and resolves the first time it's false.
+ */
+type AlertRule struct {
+	Name       string
+	Service    string `json:",omitempty"` // any service if empty
+	InstanceId uint   `json:",omitempty"` // any instance if zero
+	Metric     string
+	Stat       string // Min, Pct5, Avg, Med, Pct95, Max, or Cnt
+	Op         string // >, >=, <, <=, ==, !=
+	Value      float64
+	For        uint // intervals, 0 = 1
+	Raw        bool `json:",omitempty"`
+}
+
+var AlertStats map[string]func(*Stats) float64 = map[string]func(*Stats) float64{
+	"Min":   func(s *Stats) float64 { return s.Min },
+	"Pct5":  func(s *Stats) float64 { return s.Pct5 },
+	"Avg":   func(s *Stats) float64 { return s.Avg },
+	"Med":   func(s *Stats) float64 { return s.Med },
+	"Pct95": func(s *Stats) float64 { return s.Pct95 },
+	"Max":   func(s *Stats) float64 { return s.Max },
+	"Cnt":   func(s *Stats) float64 { return float64(s.Cnt) },
+}
+
+var alertOps map[string]func(a, b float64) bool = map[string]func(a, b float64) bool{
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+func (r AlertRule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("Alert rule has no Name")
+	}
+	if r.Metric == "" {
+		return fmt.Errorf("Alert rule %s: no Metric", r.Name)
+	}
+	if _, err := path.Match(r.Metric, ""); err != nil {
+		return fmt.Errorf("Alert rule %s: invalid Metric: %s", r.Name, err)
+	}
+	if _, ok := AlertStats[r.Stat]; !ok && !r.Raw {
+		return fmt.Errorf("Alert rule %s: invalid Stat: %s", r.Name, r.Stat)
+	}
+	if _, ok := alertOps[r.Op]; !ok {
+		return fmt.Errorf("Alert rule %s: invalid Op: %s", r.Name, r.Op)
+	}
+	return nil
+}
+
+func (r AlertRule) String() string {
+	stat := r.Stat
+	if r.Raw {
+		stat = "value"
+	}
+	return fmt.Sprintf("%s %s %s %g for %d intervals", r.Metric, stat, r.Op, r.Value, r.forN())
+}
+
+func (r AlertRule) forN() uint {
+	if r.For == 0 {
+		return 1
+	}
+	return r.For
+}
+
+func (r AlertRule) matches(si proto.ServiceInstance, metric string) bool {
+	if r.Service != "" && r.Service != si.Service {
+		return false
+	}
+	if r.InstanceId != 0 && r.InstanceId != si.InstanceId {
+		return false
+	}
+	ok, _ := path.Match(r.Metric, metric)
+	return ok
+}
+
+const (
+	ALERT_OPEN     = "open"
+	ALERT_RESOLVED = "resolved"
+)
+
+// An Alert is spooled as "mm-alert" data when an alert opens or resolves.
+type Alert struct {
+	proto.ServiceInstance
+	Ts     time.Time // UTC
+	Rule   string    // AlertRule.Name
+	Metric string
+	State  string  // open or resolved
+	Value  float64 // value that opened or resolved the alert
+	Cond   string  // AlertRule.String()
+}
+
+type alertState struct {
+	rule   AlertRule
+	si     proto.ServiceInstance
+	metric string
+	n      uint // consecutive intervals the condition was true
+	open   bool
+	val    float64 // last value
+}
+
+/**
+ * Alerter evaluates alert rules.  One Alerter is shared by all aggregators,
+ * so it's guarded by a mutex.  Alert state is kept per rule, service instance,
+ * and metric.  An open alert resolves when its metric disappears, i.e. the
+ * aggregator reports it as stale, or when its rule is removed or changed.
+ */
+type Alerter struct {
+	logger *pct.Logger
+	spool  data.Spooler
+	// --
+	rules []AlertRule
+	state map[string]*alertState
+	mux   *sync.Mutex
+}
+
+func NewAlerter(logger *pct.Logger, spool data.Spooler) *Alerter {
+	a := &Alerter{
+		logger: logger,
+		spool:  spool,
+		// --
+		rules: []AlertRule{},
+		state: make(map[string]*alertState),
+		mux:   &sync.Mutex{},
+	}
+	return a
+}
+
+// SetRules validates and sets the rules.  The alert state of unchanged rules
+// is kept.  Open alerts of removed or changed rules are resolved; changed rules
+// re-open if they're still true.
+func (a *Alerter) SetRules(rules []AlertRule) error {
+	names := make(map[string]AlertRule)
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		if _, ok := names[r.Name]; ok {
+			return fmt.Errorf("Duplicate alert rule: %s", r.Name)
+		}
+		names[r.Name] = r
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.rules = rules
+	ts := time.Now().UTC()
+	keys := make([]string, 0, len(a.state))
+	for key := range a.state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // alerts in a consistent order
+	for _, key := range keys {
+		s := a.state[key]
+		if r, ok := names[s.rule.Name]; ok && r == s.rule {
+			continue
+		}
+		if s.open {
+			a.resolve(s, "rule changed", ts)
+		}
+		delete(a.state, key)
+	}
+	return nil
+}
+
+func (a *Alerter) Rules() []AlertRule {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.rules
+}
+
+// Report evaluates non-raw rules on the summarized stats in the report, and
+// resolves open alerts, raw or not, whose metric is stale.
+func (a *Alerter) Report(report *Report) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	for _, r := range a.rules {
+		for _, is := range report.Stats {
+			metrics := make([]string, 0, len(is.Stats))
+			for metric := range is.Stats {
+				metrics = append(metrics, metric)
+			}
+			sort.Strings(metrics) // alerts in a consistent order
+			for _, metric := range metrics {
+				stats := is.Stats[metric]
+				if !r.matches(is.ServiceInstance, metric) {
+					continue
+				}
+				if stats.Stale {
+					// The metric disappeared, so its alert can't resolve by itself.
+					if s, ok := a.state[stateKey(r, is.ServiceInstance, metric)]; ok && s.open {
+						s.n = 0
+						a.resolve(s, "metric not reported", report.Ts)
+					}
+					continue
+				}
+				if r.Raw || (stats.metricType != "gauge" && stats.metricType != "counter") {
+					continue
+				}
+				if stats.Cnt == 0 {
+					continue // no values this interval, so nothing to evaluate
+				}
+				a.eval(r, is.ServiceInstance, metric, AlertStats[r.Stat](stats), report.Ts)
+			}
+		}
+	}
+}
+
+// Collection evaluates raw rules on the gauge metrics in the collection.
+func (a *Alerter) Collection(c *Collection) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	ts := time.Unix(c.Ts, 0).UTC()
+	for _, r := range a.rules {
+		if !r.Raw {
+			continue
+		}
+		for _, m := range c.Metrics {
+			if m.Type != "gauge" || !r.matches(c.ServiceInstance, m.Name) {
+				continue
+			}
+			a.eval(r, c.ServiceInstance, m.Name, m.Number, ts)
+		}
+	}
+}
+
+func stateKey(r AlertRule, si proto.ServiceInstance, metric string) string {
+	return fmt.Sprintf("%s %s-%d %s", r.Name, si.Service, si.InstanceId, metric)
+}
+
+func (a *Alerter) eval(r AlertRule, si proto.ServiceInstance, metric string, val float64, ts time.Time) {
+	key := stateKey(r, si, metric)
+	s, ok := a.state[key]
+	if !ok {
+		s = &alertState{rule: r, si: si, metric: metric}
+		a.state[key] = s
+	}
+	s.val = val
+	if alertOps[r.Op](val, r.Value) {
+		s.n++
+		if s.open || s.n < r.forN() {
+			return
+		}
+		s.open = true
+		a.logger.Warn(fmt.Sprintf("Alert %s open: %s %s-%d: %s (value %g)", r.Name, metric, si.Service, si.InstanceId, r, val))
+		a.send(r, si, metric, ALERT_OPEN, val, ts)
+	} else {
+		s.n = 0
+		if !s.open {
+			return
+		}
+		s.open = false
+		a.logger.Info(fmt.Sprintf("Alert %s resolved: %s %s-%d (value %g)", r.Name, metric, si.Service, si.InstanceId, val))
+		a.send(r, si, metric, ALERT_RESOLVED, val, ts)
+	}
+}
+
+// resolve resolves an open alert for why, e.g. its rule was removed.  The
+// value is the last one evaluated.
+func (a *Alerter) resolve(s *alertState, why string, ts time.Time) {
+	s.open = false
+	a.logger.Info(fmt.Sprintf("Alert %s resolved (%s): %s %s-%d", s.rule.Name, why, s.metric, s.si.Service, s.si.InstanceId))
+	a.send(s.rule, s.si, s.metric, ALERT_RESOLVED, s.val, ts)
+}
+
+func (a *Alerter) send(r AlertRule, si proto.ServiceInstance, metric, state string, val float64, ts time.Time) {
+	alert := &Alert{
+		ServiceInstance: si,
+		Ts:              ts,
+		Rule:            r.Name,
+		Metric:          metric,
+		State:           state,
+		Value:           val,
+		Cond:            r.String(),
+	}
+	if err := a.spool.Write("mm-alert", alert); err != nil {
+		a.logger.Warn("Lost alert:", err)
+	}
+}
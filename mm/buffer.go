@@ -0,0 +1,138 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mm
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+// Number of recent metrics each aggregator keeps for GetMetrics.  Collections
+// vary from a few metrics to thousands (e.g. Performance Schema), so the buffer
+// is capped by metrics, not collections.  At the usual 1s collect interval for
+// a few monitors, it's several minutes.
+const COLLECTION_BUFFER_SIZE = 100000
+
+// A MetricsQuery is the data for the GetMetrics command.
+type MetricsQuery struct {
+	Service    string    `json:",omitempty"` // any service if empty
+	InstanceId uint      `json:",omitempty"` // any instance if zero
+	Metric     string    `json:",omitempty"` // path.Match pattern, all metrics if empty
+	Begin      time.Time `json:",omitempty"` // inclusive, oldest if zero
+	End        time.Time `json:",omitempty"` // inclusive, newest if zero
+}
+
+func (q MetricsQuery) Validate() error {
+	if _, err := path.Match(q.Metric, ""); err != nil {
+		return fmt.Errorf("Invalid Metric: %s", err)
+	}
+	if !q.Begin.IsZero() && !q.End.IsZero() && q.End.Before(q.Begin) {
+		return fmt.Errorf("End is before Begin")
+	}
+	return nil
+}
+
+/**
+ * CollectionBuffer is a buffer of the most recent raw collections that an
+ * aggregator received, so they can be queried on demand without waiting for
+ * a report.  It holds at most size metrics in total.  Collections are not
+ * changed once sent, so they're shared, not copied.
+ */
+type CollectionBuffer struct {
+	buf     []*Collection // oldest first
+	size    int           // max metrics
+	metrics int           // metrics in buf
+	mux     *sync.Mutex
+}
+
+func NewCollectionBuffer(size int) *CollectionBuffer {
+	b := &CollectionBuffer{
+		buf:  []*Collection{},
+		size: size,
+		mux:  &sync.Mutex{},
+	}
+	return b
+}
+
+// Add a collection, removing the oldest ones until the buffer has at most
+// size metrics.  The newest collection is always kept, even if it's bigger.
+func (b *CollectionBuffer) Add(c *Collection) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.size <= 0 {
+		return
+	}
+	b.buf = append(b.buf, c)
+	b.metrics += len(c.Metrics)
+	for b.metrics > b.size && len(b.buf) > 1 {
+		b.metrics -= len(b.buf[0].Metrics)
+		b.buf[0] = nil // let it be garbage-collected
+		b.buf = b.buf[1:]
+	}
+}
+
+/**
+ * Get returns the collections that match the query, oldest first.  Returned
+ * collections only have the metrics that match; collections without any
+ * aren't returned.
+ */
+func (b *CollectionBuffer) Get(q MetricsQuery) []*Collection {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	var begin, end int64
+	if !q.Begin.IsZero() {
+		begin = q.Begin.Unix()
+	}
+	if !q.End.IsZero() {
+		end = q.End.Unix()
+	}
+
+	got := []*Collection{}
+	for _, c := range b.buf {
+		if q.Service != "" && q.Service != c.Service {
+			continue
+		}
+		if q.InstanceId != 0 && q.InstanceId != c.InstanceId {
+			continue
+		}
+		if (begin != 0 && c.Ts < begin) || (end != 0 && c.Ts > end) {
+			continue
+		}
+		if q.Metric == "" {
+			got = append(got, c)
+			continue
+		}
+		metrics := []Metric{}
+		for _, m := range c.Metrics {
+			if ok, _ := path.Match(q.Metric, m.Name); ok {
+				metrics = append(metrics, m)
+			}
+		}
+		if len(metrics) > 0 {
+			got = append(got, &Collection{
+				ServiceInstance: c.ServiceInstance,
+				Ts:              c.Ts,
+				Metrics:         metrics,
+			})
+		}
+	}
+	return got
+}
@@ -0,0 +1,222 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/percona/percona-agent/mm"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Max bytes of stderr kept for the error message if a command fails.
+const maxStderr = 1024
+
+/**
+ * Run runs the command and returns its metrics.  The command runs in its own
+ * process group so that killing it on timeout also kills its children, e.g.
+ * a sleep in a shell script.  Else a child that inherited stdout keeps the
+ * pipe open and we'd wait for it.
+ */
+func Run(c Command) ([]mm.Metric, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+	maxOutput := c.MaxOutput
+	if maxOutput == 0 {
+		maxOutput = DEFAULT_MAX_OUTPUT
+	}
+
+	cmd := exec.Command(c.Path, c.Args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stderr := &capWriter{max: maxStderr}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer timer.Stop()
+
+	// Read one byte more than the max to know if the output is too big.
+	outputChan := make(chan []byte, 1)
+	go func() {
+		output, _ := ioutil.ReadAll(io.LimitReader(stdout, int64(maxOutput)+1))
+		outputChan <- output
+	}()
+
+	var output []byte
+	select {
+	case output = <-outputChan:
+	case <-timer.C:
+		kill(cmd)
+		<-outputChan
+		cmd.Wait()
+		return nil, fmt.Errorf("Timeout after %ds", timeout)
+	}
+	if uint(len(output)) > maxOutput {
+		kill(cmd)
+		cmd.Wait()
+		return nil, fmt.Errorf("Output is more than %d bytes", maxOutput)
+	}
+
+	// Stdout is closed but the command may still be running.
+	waitChan := make(chan error, 1)
+	go func() {
+		waitChan <- cmd.Wait()
+	}()
+	select {
+	case err := <-waitChan:
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, fmt.Errorf("%s: %s", err, msg)
+			}
+			return nil, err
+		}
+	case <-timer.C:
+		kill(cmd)
+		<-waitChan
+		return nil, fmt.Errorf("Timeout after %ds", timeout)
+	}
+
+	if c.Format == "json" {
+		return ParseJSON(c.Name, output)
+	}
+	return ParseText(c.Name, output)
+}
+
+// kill kills the command's process group.
+func kill(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+/**
+ * ParseText parses "name type value" lines.  Fields are separated by any
+ * spaces or tabs.  Blank lines and lines starting with # are ignored.  For
+ * string metrics the value is the rest of the line, as is.
+ */
+func ParseText(prefix string, output []byte) ([]mm.Metric, error) {
+	metrics := []mm.Metric{}
+	lines := strings.Split(string(output), "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("Line %d: expected 'name type value', got '%s'", i+1, line)
+		}
+		name, metricType := fields[0], fields[1]
+		// The value is the remainder after name and type, with its own spacing.
+		value := strings.TrimSpace(line[len(name):])
+		value = strings.TrimSpace(value[len(metricType):])
+		metric, err := newMetric(prefix, name, metricType, value)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", i+1, err)
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// ParseJSON parses an array of {"Name": "...", "Type": "...", "Value": ...}.
+func ParseJSON(prefix string, output []byte) ([]mm.Metric, error) {
+	var values []struct {
+		Name  string
+		Type  string
+		Value interface{}
+	}
+	if err := json.Unmarshal(output, &values); err != nil {
+		return nil, err
+	}
+	metrics := []mm.Metric{}
+	for i, v := range values {
+		var value string
+		switch val := v.Value.(type) {
+		case float64:
+			value = strconv.FormatFloat(val, 'f', -1, 64)
+		case string:
+			value = val
+		case bool:
+			value = "0"
+			if val {
+				value = "1"
+			}
+		default:
+			return nil, fmt.Errorf("Metric %d: invalid value: %v", i+1, v.Value)
+		}
+		metric, err := newMetric(prefix, v.Name, v.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("Metric %d: %s", i+1, err)
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+func newMetric(prefix, name, metricType, value string) (mm.Metric, error) {
+	metric := mm.Metric{Name: prefix + "/" + strings.Trim(name, "/"), Type: metricType}
+	if name == "" {
+		return metric, fmt.Errorf("no metric name")
+	}
+	if !mm.MetricTypes[metricType] || metricType == "histogram" {
+		return metric, fmt.Errorf("%s: invalid metric type: %s", name, metricType)
+	}
+	if metricType == "string" {
+		metric.String = value
+		return metric, nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return metric, fmt.Errorf("%s: value is not a number: %s", name, value)
+	}
+	metric.Number = n
+	return metric, nil
+}
+
+// capWriter keeps the first max bytes written to it and discards the rest.
+type capWriter struct {
+	buf []byte
+	max int
+}
+
+func (w *capWriter) Write(p []byte) (int, error) {
+	if n := w.max - len(w.buf); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+	}
+	return len(p), nil
+}
+
+func (w *capWriter) String() string {
+	return string(w.buf)
+}
@@ -0,0 +1,211 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package command_test
+
+import (
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/command"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/test"
+	. "launchpad.net/gocheck"
+	"testing"
+	"time"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type CommandTestSuite struct {
+	logChan        chan *proto.LogEntry
+	logger         *pct.Logger
+	tickChan       chan time.Time
+	collectionChan chan *mm.Collection
+}
+
+var _ = Suite(&CommandTestSuite{})
+
+func (s *CommandTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 1000)
+	s.logger = pct.NewLogger(s.logChan, "mm-command-test")
+	s.tickChan = make(chan time.Time)
+	s.collectionChan = make(chan *mm.Collection, 1)
+}
+
+func sh(name, script string) command.Command {
+	return command.Command{
+		Name: name,
+		Path: "/bin/sh",
+		Args: []string{"-c", script},
+	}
+}
+
+// --------------------------------------------------------------------------
+
+func (s *CommandTestSuite) TestParseText(t *C) {
+	output := []byte(`
+# RAID controller 0
+ctrl0/degraded gauge 1
+ctrl0/media_errors counter 42
+ctrl0/state string Optimal, 2 of 2 drives online
+`)
+	got, err := command.ParseText("raid", output)
+	t.Assert(err, IsNil)
+	expect := []mm.Metric{
+		{Name: "raid/ctrl0/degraded", Type: "gauge", Number: 1},
+		{Name: "raid/ctrl0/media_errors", Type: "counter", Number: 42},
+		{Name: "raid/ctrl0/state", Type: "string", String: "Optimal, 2 of 2 drives online"},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	// Tabs and runs of spaces, e.g. from printf or column alignment.
+	output = []byte("ctrl0/degraded\tgauge\t1\nctrl0/media_errors   counter    42\nctrl0/state \t string  Optimal,  2 of 2\n")
+	got, err = command.ParseText("raid", output)
+	t.Assert(err, IsNil)
+	expect = []mm.Metric{
+		{Name: "raid/ctrl0/degraded", Type: "gauge", Number: 1},
+		{Name: "raid/ctrl0/media_errors", Type: "counter", Number: 42},
+		{Name: "raid/ctrl0/state", Type: "string", String: "Optimal,  2 of 2"},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	_, err = command.ParseText("raid", []byte("ctrl0/degraded 1\n"))
+	t.Check(err, NotNil)
+
+	_, err = command.ParseText("raid", []byte("ctrl0/degraded histogram 1\n"))
+	t.Check(err, NotNil)
+
+	_, err = command.ParseText("raid", []byte("ctrl0/degraded gauge yes\n"))
+	t.Check(err, NotNil)
+}
+
+func (s *CommandTestSuite) TestParseJSON(t *C) {
+	output := []byte(`[
+		{"Name": "age", "Type": "gauge", "Value": 3600.5},
+		{"name": "ok", "type": "gauge", "value": true},
+		{"Name": "last", "Type": "string", "Value": "xtrabackup full"}
+	]`)
+	got, err := command.ParseJSON("backup", output)
+	t.Assert(err, IsNil)
+	expect := []mm.Metric{
+		{Name: "backup/age", Type: "gauge", Number: 3600.5},
+		{Name: "backup/ok", Type: "gauge", Number: 1},
+		{Name: "backup/last", Type: "string", String: "xtrabackup full"},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	_, err = command.ParseJSON("backup", []byte(`{"Name": "age"}`))
+	t.Check(err, NotNil)
+
+	_, err = command.ParseJSON("backup", []byte(`[{"Name": "age", "Type": "gauge", "Value": null}]`))
+	t.Check(err, NotNil)
+}
+
+func (s *CommandTestSuite) TestRun(t *C) {
+	got, err := command.Run(sh("test", "echo 'a gauge 1'; echo 'b counter 2'"))
+	t.Assert(err, IsNil)
+	expect := []mm.Metric{
+		{Name: "test/a", Type: "gauge", Number: 1},
+		{Name: "test/b", Type: "counter", Number: 2},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	cmd := sh("test", `echo '[{"Name": "a", "Type": "gauge", "Value": 1}]'`)
+	cmd.Format = "json"
+	got, err = command.Run(cmd)
+	t.Assert(err, IsNil)
+	t.Check(got, DeepEquals, []mm.Metric{{Name: "test/a", Type: "gauge", Number: 1}})
+
+	// Exit status and stderr are returned as the error.
+	_, err = command.Run(sh("test", "echo 'a gauge 1'; echo 'controller not found' >&2; exit 2"))
+	t.Assert(err, NotNil)
+	t.Check(err.Error(), Matches, ".*exit status 2: controller not found")
+}
+
+func (s *CommandTestSuite) TestTimeout(t *C) {
+	// The sleep is a child of the shell, so it must be killed too, else it
+	// keeps stdout open and Run waits for it.
+	cmd := sh("test", "echo 'a gauge 1'; sleep 30")
+	cmd.Timeout = 1
+	start := time.Now()
+	_, err := command.Run(cmd)
+	t.Check(err, ErrorMatches, "Timeout after 1s")
+	t.Check(time.Now().Sub(start) < 5*time.Second, Equals, true)
+}
+
+func (s *CommandTestSuite) TestMaxOutput(t *C) {
+	cmd := sh("test", "while true; do echo 'a gauge 1'; done")
+	cmd.MaxOutput = 100
+	_, err := command.Run(cmd)
+	t.Check(err, ErrorMatches, "Output is more than 100 bytes")
+}
+
+func (s *CommandTestSuite) TestInvalidConfig(t *C) {
+	config := &command.Config{
+		Commands: []command.Command{{Name: "test", Path: "bin/check_raid"}},
+	}
+	m := command.NewMonitor("mm-command-test", config, s.logger)
+	err := m.Start(s.tickChan, s.collectionChan)
+	t.Check(err, NotNil)
+
+	config.Commands = []command.Command{{Name: "test", Path: "/bin/true", Format: "xml"}}
+	err = m.Start(s.tickChan, s.collectionChan)
+	t.Check(err, NotNil)
+}
+
+func (s *CommandTestSuite) TestStartCollectStop(t *C) {
+	config := &command.Config{
+		Commands: []command.Command{
+			sh("a", "echo 'x gauge 1'"),
+			sh("b", "exit 1"),
+			sh("c", "echo 'y counter 2'"),
+		},
+	}
+	m := command.NewMonitor("mm-command-test", config, s.logger)
+	err := m.Start(s.tickChan, s.collectionChan)
+	t.Assert(err, IsNil)
+
+	// A failed command doesn't stop the others.
+	now := time.Now()
+	s.tickChan <- now
+	got := test.WaitCollection(s.collectionChan, 1)
+	t.Assert(got, HasLen, 1)
+	t.Check(got[0].Ts, Equals, now.UTC().Unix())
+	expect := []mm.Metric{
+		{Name: "a/x", Type: "gauge", Number: 1},
+		{Name: "c/y", Type: "counter", Number: 2},
+	}
+	if same, diff := test.IsDeeply(got[0].Metrics, expect); !same {
+		test.Dump(got[0].Metrics)
+		t.Error(diff)
+	}
+
+	err = m.Stop()
+	t.Check(err, IsNil)
+}
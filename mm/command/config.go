@@ -0,0 +1,66 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package command
+
+import (
+	"fmt"
+	"github.com/percona/percona-agent/mm"
+	"path/filepath"
+)
+
+const (
+	DEFAULT_TIMEOUT    = 10        // seconds
+	DEFAULT_MAX_OUTPUT = 64 * 1024 // bytes
+)
+
+type Config struct {
+	mm.Config
+	Commands []Command
+}
+
+/**
+ * A command run every collect interval.  It prints one metric per line on
+ * stdout: "name type value", e.g. "ctrl0/degraded gauge 1", or a JSON array
+ * like [{"Name": "ctrl0/degraded", "Type": "gauge", "Value": 1}] if Format is
+ * json.  Metric names are prefixed with the command's Name, e.g. raid/ctrl0/degraded.
+ * The command is killed if it runs longer than Timeout or prints more than
+ * MaxOutput bytes.
+ */
+type Command struct {
+	Name      string // metric name prefix
+	Path      string // absolute path, not run in a shell
+	Args      []string
+	Format    string // text (default) or json
+	Timeout   uint   // seconds, 0 = DEFAULT_TIMEOUT
+	MaxOutput uint   // bytes, 0 = DEFAULT_MAX_OUTPUT
+}
+
+func (c Command) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("Command has no Name")
+	}
+	if !filepath.IsAbs(c.Path) {
+		return fmt.Errorf("Command %s: Path is not absolute: %s", c.Name, c.Path)
+	}
+	switch c.Format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("Command %s: invalid Format: %s", c.Name, c.Format)
+	}
+	return nil
+}
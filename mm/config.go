@@ -18,6 +18,7 @@
 package mm
 
 import (
+	"fmt"
 	"github.com/percona/cloud-protocol/proto"
 )
 
@@ -28,7 +29,57 @@ import (
  */
 
 type Config struct {
-	proto.ServiceInstance      // info about external service being monitored
-	Collect               uint // how often monitor collects metrics (seconds)
-	Report                uint // how often aggregator reports metrics (seconds)
+	proto.ServiceInstance        // info about external service being monitored
+	Collect               uint   // how often monitor collects metrics (seconds)
+	Report                uint   // how often aggregator reports metrics (seconds)
+	Monitor               string `json:",omitempty"` // monitor type if not the service's default, e.g. query
+	Grace                 uint   `json:",omitempty"` // how long aggregator waits for late collections (seconds)
+	// Metric rules applied to collections before the aggregator, see MetricFilter:
+	MetricInclude []string       `json:",omitempty"` // regexp
+	MetricExclude []string       `json:",omitempty"` // regexp
+	MetricRename  []MetricRename `json:",omitempty"`
+	MaxMetrics    uint           `json:",omitempty"` // max distinct metric names, 0 = no max
+	// Metrics computed from others in the same collection, see DerivedMetric:
+	Derived []DerivedMetric `json:",omitempty"`
+	// Longer report intervals (seconds) rolled up from Report, e.g. 300 and 3600:
+	Rollup []uint `json:",omitempty"`
+}
+
+// Rename metrics matching regexp Match to Replace which can have $1, etc.
+type MetricRename struct {
+	Match   string
+	Replace string
+}
+
+/**
+ * A metric computed from other metrics by an arithmetic expression, e.g.
+ * buffer pool hit rate:
+ *
+ *   DerivedMetric{
+ *     Name: "mysql/innodb_buffer_pool_hit_rate",
+ *     Expr: "1 - mysql/innodb_buffer_pool_reads / mysql/innodb_buffer_pool_read_requests",
+ *   }
+ *
+ * Expr can have numbers, metric names, + - * / and parentheses.  Slashes are
+ * part of metric names, so division needs a space before or after the /.
+ */
+type DerivedMetric struct {
+	Name string
+	Expr string
+}
+
+// ValidateRollup returns an error unless each Rollup interval is a larger
+// multiple of Report.
+func (c Config) ValidateRollup() error {
+	for _, r := range c.Rollup {
+		if c.Report == 0 || r <= c.Report || r%c.Report != 0 {
+			return fmt.Errorf("Invalid rollup interval %d: must be a multiple of the %d second report interval", r, c.Report)
+		}
+	}
+	return nil
+}
+
+func (c Config) HasMetricRules() bool {
+	return len(c.MetricInclude) > 0 || len(c.MetricExclude) > 0 || len(c.MetricRename) > 0 || c.MaxMetrics > 0 ||
+		len(c.Derived) > 0
 }
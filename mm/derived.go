@@ -0,0 +1,335 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+/**
+ * derivedMetric is a parsed DerivedMetric.  Its type is inferred from the
+ * metrics in the expression:
+ *
+ *   - Only gauges: the expression is evaluated on their values; gauge.
+ *   - Only counters added or subtracted, e.g. "a + b - c": the expression is
+ *     evaluated on their values; counter, so the aggregator reports its rate.
+ *   - Otherwise, counters are their per-second rate since the previous
+ *     collection, e.g. "a / b" is the ratio of a and b during the collect
+ *     interval; gauge.
+ *
+ * No value is reported if a metric isn't in the collection, a counter has no
+ * previous value or it was reset (decreased), or the expression divides by
+ * zero.
+ */
+type derivedMetric struct {
+	name   string
+	root   exprNode
+	refs   []string // metric names in the expression, unique
+	linear bool     // only +, -, and metrics
+}
+
+// A counter's previous value for derived metrics that use its rate.
+type counterVal struct {
+	ts  int64
+	val float64
+}
+
+func newDerivedMetric(d DerivedMetric) (*derivedMetric, error) {
+	if d.Name == "" {
+		return nil, fmt.Errorf("Derived metric has no Name")
+	}
+	p := &exprParser{s: d.Expr}
+	root, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("Derived metric %s: %s", d.Name, err)
+	}
+	dm := &derivedMetric{
+		name:   d.Name,
+		root:   root,
+		linear: root.linear(),
+	}
+	seen := make(map[string]bool)
+	for _, ref := range p.refs {
+		if !seen[ref] {
+			dm.refs = append(dm.refs, ref)
+			seen[ref] = true
+		}
+	}
+	if len(dm.refs) == 0 {
+		return nil, fmt.Errorf("Derived metric %s: no metrics in expression", d.Name)
+	}
+	return dm, nil
+}
+
+// eval returns the derived metric for the collection's metrics, keyed on
+// name, and false if it has no value.  prev is the counters' values from the
+// previous collection.
+func (d *derivedMetric) eval(ts int64, metrics map[string]Metric, prev map[string]counterVal) (Metric, bool) {
+	allCounters := true
+	anyCounter := false
+	for _, ref := range d.refs {
+		m, ok := metrics[ref]
+		if !ok {
+			return Metric{}, false
+		}
+		if m.Type == "counter" {
+			anyCounter = true
+		} else {
+			allCounters = false
+		}
+	}
+
+	vals := make(map[string]float64, len(d.refs))
+	metricType := "gauge"
+	if anyCounter && allCounters && d.linear {
+		metricType = "counter"
+	}
+	for _, ref := range d.refs {
+		m := metrics[ref]
+		if m.Type != "counter" || metricType == "counter" {
+			vals[ref] = m.Number
+			continue
+		}
+		p, ok := prev[ref]
+		if !ok || ts <= p.ts || m.Number < p.val {
+			return Metric{}, false // first value or reset
+		}
+		vals[ref] = (m.Number - p.val) / float64(ts-p.ts)
+	}
+
+	val, ok := d.root.eval(vals)
+	if !ok || math.IsNaN(val) || math.IsInf(val, 0) {
+		return Metric{}, false
+	}
+	return Metric{Name: d.name, Type: metricType, Number: val}, true
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Expressions
+/////////////////////////////////////////////////////////////////////////////
+
+type exprNode interface {
+	eval(vals map[string]float64) (float64, bool) // false on division by zero
+	linear() bool
+}
+
+type numNode float64
+
+func (n numNode) eval(vals map[string]float64) (float64, bool) { return float64(n), true }
+func (n numNode) linear() bool                                 { return false }
+
+type refNode string
+
+func (n refNode) eval(vals map[string]float64) (float64, bool) { return vals[string(n)], true }
+func (n refNode) linear() bool                                 { return true }
+
+type negNode struct {
+	x exprNode
+}
+
+func (n negNode) eval(vals map[string]float64) (float64, bool) {
+	x, ok := n.x.eval(vals)
+	return -x, ok
+}
+func (n negNode) linear() bool { return n.x.linear() }
+
+type binNode struct {
+	op   byte
+	l, r exprNode
+}
+
+func (n binNode) eval(vals map[string]float64) (float64, bool) {
+	l, ok := n.l.eval(vals)
+	if !ok {
+		return 0, false
+	}
+	r, ok := n.r.eval(vals)
+	if !ok {
+		return 0, false
+	}
+	switch n.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	default: // '/'
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	}
+}
+
+func (n binNode) linear() bool {
+	return (n.op == '+' || n.op == '-') && n.l.linear() && n.r.linear()
+}
+
+/**
+ * exprParser is a recursive descent parser for:
+ *
+ *   expr   = term { ("+" | "-") term }
+ *   term   = factor { ("*" | "/") factor }
+ *   factor = number | metric | "-" factor | "(" expr ")"
+ *
+ * A metric name starts with a letter or _ and has letters, digits, and
+ * _ . : / but a / must be followed by one of those, else it's division.
+ */
+type exprParser struct {
+	s    string
+	pos  int
+	refs []string
+}
+
+func (p *exprParser) parse() (exprNode, error) {
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.s[p.pos], p.pos+1)
+	}
+	return n, nil
+}
+
+func (p *exprParser) expr() (exprNode, error) {
+	l, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.space()
+		if p.pos >= len(p.s) || (p.s[p.pos] != '+' && p.s[p.pos] != '-') {
+			return l, nil
+		}
+		op := p.s[p.pos]
+		p.pos++
+		r, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		l = binNode{op, l, r}
+	}
+}
+
+func (p *exprParser) term() (exprNode, error) {
+	l, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.space()
+		if p.pos >= len(p.s) || (p.s[p.pos] != '*' && p.s[p.pos] != '/') {
+			return l, nil
+		}
+		op := p.s[p.pos]
+		p.pos++
+		r, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		l = binNode{op, l, r}
+	}
+}
+
+func (p *exprParser) factor() (exprNode, error) {
+	p.space()
+	if p.pos >= len(p.s) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	c := p.s[p.pos]
+	switch {
+	case c == '-':
+		p.pos++
+		x, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return negNode{x}, nil
+	case c == '(':
+		p.pos++
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		p.space()
+		if p.pos >= len(p.s) || p.s[p.pos] != ')' {
+			return nil, fmt.Errorf("missing ) at position %d", p.pos+1)
+		}
+		p.pos++
+		return x, nil
+	case isDigit(c) || c == '.':
+		start := p.pos
+		for p.pos < len(p.s) && (isDigit(p.s[p.pos]) || p.s[p.pos] == '.') {
+			p.pos++
+		}
+		if p.pos < len(p.s) && (p.s[p.pos] == 'e' || p.s[p.pos] == 'E') {
+			p.pos++
+			if p.pos < len(p.s) && (p.s[p.pos] == '+' || p.s[p.pos] == '-') {
+				p.pos++
+			}
+			for p.pos < len(p.s) && isDigit(p.s[p.pos]) {
+				p.pos++
+			}
+		}
+		n, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number at position %d: %s", start+1, p.s[start:p.pos])
+		}
+		return numNode(n), nil
+	case isLetter(c):
+		start := p.pos
+		for p.pos < len(p.s) {
+			c := p.s[p.pos]
+			if c == '/' && p.pos+1 < len(p.s) && isNameChar(p.s[p.pos+1]) {
+				p.pos++
+				continue
+			}
+			if !isNameChar(c) {
+				break
+			}
+			p.pos++
+		}
+		name := p.s[start:p.pos]
+		p.refs = append(p.refs, name)
+		return refNode(name), nil
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", c, p.pos+1)
+}
+
+func (p *exprParser) space() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+}
+
+func isNameChar(c byte) bool {
+	return isLetter(c) || isDigit(c) || c == '.' || c == ':'
+}
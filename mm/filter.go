@@ -0,0 +1,320 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mm
+
+import (
+	"fmt"
+	"github.com/percona/percona-agent/pct"
+	"math"
+	"regexp"
+	"sort"
+	"time"
+)
+
+type metricRename struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+/**
+ * MetricFilter applies a monitor's metric rules (Config.MetricInclude, etc.)
+ * to its collections before they reach the aggregator.  Metric names are
+ * first matched against MetricInclude and MetricExclude, then renamed by
+ * the first MetricRename rule that matches, if any.  If there
+ * are more than MaxMetrics distinct names, only the top MaxMetrics by activity
+ * are kept.  Activity is how much a metric's value changed during the last
+ * report interval, so the top metrics are re-selected every report interval.
+ * Before the first report interval ends, the first MaxMetrics names are kept.
+ * Derived metrics are computed from the collected metrics before the rules are
+ * applied, and they're always reported.
+ */
+type MetricFilter struct {
+	logger *pct.Logger
+	// --
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	rename  []metricRename
+	max     int
+	window  int64 // report interval (seconds)
+	derived []*derivedMetric
+	// --
+	collectionChan chan *Collection // <- metrics from monitor
+	outChan        chan *Collection // -> filtered metrics to aggregator
+	sync           *pct.SyncChan
+	// Top metrics by activity:
+	curWindow int64
+	selected  map[string]bool
+	firstCome bool               // no activity yet, select first max metrics
+	activity  map[string]float64 // this window
+	prev      map[string]float64 // last value
+	capped    bool
+	// Previous values of counters in derived metrics:
+	counters map[string]counterVal
+}
+
+func NewMetricFilter(logger *pct.Logger, config Config) (*MetricFilter, error) {
+	f := &MetricFilter{
+		logger:  logger,
+		include: make([]*regexp.Regexp, len(config.MetricInclude)),
+		exclude: make([]*regexp.Regexp, len(config.MetricExclude)),
+		rename:  make([]metricRename, len(config.MetricRename)),
+		max:     int(config.MaxMetrics),
+		window:  int64(config.Report),
+		// --
+		collectionChan: make(chan *Collection, 5),
+		sync:           pct.NewSyncChan(),
+		selected:       make(map[string]bool),
+		firstCome:      true,
+		activity:       make(map[string]float64),
+		prev:           make(map[string]float64),
+		counters:       make(map[string]counterVal),
+	}
+	if f.window <= 0 {
+		f.window = 60
+	}
+	for i, pattern := range config.MetricInclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("MetricInclude: %s", err)
+		}
+		f.include[i] = re
+	}
+	for i, pattern := range config.MetricExclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("MetricExclude: %s", err)
+		}
+		f.exclude[i] = re
+	}
+	for i, r := range config.MetricRename {
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("MetricRename: %s", err)
+		}
+		f.rename[i] = metricRename{re, r.Replace}
+	}
+	names := make(map[string]bool)
+	for _, d := range config.Derived {
+		dm, err := newDerivedMetric(d)
+		if err != nil {
+			return nil, err
+		}
+		if names[d.Name] {
+			return nil, fmt.Errorf("Duplicate derived metric: %s", d.Name)
+		}
+		names[d.Name] = true
+		f.derived = append(f.derived, dm)
+	}
+	return f, nil
+}
+
+// Start filtering collections from CollectionChan() to outChan.
+// @goroutine[0]
+func (f *MetricFilter) Start(outChan chan *Collection) {
+	f.outChan = outChan
+	go f.run()
+}
+
+// @goroutine[0]
+func (f *MetricFilter) Stop() {
+	f.sync.Stop()
+	f.sync.Wait()
+}
+
+// CollectionChan is the chan the monitor sends collections to.
+func (f *MetricFilter) CollectionChan() chan *Collection {
+	return f.collectionChan
+}
+
+// Filter returns a copy of the collection with only the metrics to report.
+func (f *MetricFilter) Filter(c *Collection) *Collection {
+	out := &Collection{
+		ServiceInstance: c.ServiceInstance,
+		Ts:              c.Ts,
+		Metrics:         make([]Metric, 0, len(c.Metrics)),
+	}
+
+	if f.max > 0 {
+		if w := c.Ts / f.window; w > f.curWindow {
+			if f.curWindow > 0 {
+				f.selectTop()
+			}
+			f.curWindow = w
+		}
+	}
+
+	for _, m := range c.Metrics {
+		name, ok := f.name(m.Name)
+		if !ok {
+			continue
+		}
+		m.Name = name
+		if f.max > 0 && !f.top(m) {
+			continue
+		}
+		out.Metrics = append(out.Metrics, m)
+	}
+
+	if len(f.derived) > 0 {
+		out.Metrics = append(out.Metrics, f.derive(c)...)
+	}
+	return out
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[1]
+func (f *MetricFilter) run() {
+	defer f.sync.Done()
+	for {
+		select {
+		case c := <-f.collectionChan:
+			out := f.Filter(c)
+			select {
+			case f.outChan <- out:
+			case <-time.After(500 * time.Millisecond):
+				f.logger.Debug("Lost metrics; timeout sending to aggregator after 500ms")
+				pct.Metrics.Inc("mm/lost_collections", 1)
+			}
+		case <-f.sync.StopChan:
+			return
+		}
+	}
+}
+
+// name returns the metric's new name, and false if it's not included.
+func (f *MetricFilter) name(name string) (string, bool) {
+	if len(f.include) > 0 {
+		included := false
+		for _, re := range f.include {
+			if re.MatchString(name) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return "", false
+		}
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return "", false
+		}
+	}
+	for _, r := range f.rename {
+		if r.re.MatchString(name) {
+			return r.re.ReplaceAllString(name, r.replace), true
+		}
+	}
+	return name, true
+}
+
+// derive returns the derived metrics that have a value for the collection.
+func (f *MetricFilter) derive(c *Collection) []Metric {
+	metrics := make(map[string]Metric, len(c.Metrics))
+	for _, m := range c.Metrics {
+		if m.Type == "gauge" || m.Type == "counter" {
+			metrics[m.Name] = m
+		}
+	}
+	derived := []Metric{}
+	for _, d := range f.derived {
+		if m, ok := d.eval(c.Ts, metrics, f.counters); ok {
+			derived = append(derived, m)
+		}
+	}
+	for _, d := range f.derived {
+		for _, ref := range d.refs {
+			if m, ok := metrics[ref]; ok && m.Type == "counter" {
+				f.counters[ref] = counterVal{c.Ts, m.Number}
+			}
+		}
+	}
+	return derived
+}
+
+// top updates the metric's activity and returns true if it's a top metric.
+func (f *MetricFilter) top(m Metric) bool {
+	activity := f.activity[m.Name]
+	if m.Type != "string" {
+		val := m.Number
+		if m.Type == "histogram" {
+			val = 0
+			for _, n := range m.Counts {
+				val += n
+			}
+		}
+		if prev, ok := f.prev[m.Name]; ok {
+			activity += math.Abs(val - prev)
+		}
+		f.prev[m.Name] = val
+	}
+	f.activity[m.Name] = activity
+
+	if f.selected[m.Name] {
+		return true
+	}
+	if f.firstCome && len(f.selected) < f.max {
+		f.selected[m.Name] = true
+		return true
+	}
+	if !f.capped {
+		f.capped = true
+		f.logger.Warn(fmt.Sprintf("More than %d metrics (MaxMetrics), reporting the top %d by activity", f.max, f.max))
+	}
+	return false
+}
+
+// selectTop selects the top metrics by activity in the last window.
+// Ties are broken by name so the selection is stable.
+func (f *MetricFilter) selectTop() {
+	if len(f.activity) == 0 {
+		return // no metrics, keep the current selection
+	}
+	names := make([]string, 0, len(f.activity))
+	for name := range f.activity {
+		names = append(names, name)
+	}
+	sort.Sort(byActivity{names, f.activity})
+	if len(names) > f.max {
+		names = names[0:f.max]
+	}
+	f.selected = make(map[string]bool, len(names))
+	for _, name := range names {
+		f.selected[name] = true
+	}
+	f.firstCome = false
+	f.activity = make(map[string]float64)
+}
+
+type byActivity struct {
+	names    []string
+	activity map[string]float64
+}
+
+func (a byActivity) Len() int      { return len(a.names) }
+func (a byActivity) Swap(i, j int) { a.names[i], a.names[j] = a.names[j], a.names[i] }
+func (a byActivity) Less(i, j int) bool {
+	ai, aj := a.activity[a.names[i]], a.activity[a.names[j]]
+	if ai != aj {
+		return ai > aj
+	}
+	return a.names[i] < a.names[j]
+}
@@ -32,7 +32,10 @@ import (
 	"github.com/percona/percona-agent/pct"
 	"github.com/percona/percona-agent/ticker"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 )
@@ -40,7 +43,8 @@ import (
 // We use one binding per unique mm.Report interval.  For example, if some monitors
 // report every 60s and others every 10s, then there are two bindings.  All monitors
 // with the same report interval share the same binding: collectionChan to send
-// metrics and aggregator summarizing and reporting those metrics.
+// metrics and aggregator summarizing and reporting those metrics.  The aggregator
+// also keeps the most recent collections for the GetMetrics command.
 type Binding struct {
 	aggregator     *Aggregator
 	collectionChan chan *Collection // <- metrics from monitors
@@ -54,10 +58,12 @@ type Manager struct {
 	im      *instance.Repo
 	// --
 	monitors    map[string]Monitor
+	filters     map[string]*MetricFilter // keyed on monitor name
 	running     bool
 	mux         *sync.RWMutex // guards monitors and running
 	status      *pct.Status
 	aggregators map[uint]*Binding
+	alerter     *Alerter
 }
 
 func NewManager(logger *pct.Logger, factory MonitorFactory, clock ticker.Manager, spool data.Spooler, im *instance.Repo) *Manager {
@@ -69,8 +75,10 @@ func NewManager(logger *pct.Logger, factory MonitorFactory, clock ticker.Manager
 		im:      im,
 		// --
 		monitors:    make(map[string]Monitor),
+		filters:     make(map[string]*MetricFilter),
 		status:      pct.NewStatus([]string{"mm"}),
 		aggregators: make(map[uint]*Binding),
+		alerter:     NewAlerter(pct.NewLogger(logger.LogChan(), "mm-alert"), spool),
 		mux:         &sync.RWMutex{},
 	}
 	return m
@@ -90,6 +98,18 @@ func (m *Manager) Start() error {
 		return pct.ServiceIsRunningError{Service: "mm"}
 	}
 
+	// Load alert rules before starting monitors so the first reports are checked.
+	// Rules are saved as alert.conf, not mm-alert.conf, so they're not mistaken
+	// for a monitor config.
+	rules := []AlertRule{}
+	if err := pct.Basedir.ReadConfig("alert", &rules); err != nil {
+		if !os.IsNotExist(err) {
+			m.logger.Error("Read alert rules: " + err.Error())
+		}
+	} else if err := m.alerter.SetRules(rules); err != nil {
+		m.logger.Error("Invalid alert rules: " + err.Error())
+	}
+
 	// Start all metric monitors.
 	glob := filepath.Join(pct.Basedir.Dir("config"), "mm-*.conf")
 	configFiles, err := filepath.Glob(glob)
@@ -139,6 +159,13 @@ func (m *Manager) Stop() error {
 			continue
 		}
 		m.clock.Remove(monitor.TickChan())
+		for _, a := range m.aggregators {
+			a.aggregator.Remove(name)
+		}
+		if filter, ok := m.filters[name]; ok {
+			filter.Stop()
+			delete(m.filters, name)
+		}
 		delete(m.monitors, name)
 	}
 	m.running = false
@@ -158,6 +185,9 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 		if err != nil {
 			return cmd.Reply(nil, err)
 		}
+		if err := mm.ValidateRollup(); err != nil {
+			return cmd.Reply(nil, err)
+		}
 
 		m.status.UpdateRe("mm", "Starting "+name, cmd)
 		m.logger.Info("Start", name, cmd)
@@ -181,33 +211,66 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 		// at 00:03 and system metrics at 00:05 and other metrics at 00:06 which
 		// makes it very difficult to see all metrics at a single point in time
 		// or meaningfully compare a single interval, e.g. 00:00 to 00:05.
+		// Slow monitors that aren't Synchronized are the exception.
+		synced := Synchronized(monitor)
 		tickChan := make(chan time.Time)
-		m.clock.Add(tickChan, mm.Collect, true)
+		m.clock.Add(tickChan, mm.Collect, synced)
 
 		// We need one aggregator for each unique report interval.  There's usually
 		// just one: 60s.  Remember: report interval != collect interval.  Monitors
 		// can collect at different intervals (typically 1s and 10s), yet all report
 		// at the same 60s interval, or different report intervals.
-		a, ok := m.aggregators[mm.Report]
+		report := reportInterval(mm, synced)
+		a, ok := m.aggregators[report]
 		if !ok {
 			// Make new aggregator for this report interval.
-			logger := pct.NewLogger(m.logger.LogChan(), fmt.Sprintf("mm-ag-%d", mm.Report))
+			logger := pct.NewLogger(m.logger.LogChan(), fmt.Sprintf("mm-ag-%d", report))
 			collectionChan := make(chan *Collection, 5)
-			aggregator := NewAggregator(logger, int64(mm.Report), collectionChan, m.spool)
+			aggregator := NewAggregator(logger, int64(report), collectionChan, m.spool, m.alerter)
 			aggregator.Start()
 
 			// Save aggregator for other monitors with same report interval.
 			a = &Binding{aggregator, collectionChan}
-			m.aggregators[mm.Report] = a
-			m.logger.Info("Created", mm.Report, "second aggregator")
+			m.aggregators[report] = a
+			pct.Metrics.SetGauge(fmt.Sprintf("mm/collection_chan/%d", report), func() float64 {
+				return float64(len(collectionChan))
+			})
+			m.logger.Info("Created", report, "second aggregator")
+		}
+		a.aggregator.SetGrace(mm.Grace)
+		if report == mm.Report {
+			for _, r := range mm.Rollup {
+				a.aggregator.AddRollup(r)
+			}
+		}
+
+		// If the monitor has metric rules, its collections go through a filter
+		// which applies them then sends the collections to the aggregator.
+		collectionChan := a.collectionChan
+		var filter *MetricFilter
+		if mm.HasMetricRules() {
+			filter, err = NewMetricFilter(pct.NewLogger(m.logger.LogChan(), name+"-filter"), *mm)
+			if err != nil {
+				m.clock.Remove(tickChan)
+				return cmd.Reply(nil, errors.New("Start "+name+": "+err.Error()))
+			}
+			filter.Start(a.collectionChan)
+			collectionChan = filter.CollectionChan()
 		}
 
 		// Start the monitor.
-		if err := monitor.Start(tickChan, a.collectionChan); err != nil {
+		if err := monitor.Start(tickChan, collectionChan); err != nil {
+			if filter != nil {
+				filter.Stop()
+			}
 			return cmd.Reply(nil, errors.New("Start "+name+": "+err.Error()))
 		}
+		a.aggregator.Expect(name, mm.ServiceInstance, mm.Collect, time.Now())
 		m.mux.Lock()
 		m.monitors[name] = monitor
+		if filter != nil {
+			m.filters[name] = filter
+		}
 		m.mux.Unlock()
 
 		// Save the monitor-specific config to disk so agent starts on restart.
@@ -234,19 +297,43 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 			return cmd.Reply(nil, errors.New("Stop "+name+": "+err.Error()))
 		}
 		m.clock.Remove(monitor.TickChan())
+		for _, a := range m.aggregators {
+			a.aggregator.Remove(name)
+		}
 		if err := pct.Basedir.RemoveConfig(name); err != nil {
 			return cmd.Reply(nil, errors.New("Remove "+name+": "+err.Error()))
 		}
 		m.mux.Lock()
+		if filter, ok := m.filters[name]; ok {
+			filter.Stop()
+			delete(m.filters, name)
+		}
 		delete(m.monitors, name)
 		m.mux.Unlock()
 		return cmd.Reply(nil) // success
 	case "GetConfig":
 		config, errs := m.GetConfig()
 		return cmd.Reply(config, errs...)
+	case "SetAlertRules":
+		rules := []AlertRule{}
+		if err := json.Unmarshal(cmd.Data, &rules); err != nil {
+			return cmd.Reply(nil, errors.New("mm.Handle:SetAlertRules:json.Unmarshal:"+err.Error()))
+		}
+		m.logger.Info("Set alert rules", cmd)
+		if err := m.alerter.SetRules(rules); err != nil {
+			return cmd.Reply(nil, err)
+		}
+		if err := pct.Basedir.WriteConfig("alert", rules); err != nil {
+			return cmd.Reply(nil, errors.New("Write alert rules:"+err.Error()))
+		}
+		return cmd.Reply(nil) // success
+	case "GetAlertRules":
+		return cmd.Reply(m.alerter.Rules())
+	case "SetConfig":
+		return m.setMonitorConfig(cmd)
+	case "GetMetrics":
+		return m.getMetrics(cmd)
 	default:
-		// SetConfig does not work by design.  To re-configure a monitor,
-		// stop it then start it again with the new config.
 		return cmd.Reply(nil, pct.UnknownCmdError{Cmd: cmd.Cmd})
 	}
 }
@@ -306,6 +393,134 @@ func (m *Manager) GetConfig() ([]proto.AgentConfig, []error) {
 	return configs, errs
 }
 
+/**
+ * getMetrics returns the most recent raw collections that match the query in
+ * cmd.Data, oldest first, from all aggregators.  It's for troubleshooting, so
+ * metrics are what monitors collected: counters are not rates, etc.
+ */
+// @goroutine[0]
+func (m *Manager) getMetrics(cmd *proto.Cmd) *proto.Reply {
+	q := MetricsQuery{}
+	if cmd.Data != nil {
+		if err := json.Unmarshal(cmd.Data, &q); err != nil {
+			return cmd.Reply(nil, errors.New("mm.getMetrics:json.Unmarshal:"+err.Error()))
+		}
+	}
+	if err := q.Validate(); err != nil {
+		return cmd.Reply(nil, err)
+	}
+	collections := []*Collection{}
+	for _, a := range m.aggregators {
+		collections = append(collections, a.aggregator.Recent().Get(q)...)
+	}
+	sort.Stable(byTs(collections))
+	return cmd.Reply(collections)
+}
+
+type byTs []*Collection
+
+func (a byTs) Len() int           { return len(a) }
+func (a byTs) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTs) Less(i, j int) bool { return a[i].Ts < a[j].Ts }
+
+/**
+ * setMonitorConfig re-configures a running monitor if it's Reconfigurable,
+ * else it must be stopped then started again with the new config.  The
+ * report interval and metric rules can't be changed this way because the
+ * monitor's collectionChan depends on them.
+ */
+// @goroutine[0]
+func (m *Manager) setMonitorConfig(cmd *proto.Cmd) *proto.Reply {
+	config, name, err := m.getMonitorConfig(cmd)
+	if err != nil {
+		return cmd.Reply(nil, err)
+	}
+
+	m.status.UpdateRe("mm", "Setting "+name+" config", cmd)
+	m.logger.Info("Set", name, cmd)
+
+	m.mux.RLock()
+	monitor, ok := m.monitors[name]
+	m.mux.RUnlock()
+	if !ok {
+		return cmd.Reply(nil, errors.New("Unknown monitor: "+name))
+	}
+	r, ok := monitor.(Reconfigurable)
+	if !ok {
+		return cmd.Reply(nil, errors.New(name+" cannot be re-configured while running; stop it and start it with the new config"))
+	}
+
+	// Monitor's current mm.Config.
+	bytes, err := json.Marshal(monitor.Config())
+	if err != nil {
+		return cmd.Reply(nil, err)
+	}
+	cur := &Config{}
+	if err := json.Unmarshal(bytes, cur); err != nil {
+		return cmd.Reply(nil, err)
+	}
+	if config.Report != cur.Report {
+		return cmd.Reply(nil, errors.New("Report interval cannot be changed while running; stop "+name+" and start it with the new config"))
+	}
+	synced := Synchronized(monitor)
+	if !synced && config.Collect != cur.Collect {
+		// It reports at its collect interval, see reportInterval().
+		return cmd.Reply(nil, errors.New("Collect interval cannot be changed while running; stop "+name+" and start it with the new config"))
+	}
+	if err := config.ValidateRollup(); err != nil {
+		return cmd.Reply(nil, err)
+	}
+	if !reflect.DeepEqual(config.MetricInclude, cur.MetricInclude) ||
+		!reflect.DeepEqual(config.MetricExclude, cur.MetricExclude) ||
+		!reflect.DeepEqual(config.MetricRename, cur.MetricRename) ||
+		config.MaxMetrics != cur.MaxMetrics ||
+		!reflect.DeepEqual(config.Derived, cur.Derived) {
+		return cmd.Reply(nil, errors.New("Metric rules cannot be changed while running; stop "+name+" and start it with the new config"))
+	}
+
+	if err := r.Reconfigure(cmd.Data); err != nil {
+		return cmd.Reply(nil, errors.New("Set "+name+" config: "+err.Error()))
+	}
+
+	// The monitor keeps its tickChan, so re-add it to the clock at the new interval.
+	if config.Collect != cur.Collect {
+		tickChan := monitor.TickChan()
+		m.clock.Remove(tickChan)
+		m.clock.Add(tickChan, config.Collect, synced)
+		m.logger.Info("Changed", name, "collect interval from", cur.Collect, "to", config.Collect)
+	}
+	report := reportInterval(config, synced)
+	if a, ok := m.aggregators[report]; ok {
+		a.aggregator.SetGrace(config.Grace)
+		if report == config.Report {
+			for _, r := range config.Rollup {
+				a.aggregator.AddRollup(r)
+			}
+		}
+		if config.Collect != cur.Collect {
+			a.aggregator.Expect(name, config.ServiceInstance, config.Collect, time.Now())
+		}
+	}
+
+	if err := pct.Basedir.WriteConfig(name, monitor.Config()); err != nil {
+		return cmd.Reply(nil, errors.New("Write "+name+" config:"+err.Error()))
+	}
+	return cmd.Reply(nil) // success
+}
+
+/**
+ * reportInterval returns the interval of the aggregator for a monitor.  A
+ * monitor that's not synchronized and collects less often than it reports
+ * reports at its collect interval, else its metrics would be stale in every
+ * report between its collections.
+ */
+func reportInterval(config *Config, synced bool) uint {
+	if !synced && config.Collect > config.Report {
+		return config.Collect
+	}
+	return config.Report
+}
+
 func (m *Manager) getMonitorConfig(cmd *proto.Cmd) (*Config, string, error) {
 	/**
 	 * cmd.Data is a monitor-specific config, e.g. mysql.Config.  But monitor-specific
@@ -321,8 +536,12 @@ func (m *Manager) getMonitorConfig(cmd *proto.Cmd) (*Config, string, error) {
 		}
 	}
 
-	// The real name of the internal service, e.g. mm-mysql-1:
+	// The real name of the internal service, e.g. mm-mysql-1, or mm-mysql-1-query
+	// if it's not the service's default monitor:
 	name := "mm-" + m.im.Name(mm.Service, mm.InstanceId)
+	if mm.Monitor != "" {
+		name += "-" + mm.Monitor
+	}
 
 	return mm, name, nil
 }
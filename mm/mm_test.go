@@ -94,7 +94,7 @@ func (s *AggregatorTestSuite) TestGoTime(t *C) {
 
 func (s *AggregatorTestSuite) TestC001(t *C) {
 	interval := int64(300)
-	a := mm.NewAggregator(s.logger, interval, s.collectionChan, s.spool)
+	a := mm.NewAggregator(s.logger, interval, s.collectionChan, s.spool, nil)
 	go a.Start()
 	defer a.Stop()
 
@@ -136,7 +136,7 @@ func (s *AggregatorTestSuite) TestC001(t *C) {
 
 func (s *AggregatorTestSuite) TestC002(t *C) {
 	interval := int64(300)
-	a := mm.NewAggregator(s.logger, interval, s.collectionChan, s.spool)
+	a := mm.NewAggregator(s.logger, interval, s.collectionChan, s.spool, nil)
 	go a.Start()
 	defer a.Stop()
 
@@ -172,7 +172,7 @@ func (s *AggregatorTestSuite) TestC002(t *C) {
 // All zero values
 func (s *AggregatorTestSuite) TestC000(t *C) {
 	interval := int64(60)
-	a := mm.NewAggregator(s.logger, interval, s.collectionChan, s.spool)
+	a := mm.NewAggregator(s.logger, interval, s.collectionChan, s.spool, nil)
 	go a.Start()
 	defer a.Stop()
 
@@ -205,7 +205,7 @@ func (s *AggregatorTestSuite) TestC000(t *C) {
 // COUNTER
 func (s *AggregatorTestSuite) TestC003(t *C) {
 	interval := int64(5)
-	a := mm.NewAggregator(s.logger, interval, s.collectionChan, s.spool)
+	a := mm.NewAggregator(s.logger, interval, s.collectionChan, s.spool, nil)
 	go a.Start()
 	defer a.Stop()
 
@@ -251,7 +251,7 @@ func (s *AggregatorTestSuite) TestC003(t *C) {
 
 func (s *AggregatorTestSuite) TestC003Lost(t *C) {
 	interval := int64(5)
-	a := mm.NewAggregator(s.logger, interval, s.collectionChan, s.spool)
+	a := mm.NewAggregator(s.logger, interval, s.collectionChan, s.spool, nil)
 	go a.Start()
 	defer a.Stop()
 
@@ -302,7 +302,7 @@ func (s *AggregatorTestSuite) TestBadMetric(t *C) {
 	 * its type is "guage" instead of "gauge", and it's the only metric so the
 	 * result should be zero metrics.
 	 */
-	a := mm.NewAggregator(s.logger, 60, s.collectionChan, s.spool)
+	a := mm.NewAggregator(s.logger, 60, s.collectionChan, s.spool, nil)
 	go a.Start()
 	defer a.Stop()
 
@@ -320,6 +320,875 @@ func (s *AggregatorTestSuite) TestBadMetric(t *C) {
 	t.Check(len(got.Stats[0].Stats), Equals, 0) // ^ its metrics
 }
 
+func (s *AggregatorTestSuite) TestHistogram(t *C) {
+	/**
+	 * Histogram counts are counters, so the interval's distribution is how
+	 * much each bucket increased.  A reset (a bucket decreased) is skipped,
+	 * and new bounds restart the distribution.
+	 */
+	stats, err := mm.NewStats("histogram")
+	t.Assert(err, IsNil)
+
+	bounds := []float64{0.001, 0.01, 0.1}
+	stats.Add(&mm.Metric{Name: "h", Type: "histogram", Bounds: bounds, Counts: []float64{10, 5, 1, 0}}, 1)
+	stats.Add(&mm.Metric{Name: "h", Type: "histogram", Bounds: bounds, Counts: []float64{15, 7, 1, 1}}, 2)
+	stats.Add(&mm.Metric{Name: "h", Type: "histogram", Bounds: bounds, Counts: []float64{2, 0, 0, 0}}, 3) // reset
+	stats.Add(&mm.Metric{Name: "h", Type: "histogram", Bounds: bounds, Counts: []float64{4, 1, 0, 0}}, 4)
+	stats.Summarize()
+	t.Check(stats.Bounds, DeepEquals, bounds)
+	t.Check(stats.Counts, DeepEquals, []float64{7, 3, 0, 1})
+	t.Check(stats.Cnt, Equals, 11)
+
+	// Invalid: len(Counts) != len(Bounds) + 1
+	stats.Add(&mm.Metric{Name: "h", Type: "histogram", Bounds: bounds, Counts: []float64{9, 9}}, 5)
+	t.Check(stats.Counts, DeepEquals, []float64{7, 3, 0, 1})
+
+	// New bounds
+	bounds = []float64{1, 10}
+	stats.Add(&mm.Metric{Name: "h", Type: "histogram", Bounds: bounds, Counts: []float64{1, 1, 1}}, 6)
+	stats.Add(&mm.Metric{Name: "h", Type: "histogram", Bounds: bounds, Counts: []float64{2, 1, 3}}, 7)
+	stats.Summarize()
+	t.Check(stats.Bounds, DeepEquals, bounds)
+	t.Check(stats.Counts, DeepEquals, []float64{1, 0, 2})
+	t.Check(stats.Cnt, Equals, 3)
+}
+
+func (s *AggregatorTestSuite) TestHistogramIntervals(t *C) {
+	a := mm.NewAggregator(s.logger, 60, s.collectionChan, s.spool, nil)
+	go a.Start()
+	defer a.Stop()
+
+	mysql1 := proto.ServiceInstance{Service: "mysql", InstanceId: 1}
+	begin := int64(1388577600) // 2014-01-01 12:00:00
+	a.Expect("mm-mysql-1", mysql1, 10, time.Unix(begin-60, 0))
+
+	// One query every 10s.
+	bounds := []float64{0.001, 0.01, 0.1}
+	send := func(ts int64) {
+		n := float64((ts - begin) / 10)
+		s.collectionChan <- &mm.Collection{
+			ServiceInstance: mysql1,
+			Ts:              ts,
+			Metrics: []mm.Metric{
+				{Name: "mysql/query_response_time", Type: "histogram", Bounds: bounds, Counts: []float64{100 + n, 5, 1, 0}},
+			},
+		}
+	}
+
+	// The 1st value is only the baseline, so 5 queries in the 1st interval.
+	for ts := begin; ts <= begin+50; ts += 10 {
+		send(ts)
+	}
+	got := test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Stats[0].Stats["mysql/query_response_time"].Counts, DeepEquals, []float64{5, 0, 0, 0})
+
+	// The 2nd interval starts from the last value of the 1st, so the query
+	// between 50s and 60s isn't lost.
+	for ts := begin + 60; ts <= begin+110; ts += 10 {
+		send(ts)
+	}
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(begin+60, 0).UTC())
+	hist := got.Stats[0].Stats["mysql/query_response_time"]
+	t.Check(hist.Counts, DeepEquals, []float64{6, 0, 0, 0})
+	t.Check(hist.Cnt, Equals, 6)
+}
+
+func (s *AggregatorTestSuite) TestCollectionBuffer(t *C) {
+	b := mm.NewCollectionBuffer(6) // metrics, i.e. 3 collections
+	t.Check(b.Get(mm.MetricsQuery{}), HasLen, 0)
+
+	for ts := int64(1); ts <= 4; ts++ {
+		b.Add(&mm.Collection{
+			ServiceInstance: proto.ServiceInstance{Service: "mysql", InstanceId: 1},
+			Ts:              ts,
+			Metrics: []mm.Metric{
+				{Name: "mysql/threads_running", Type: "gauge", Number: float64(ts)},
+				{Name: "mysql/threads_connected", Type: "gauge", Number: 10},
+			},
+		})
+	}
+
+	// Oldest collection (ts 1) was replaced.
+	got := b.Get(mm.MetricsQuery{})
+	t.Assert(got, HasLen, 3)
+	t.Check(got[0].Ts, Equals, int64(2))
+	t.Check(got[2].Ts, Equals, int64(4))
+	t.Check(got[0].Metrics, HasLen, 2)
+
+	got = b.Get(mm.MetricsQuery{Metric: "mysql/*_running", Begin: time.Unix(3, 0), End: time.Unix(3, 0)})
+	t.Assert(got, HasLen, 1)
+	t.Check(got[0].Metrics, DeepEquals, []mm.Metric{{Name: "mysql/threads_running", Type: "gauge", Number: 3}})
+
+	t.Check(b.Get(mm.MetricsQuery{Service: "server"}), HasLen, 0)
+	t.Check(b.Get(mm.MetricsQuery{InstanceId: 2}), HasLen, 0)
+	t.Check(b.Get(mm.MetricsQuery{Metric: "os/*"}), HasLen, 0)
+
+	t.Check(mm.MetricsQuery{Metric: "mysql/["}.Validate(), NotNil)
+	t.Check(mm.MetricsQuery{Begin: time.Unix(2, 0), End: time.Unix(1, 0)}.Validate(), NotNil)
+
+	// A big collection replaces as many old ones as needed, and it's kept
+	// even though it alone is more metrics than the buffer size.
+	big := &mm.Collection{
+		ServiceInstance: proto.ServiceInstance{Service: "mysql", InstanceId: 1},
+		Ts:              5,
+		Metrics:         make([]mm.Metric, 7),
+	}
+	b.Add(big)
+	got = b.Get(mm.MetricsQuery{})
+	t.Assert(got, HasLen, 1)
+	t.Check(got[0].Ts, Equals, int64(5))
+}
+
+func (s *AggregatorTestSuite) TestCoverage(t *C) {
+	a := mm.NewAggregator(s.logger, 60, s.collectionChan, s.spool, nil)
+	go a.Start()
+	defer a.Stop()
+
+	mysql1 := proto.ServiceInstance{Service: "mysql", InstanceId: 1}
+	server1 := proto.ServiceInstance{Service: "server", InstanceId: 1}
+
+	// The MySQL monitor starts 20s into the interval, so only 4 collections
+	// are expected: 20, 30, 40, 50.  The server monitor starts before.
+	begin := int64(1388577600) // 2014-01-01 12:00:00
+	a.Expect("mm-mysql-1", mysql1, 10, time.Unix(begin+20, 0))
+	a.Expect("mm-server-1", server1, 30, time.Unix(begin-60, 0))
+
+	metrics := []mm.Metric{
+		{Name: "mysql/threads_running", Type: "gauge", Number: 1},
+		{Name: "mysql/version", Type: "string", String: "5.6.19"},
+	}
+	s.collectionChan <- &mm.Collection{ServiceInstance: mysql1, Ts: begin + 20, Metrics: metrics}
+	s.collectionChan <- &mm.Collection{ServiceInstance: mysql1, Ts: begin + 30, Metrics: metrics}
+
+	// 1st collection in next interval doesn't have mysql/version.
+	s.collectionChan <- &mm.Collection{ServiceInstance: mysql1, Ts: begin + 60, Metrics: metrics[0:1]}
+
+	got := test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Assert(got.Stats, HasLen, 2)
+	t.Check(got.Stats[0].ServiceInstance, DeepEquals, mysql1)
+	t.Check(got.Stats[0].Coverage, DeepEquals, &mm.Coverage{
+		Expected: 4,
+		Received: 2,
+		Missing:  []string{"2 of 4 collections not received"},
+	})
+	t.Check(got.Stats[0].Stats["mysql/version"].Stale, Equals, false)
+
+	// Server instance sent nothing but is reported anyway.
+	t.Check(got.Stats[1].ServiceInstance, DeepEquals, server1)
+	t.Check(got.Stats[1].Stats, HasLen, 0)
+	t.Check(got.Stats[1].Coverage, DeepEquals, &mm.Coverage{
+		Expected: 2,
+		Received: 0,
+		Missing:  []string{"No collections"},
+	})
+
+	// Stop expecting the server instance, and send a late collection.
+	a.Remove("mm-server-1")
+	s.collectionChan <- &mm.Collection{ServiceInstance: mysql1, Ts: begin + 50, Metrics: metrics[0:1]}
+	for ts := begin + 70; ts <= begin+120; ts += 10 {
+		s.collectionChan <- &mm.Collection{ServiceInstance: mysql1, Ts: ts, Metrics: metrics[0:1]}
+	}
+
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Assert(got.Stats, HasLen, 1)
+	t.Check(got.Stats[0].Coverage, DeepEquals, &mm.Coverage{
+		Expected: 6,
+		Received: 6,
+		Late:     1,
+		Missing:  []string{"1 collections for past intervals arrived late"},
+	})
+	version := got.Stats[0].Stats["mysql/version"]
+	t.Assert(version, NotNil)
+	t.Check(version.Stale, Equals, true)
+	t.Check(version.Cnt, Equals, 0)
+	t.Check(got.Stats[0].Stats["mysql/threads_running"].Stale, Equals, false)
+
+	// Still not reported, so it's forgotten: stale for only one interval.
+	for ts := begin + 130; ts <= begin+180; ts += 10 {
+		s.collectionChan <- &mm.Collection{ServiceInstance: mysql1, Ts: ts, Metrics: metrics[0:1]}
+	}
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Assert(got.Stats, HasLen, 1)
+	t.Check(got.Stats[0].Stats["mysql/version"], IsNil)
+	t.Check(got.Stats[0].Stats["mysql/threads_running"].Stale, Equals, false)
+}
+
+func (s *AggregatorTestSuite) TestLateCollection(t *C) {
+	a := mm.NewAggregator(s.logger, 60, s.collectionChan, s.spool, nil)
+	go a.Start()
+	defer a.Stop()
+
+	mysql1 := proto.ServiceInstance{Service: "mysql", InstanceId: 1}
+	begin := int64(1388577600) // 2014-01-01 12:00:00
+	a.Expect("mm-mysql-1", mysql1, 10, time.Unix(begin-60, 0))
+
+	// Questions increases 1/s.
+	send := func(ts, questions int64) {
+		s.collectionChan <- &mm.Collection{
+			ServiceInstance: mysql1,
+			Ts:              ts,
+			Metrics: []mm.Metric{
+				{Name: "mysql/threads_running", Type: "gauge", Number: 100},
+				{Name: "mysql/questions", Type: "counter", Number: float64(questions)},
+			},
+		}
+	}
+	for ts := begin; ts <= begin+50; ts += 10 {
+		send(ts, ts-begin)
+	}
+	got := test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(begin, 0).UTC())
+
+	// A late collection for the 1st interval, with a lower counter value,
+	// arrives during the 2nd interval.  Only its gauge is counted, else the
+	// counter would look reset and its rate would be wrong.
+	send(begin+60, 60)
+	send(begin+30, 0)
+	for ts := begin + 70; ts <= begin+110; ts += 10 {
+		send(ts, ts-begin)
+	}
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(begin+60, 0).UTC())
+	t.Check(got.Stats[0].Coverage.Late, Equals, uint(1))
+	t.Check(got.Stats[0].Stats["mysql/threads_running"].Cnt, Equals, 7)
+	questions := got.Stats[0].Stats["mysql/questions"]
+	t.Check(questions.Cnt, Equals, 5)
+	t.Check(questions.Min, Equals, float64(1))
+	t.Check(questions.Max, Equals, float64(1))
+}
+
+func (s *AggregatorTestSuite) TestLateAfterEarlyReport(t *C) {
+	a := mm.NewAggregator(s.logger, 60, s.collectionChan, s.spool, nil)
+	go a.Start()
+	defer a.Stop()
+
+	mysql1 := proto.ServiceInstance{Service: "mysql", InstanceId: 1}
+	begin := int64(1388577600) // 2014-01-01 12:00:00
+	a.Expect("mm-mysql-1", mysql1, 10, time.Unix(begin-60, 0))
+
+	send := func(ts int64) {
+		s.collectionChan <- &mm.Collection{
+			ServiceInstance: mysql1,
+			Ts:              ts,
+			Metrics:         []mm.Metric{{Name: "mysql/threads_running", Type: "gauge", Number: 1}},
+		}
+	}
+
+	// All collections received, so the 1st interval is reported early.
+	for ts := begin; ts <= begin+50; ts += 10 {
+		send(ts)
+	}
+	got := test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(begin, 0).UTC())
+
+	// More collections for the 1st interval arrive before the 2nd interval
+	// starts.  They're late; the 1st interval isn't re-opened and reported again.
+	send(begin + 50)
+	send(begin + 55)
+	for ts := begin + 60; ts <= begin+110; ts += 10 {
+		send(ts)
+	}
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(begin+60, 0).UTC())
+	t.Check(got.Stats[0].Coverage.Received, Equals, uint(6))
+	t.Check(got.Stats[0].Coverage.Late, Equals, uint(2))
+	t.Check(got.Stats[0].Stats["mysql/threads_running"].Cnt, Equals, 8)
+	got = test.WaitMmReport(s.dataChan)
+	t.Check(got, IsNil)
+}
+
+func (s *AggregatorTestSuite) TestGapInterval(t *C) {
+	a := mm.NewAggregator(s.logger, 60, s.collectionChan, s.spool, nil)
+	a.SetGrace(90)
+	go a.Start()
+	defer a.Stop()
+
+	mysql1 := proto.ServiceInstance{Service: "mysql", InstanceId: 1}
+	begin := int64(1388577600) // 2014-01-01 12:00:00
+	a.Expect("mm-mysql-1", mysql1, 10, time.Unix(begin-60, 0))
+
+	send := func(ts int64) {
+		s.collectionChan <- &mm.Collection{
+			ServiceInstance: mysql1,
+			Ts:              ts,
+			Metrics:         []mm.Metric{{Name: "mysql/threads_running", Type: "gauge", Number: 1}},
+		}
+	}
+
+	// The 1st and 3rd intervals are open when the 1st collection for the
+	// 2nd interval arrives.  It's not late: the 2nd interval was never reported.
+	for ts := begin; ts <= begin+40; ts += 10 {
+		send(ts)
+	}
+	send(begin + 120)
+	send(begin + 60)
+	send(begin + 50)
+	got := test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(begin, 0).UTC())
+
+	for ts := begin + 70; ts <= begin+110; ts += 10 {
+		send(ts)
+	}
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(begin+60, 0).UTC())
+	t.Check(got.Stats[0].Coverage, DeepEquals, &mm.Coverage{Expected: 6, Received: 6})
+	t.Check(got.Stats[0].Stats["mysql/threads_running"].Cnt, Equals, 6)
+}
+
+func (s *AggregatorTestSuite) TestGrace(t *C) {
+	a := mm.NewAggregator(s.logger, 60, s.collectionChan, s.spool, nil)
+	a.SetGrace(30)
+	go a.Start()
+	defer a.Stop()
+
+	mysql1 := proto.ServiceInstance{Service: "mysql", InstanceId: 1}
+	begin := int64(1388577600) // 2014-01-01 12:00:00
+	a.Expect("mm-mysql-1", mysql1, 10, time.Unix(begin-60, 0))
+
+	send := func(ts int64) {
+		s.collectionChan <- &mm.Collection{
+			ServiceInstance: mysql1,
+			Ts:              ts,
+			Metrics:         []mm.Metric{{Name: "mysql/threads_running", Type: "gauge", Number: 1}},
+		}
+	}
+
+	// The collection at 50s is slow, so the next interval starts first.
+	for ts := begin; ts <= begin+40; ts += 10 {
+		send(ts)
+	}
+	send(begin + 60)
+	got := test.WaitMmReport(s.dataChan)
+	t.Check(got, IsNil)
+
+	// It arrives within the grace window and completes the 1st interval.
+	send(begin + 50)
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(begin, 0).UTC())
+	t.Check(got.Stats[0].Coverage, DeepEquals, &mm.Coverage{Expected: 6, Received: 6})
+	t.Check(got.Stats[0].Stats["mysql/threads_running"].Cnt, Equals, 6)
+
+	// The 2nd interval is reported as soon as its last collection arrives.
+	for ts := begin + 70; ts <= begin+110; ts += 10 {
+		send(ts)
+	}
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(begin+60, 0).UTC())
+	t.Check(got.Stats[0].Coverage, DeepEquals, &mm.Coverage{Expected: 6, Received: 6})
+
+	// The 3rd interval is missing collections, so it's reported when
+	// the grace window expires: 30s after its end.
+	send(begin + 120)
+	send(begin + 150)
+	send(begin + 200)
+	got = test.WaitMmReport(s.dataChan)
+	t.Check(got, IsNil)
+	send(begin + 210)
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(begin+120, 0).UTC())
+	t.Check(got.Stats[0].Coverage, DeepEquals, &mm.Coverage{
+		Expected: 6,
+		Received: 2,
+		Missing:  []string{"4 of 6 collections not received"},
+	})
+}
+
+func (s *AggregatorTestSuite) TestRollup(t *C) {
+	a := mm.NewAggregator(s.logger, 60, s.collectionChan, s.spool, nil)
+	a.AddRollup(180)
+	a.AddRollup(180) // dupe ignored
+	a.AddRollup(90)  // not a multiple of 60, ignored
+	go a.Start()
+	defer a.Stop()
+
+	mysql1 := proto.ServiceInstance{Service: "mysql", InstanceId: 1}
+	begin := int64(1388577600) // 2014-01-01 12:00:00
+	a.Expect("mm-mysql-1", mysql1, 30, time.Unix(begin-60, 0))
+
+	send := func(ts int64, val float64) {
+		s.collectionChan <- &mm.Collection{
+			ServiceInstance: mysql1,
+			Ts:              ts,
+			Metrics: []mm.Metric{
+				{Name: "mysql/threads_running", Type: "gauge", Number: val},
+				{Name: "mysql/version", Type: "string", String: fmt.Sprintf("5.6.%d", int(val))},
+			},
+		}
+	}
+
+	// 3 one-minute reports, then the 3-minute rollup of their values.
+	for i := int64(0); i < 3; i++ {
+		send(begin+i*60, float64(2*i+1))
+		send(begin+i*60+30, float64(2*i+2))
+		got := test.WaitMmReport(s.dataChan)
+		t.Assert(got, NotNil)
+		t.Check(got.Ts, Equals, time.Unix(begin+i*60, 0).UTC())
+		t.Check(got.Duration, Equals, uint(60))
+		t.Check(got.Stats[0].Stats["mysql/threads_running"].Cnt, Equals, 2)
+	}
+	got := test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(begin, 0).UTC())
+	t.Check(got.Duration, Equals, uint(180))
+	t.Assert(got.Stats, HasLen, 1)
+	t.Check(got.Stats[0].Coverage, DeepEquals, &mm.Coverage{Expected: 6, Received: 6})
+	stats := got.Stats[0].Stats["mysql/threads_running"]
+	t.Check(stats.Cnt, Equals, 6)
+	t.Check(stats.Min, Equals, float64(1))
+	t.Check(stats.Avg, Equals, float64(3.5))
+	t.Check(stats.Med, Equals, float64(4))
+	t.Check(stats.Max, Equals, float64(6))
+	t.Check(got.Stats[0].Stats["mysql/version"].Str, Equals, "5.6.6")
+	t.Check(got.Stats[0].Stats["mysql/version"].Cnt, Equals, 6)
+
+	// The next rollup interval only gets one report, so it's reported when
+	// a report for a later rollup interval arrives.
+	send(begin+180, 7)
+	send(begin+210, 8)
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Duration, Equals, uint(60))
+	got = test.WaitMmReport(s.dataChan)
+	t.Check(got, IsNil)
+
+	send(begin+360, 9)
+	send(begin+390, 10)
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(begin+360, 0).UTC())
+	t.Check(got.Duration, Equals, uint(60))
+	got = test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Ts, Equals, time.Unix(begin+180, 0).UTC())
+	t.Check(got.Duration, Equals, uint(180))
+	t.Check(got.Stats[0].Stats["mysql/threads_running"].Cnt, Equals, 2)
+	t.Check(got.Stats[0].Coverage, DeepEquals, &mm.Coverage{Expected: 2, Received: 2})
+}
+
+func (s *AggregatorTestSuite) TestRollupManyValues(t *C) {
+	a := mm.NewAggregator(s.logger, 60, s.collectionChan, s.spool, nil)
+	a.AddRollup(1200)
+	go a.Start()
+	defer a.Stop()
+
+	mysql1 := proto.ServiceInstance{Service: "mysql", InstanceId: 1}
+	begin := int64(1388577600) // 2014-01-01 12:00:00
+	a.Expect("mm-mysql-1", mysql1, 1, time.Unix(begin-60, 0))
+
+	// 1200 values, 0 to 1199 out of order, so more than mm.MAX_MERGED_VALS.
+	for i := int64(0); i < 20; i++ {
+		for j := int64(0); j < 60; j++ {
+			n := i*60 + j
+			s.collectionChan <- &mm.Collection{
+				ServiceInstance: mysql1,
+				Ts:              begin + n,
+				Metrics:         []mm.Metric{{Name: "mysql/threads_running", Type: "gauge", Number: float64((n * 7919) % 1200)}},
+			}
+		}
+		got := test.WaitMmReport(s.dataChan)
+		t.Assert(got, NotNil)
+		t.Check(got.Duration, Equals, uint(60))
+	}
+	got := test.WaitMmReport(s.dataChan)
+	t.Assert(got, NotNil)
+	t.Check(got.Duration, Equals, uint(1200))
+
+	// Cnt, Min, Avg, and Max are exact; percentiles are approximate.
+	stats := got.Stats[0].Stats["mysql/threads_running"]
+	t.Check(stats.Cnt, Equals, 1200)
+	t.Check(stats.Min, Equals, float64(0))
+	t.Check(stats.Avg, Equals, float64(599.5))
+	t.Check(stats.Max, Equals, float64(1199))
+	for _, p := range []struct{ got, expect float64 }{{stats.Pct5, 60}, {stats.Med, 600}, {stats.Pct95, 1140}} {
+		if p.got < p.expect-10 || p.got > p.expect+10 {
+			t.Errorf("Got %f, expected %f +/- 10", p.got, p.expect)
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Alerter test suite
+/////////////////////////////////////////////////////////////////////////////
+
+type AlerterTestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&AlerterTestSuite{})
+
+func (s *AlerterTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 100)
+	s.logger = pct.NewLogger(s.logChan, "mm-alert-test")
+}
+
+func report(ts int64, vals ...float64) *mm.Report {
+	stats, _ := mm.NewStats("gauge")
+	for _, val := range vals {
+		stats.Add(&mm.Metric{Name: "mysql/threads_running", Type: "gauge", Number: val}, ts)
+	}
+	stats.Summarize()
+	return &mm.Report{
+		Ts:       time.Unix(ts, 0).UTC(),
+		Duration: 60,
+		Stats: []*mm.InstanceStats{
+			{
+				ServiceInstance: proto.ServiceInstance{Service: "mysql", InstanceId: 1},
+				Stats:           map[string]*mm.Stats{"mysql/threads_running": stats},
+			},
+		},
+	}
+}
+
+// --------------------------------------------------------------------------
+
+func (s *AlerterTestSuite) TestValidate(t *C) {
+	r := mm.AlertRule{Name: "threads", Metric: "mysql/threads_running", Stat: "Pct95", Op: ">", Value: 50}
+	t.Check(r.Validate(), IsNil)
+
+	r.Stat = "P95"
+	t.Check(r.Validate(), NotNil)
+
+	r.Raw = true // Stat is ignored
+	t.Check(r.Validate(), IsNil)
+
+	r.Op = "=>"
+	t.Check(r.Validate(), NotNil)
+
+	r.Op = "<="
+	r.Metric = "mysql/[threads"
+	t.Check(r.Validate(), NotNil)
+
+	r.Metric = "mysql/threads_running"
+	r.Name = ""
+	t.Check(r.Validate(), NotNil)
+
+	a := mm.NewAlerter(s.logger, mock.NewSpooler(nil))
+	r.Name = "threads"
+	err := a.SetRules([]mm.AlertRule{r, r})
+	t.Check(err, NotNil)
+}
+
+func (s *AlerterTestSuite) TestReportOpenResolve(t *C) {
+	spool := mock.NewSpooler(nil)
+	a := mm.NewAlerter(s.logger, spool)
+	err := a.SetRules([]mm.AlertRule{
+		{Name: "threads", Metric: "mysql/threads_*", Stat: "Max", Op: ">", Value: 50, For: 2},
+		{Name: "other-instance", InstanceId: 2, Metric: "mysql/threads_running", Stat: "Max", Op: ">", Value: 0},
+	})
+	t.Assert(err, IsNil)
+
+	// Condition true once is not enough.
+	a.Report(report(60, 10, 60))
+	t.Check(spool.DataIn, HasLen, 0)
+
+	// False resets the count.
+	a.Report(report(120, 10, 20))
+	a.Report(report(180, 55))
+	t.Check(spool.DataIn, HasLen, 0)
+
+	// True 2 intervals in a row opens the alert.
+	a.Report(report(240, 70))
+	t.Assert(spool.DataIn, HasLen, 1)
+	expect := &mm.Alert{
+		ServiceInstance: proto.ServiceInstance{Service: "mysql", InstanceId: 1},
+		Ts:              time.Unix(240, 0).UTC(),
+		Rule:            "threads",
+		Metric:          "mysql/threads_running",
+		State:           mm.ALERT_OPEN,
+		Value:           70,
+		Cond:            "mysql/threads_* Max > 50 for 2 intervals",
+	}
+	if same, diff := test.IsDeeply(spool.DataIn[0], expect); !same {
+		test.Dump(spool.DataIn[0])
+		t.Error(diff)
+	}
+
+	// Still true, still open: no new alert.
+	a.Report(report(300, 80))
+	t.Check(spool.DataIn, HasLen, 1)
+
+	// No values is not false: the alert isn't resolved.
+	a.Report(report(310))
+	t.Check(spool.DataIn, HasLen, 1)
+
+	// False resolves it.
+	a.Report(report(360, 5))
+	t.Assert(spool.DataIn, HasLen, 2)
+	got := spool.DataIn[1].(*mm.Alert)
+	t.Check(got.State, Equals, mm.ALERT_RESOLVED)
+	t.Check(got.Value, Equals, float64(5))
+	t.Check(got.Ts, Equals, time.Unix(360, 0).UTC())
+}
+
+func (s *AlerterTestSuite) TestResolveStaleAndRemoved(t *C) {
+	spool := mock.NewSpooler(nil)
+	a := mm.NewAlerter(s.logger, spool)
+	threads := mm.AlertRule{Name: "threads", Metric: "mysql/threads_running", Stat: "Max", Op: ">", Value: 50}
+	err := a.SetRules([]mm.AlertRule{threads})
+	t.Assert(err, IsNil)
+
+	a.Report(report(60, 70))
+	t.Assert(spool.DataIn, HasLen, 1)
+
+	// The metric disappeared: the aggregator reports it stale, which resolves
+	// the alert with the last value.
+	stale := report(120)
+	stale.Stats[0].Stats["mysql/threads_running"].Stale = true
+	a.Report(stale)
+	t.Assert(spool.DataIn, HasLen, 2)
+	got := spool.DataIn[1].(*mm.Alert)
+	t.Check(got.State, Equals, mm.ALERT_RESOLVED)
+	t.Check(got.Value, Equals, float64(70))
+	t.Check(got.Ts, Equals, time.Unix(120, 0).UTC())
+
+	// Re-open it.
+	a.Report(report(180, 80))
+	t.Assert(spool.DataIn, HasLen, 3)
+	t.Check(spool.DataIn[2].(*mm.Alert).State, Equals, mm.ALERT_OPEN)
+
+	// Adding a rule keeps the state of unchanged rules: still open, no new alert.
+	err = a.SetRules([]mm.AlertRule{
+		threads,
+		{Name: "conns", Metric: "mysql/threads_connected", Stat: "Max", Op: ">", Value: 1000},
+	})
+	t.Assert(err, IsNil)
+	t.Check(spool.DataIn, HasLen, 3)
+	a.Report(report(240, 90))
+	t.Check(spool.DataIn, HasLen, 3)
+
+	// Removing the rule resolves its open alert.
+	err = a.SetRules([]mm.AlertRule{})
+	t.Assert(err, IsNil)
+	t.Assert(spool.DataIn, HasLen, 4)
+	got = spool.DataIn[3].(*mm.Alert)
+	t.Check(got.Rule, Equals, "threads")
+	t.Check(got.State, Equals, mm.ALERT_RESOLVED)
+	t.Check(got.Value, Equals, float64(90))
+}
+
+func (s *AlerterTestSuite) TestRawCollection(t *C) {
+	spool := mock.NewSpooler(nil)
+	a := mm.NewAlerter(s.logger, spool)
+	err := a.SetRules([]mm.AlertRule{
+		{Name: "conns", Metric: "mysql/threads_connected", Op: ">=", Value: 100, Raw: true},
+		{Name: "threads", Metric: "mysql/threads_connected", Stat: "Max", Op: ">", Value: 0},
+	})
+	t.Assert(err, IsNil)
+
+	c := &mm.Collection{
+		ServiceInstance: proto.ServiceInstance{Service: "mysql", InstanceId: 1},
+		Ts:              1,
+		Metrics: []mm.Metric{
+			{Name: "mysql/threads_connected", Type: "gauge", Number: 100},
+			{Name: "mysql/threads_connected_total", Type: "counter", Number: 500},
+		},
+	}
+	a.Collection(c)
+	t.Assert(spool.DataIn, HasLen, 1)
+	got := spool.DataIn[0].(*mm.Alert)
+	t.Check(got.Rule, Equals, "conns")
+	t.Check(got.State, Equals, mm.ALERT_OPEN)
+	t.Check(got.Cond, Equals, "mysql/threads_connected value >= 100 for 1 intervals")
+
+	c.Ts = 2
+	c.Metrics[0].Number = 99
+	a.Collection(c)
+	t.Assert(spool.DataIn, HasLen, 2)
+	got = spool.DataIn[1].(*mm.Alert)
+	t.Check(got.State, Equals, mm.ALERT_RESOLVED)
+	t.Check(got.Ts, Equals, time.Unix(2, 0).UTC())
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// MetricFilter test suite
+/////////////////////////////////////////////////////////////////////////////
+
+type MetricFilterTestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&MetricFilterTestSuite{})
+
+func (s *MetricFilterTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 100)
+	s.logger = pct.NewLogger(s.logChan, "mm-filter-test")
+}
+
+func metricNames(c *mm.Collection) []string {
+	names := []string{}
+	for _, m := range c.Metrics {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+// --------------------------------------------------------------------------
+
+func (s *MetricFilterTestSuite) TestIncludeExcludeRename(t *C) {
+	config := mm.Config{
+		Report:        60,
+		MetricInclude: []string{`^mysql/db\.`},
+		MetricExclude: []string{`^mysql/db\.tmp_`},
+		MetricRename: []mm.MetricRename{
+			{Match: `^mysql/db\.(\w+)\.(\w+)/rows_read$`, Replace: "mysql/table/$1/$2/rows_read"},
+		},
+	}
+	f, err := mm.NewMetricFilter(s.logger, config)
+	t.Assert(err, IsNil)
+
+	c := &mm.Collection{
+		ServiceInstance: proto.ServiceInstance{Service: "mysql", InstanceId: 1},
+		Ts:              60,
+		Metrics: []mm.Metric{
+			{Name: "mysql/threads_running", Type: "gauge", Number: 1},
+			{Name: "mysql/db.app.users/rows_read", Type: "counter", Number: 10},
+			{Name: "mysql/db.app.users/rows_changed", Type: "counter", Number: 5},
+			{Name: "mysql/db.tmp_1.t/rows_read", Type: "counter", Number: 10},
+		},
+	}
+	got := f.Filter(c)
+	t.Check(got.ServiceInstance, DeepEquals, c.ServiceInstance)
+	t.Check(got.Ts, Equals, c.Ts)
+	t.Check(metricNames(got), DeepEquals, []string{
+		"mysql/table/app/users/rows_read",
+		"mysql/db.app.users/rows_changed",
+	})
+	t.Check(got.Metrics[0].Number, Equals, float64(10))
+
+	// The original collection isn't changed.
+	t.Check(c.Metrics[1].Name, Equals, "mysql/db.app.users/rows_read")
+
+	// Bad regexp.
+	config.MetricExclude = []string{"("}
+	_, err = mm.NewMetricFilter(s.logger, config)
+	t.Check(err, NotNil)
+}
+
+func (s *MetricFilterTestSuite) TestMaxMetrics(t *C) {
+	f, err := mm.NewMetricFilter(s.logger, mm.Config{Report: 60, MaxMetrics: 2})
+	t.Assert(err, IsNil)
+
+	collection := func(ts int64, a, b, c float64) *mm.Collection {
+		return &mm.Collection{
+			Ts: ts,
+			Metrics: []mm.Metric{
+				{Name: "a", Type: "counter", Number: a},
+				{Name: "b", Type: "counter", Number: b},
+				{Name: "c", Type: "counter", Number: c},
+			},
+		}
+	}
+
+	// 1st interval: no activity yet, so first 2 metrics.
+	got := f.Filter(collection(60, 0, 0, 0))
+	t.Check(metricNames(got), DeepEquals, []string{"a", "b"})
+	got = f.Filter(collection(90, 1, 0, 100))
+	t.Check(metricNames(got), DeepEquals, []string{"a", "b"})
+
+	// 2nd interval: top 2 by activity in 1st interval: c (100), a (1).
+	got = f.Filter(collection(120, 1, 50, 100))
+	t.Check(metricNames(got), DeepEquals, []string{"a", "c"})
+	got = f.Filter(collection(150, 1, 100, 101))
+	t.Check(metricNames(got), DeepEquals, []string{"a", "c"})
+
+	// 3rd interval: b (100), c (1).  a has no activity.
+	got = f.Filter(collection(180, 1, 100, 101))
+	t.Check(metricNames(got), DeepEquals, []string{"b", "c"})
+}
+
+func (s *MetricFilterTestSuite) TestDerived(t *C) {
+	config := mm.Config{
+		Report:        60,
+		MetricExclude: []string{"^mysql/innodb_buffer_pool_read"},
+		Derived: []mm.DerivedMetric{
+			// Counters ratio: per-interval rates, gauge
+			{Name: "mysql/bp_hit_rate", Expr: "1 - mysql/innodb_buffer_pool_reads / mysql/innodb_buffer_pool_read_requests"},
+			// Counters added: counter
+			{Name: "mysql/com_writes", Expr: "mysql/com_insert + mysql/com_update"},
+			// Gauges: gauge
+			{Name: "mysql/threads_idle_pct", Expr: "(mysql/threads_connected-mysql/threads_running)*100 / mysql/threads_connected"},
+		},
+	}
+	f, err := mm.NewMetricFilter(s.logger, config)
+	t.Assert(err, IsNil)
+
+	collection := func(ts int64, reads, requests, connected float64) *mm.Collection {
+		return &mm.Collection{
+			Ts: ts,
+			Metrics: []mm.Metric{
+				{Name: "mysql/innodb_buffer_pool_reads", Type: "counter", Number: reads},
+				{Name: "mysql/innodb_buffer_pool_read_requests", Type: "counter", Number: requests},
+				{Name: "mysql/com_insert", Type: "counter", Number: 10},
+				{Name: "mysql/com_update", Type: "counter", Number: 5},
+				{Name: "mysql/threads_connected", Type: "gauge", Number: connected},
+				{Name: "mysql/threads_running", Type: "gauge", Number: 1},
+			},
+		}
+	}
+
+	// 1st collection: no previous counter values, so no hit rate.  Derived
+	// metrics are reported even though their metrics are excluded.
+	got := f.Filter(collection(60, 100, 1000, 4))
+	t.Check(got.Metrics[4:], DeepEquals, []mm.Metric{
+		{Name: "mysql/com_writes", Type: "counter", Number: 15},
+		{Name: "mysql/threads_idle_pct", Type: "gauge", Number: 75},
+	})
+
+	// 2nd: 10 reads / 100 requests in the interval.
+	got = f.Filter(collection(70, 110, 1100, 4))
+	t.Check(got.Metrics[4:], DeepEquals, []mm.Metric{
+		{Name: "mysql/bp_hit_rate", Type: "gauge", Number: 0.9},
+		{Name: "mysql/com_writes", Type: "counter", Number: 15},
+		{Name: "mysql/threads_idle_pct", Type: "gauge", Number: 75},
+	})
+
+	// 3rd: no requests (division by zero) and no connections.
+	got = f.Filter(collection(80, 110, 1100, 0))
+	t.Check(metricNames(got)[4:], DeepEquals, []string{"mysql/com_writes"})
+
+	// 4th: counters reset, e.g. FLUSH STATUS.
+	got = f.Filter(collection(90, 1, 10, 4))
+	t.Check(metricNames(got)[4:], DeepEquals, []string{"mysql/com_writes", "mysql/threads_idle_pct"})
+
+	// 5th: counting again.
+	got = f.Filter(collection(100, 3, 20, 4))
+	t.Check(got.Metrics[4], DeepEquals, mm.Metric{Name: "mysql/bp_hit_rate", Type: "gauge", Number: 0.8})
+
+	// Missing metric: no value.
+	got = f.Filter(&mm.Collection{Ts: 110, Metrics: []mm.Metric{{Name: "mysql/com_insert", Type: "counter", Number: 10}}})
+	t.Check(metricNames(got), DeepEquals, []string{"mysql/com_insert"})
+
+	// Invalid expressions.
+	for _, expr := range []string{"", "1 +", "(a + b", "a + 2)", "a $ b", "1 + 2"} {
+		config.Derived = []mm.DerivedMetric{{Name: "x", Expr: expr}}
+		_, err = mm.NewMetricFilter(s.logger, config)
+		t.Check(err, NotNil, Commentf(expr))
+	}
+	config.Derived = []mm.DerivedMetric{{Name: "x", Expr: "a"}, {Name: "x", Expr: "b"}}
+	_, err = mm.NewMetricFilter(s.logger, config)
+	t.Check(err, NotNil)
+	config.Derived = []mm.DerivedMetric{{Expr: "a"}}
+	_, err = mm.NewMetricFilter(s.logger, config)
+	t.Check(err, NotNil)
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Manager test suite
 /////////////////////////////////////////////////////////////////////////////
@@ -732,3 +1601,261 @@ func (s *ManagerTestSuite) TestGetConfig(t *C) {
 		t.Error(diff)
 	}
 }
+
+func (s *ManagerTestSuite) TestAlertRules(t *C) {
+	m := mm.NewManager(s.logger, s.factory, s.clock, s.spool, s.im)
+	t.Assert(m, NotNil)
+	err := m.Start()
+	t.Assert(err, IsNil)
+	defer m.Stop()
+
+	rules := []mm.AlertRule{
+		{Name: "threads", Metric: "mysql/threads_running", Stat: "Pct95", Op: ">", Value: 50, For: 3},
+	}
+	data, err := json.Marshal(rules)
+	t.Assert(err, IsNil)
+	cmd := &proto.Cmd{
+		Service: "mm",
+		Cmd:     "SetAlertRules",
+		Data:    data,
+	}
+	reply := m.Handle(cmd)
+	t.Assert(reply, NotNil)
+	t.Check(reply.Error, Equals, "")
+
+	// Rules are saved so they're loaded on restart.
+	gotRules := []mm.AlertRule{}
+	err = pct.Basedir.ReadConfig("alert", &gotRules)
+	t.Check(err, IsNil)
+	t.Check(gotRules, DeepEquals, rules)
+
+	cmd = &proto.Cmd{
+		Service: "mm",
+		Cmd:     "GetAlertRules",
+	}
+	reply = m.Handle(cmd)
+	t.Assert(reply.Error, Equals, "")
+	gotRules = []mm.AlertRule{}
+	err = json.Unmarshal(reply.Data, &gotRules)
+	t.Check(err, IsNil)
+	t.Check(gotRules, DeepEquals, rules)
+
+	// Invalid rules are not set or saved.
+	cmd = &proto.Cmd{
+		Service: "mm",
+		Cmd:     "SetAlertRules",
+		Data:    []byte(`[{"Name": "threads", "Metric": "mysql/threads_running", "Stat": "Pct99", "Op": ">"}]`),
+	}
+	reply = m.Handle(cmd)
+	t.Check(reply.Error, Not(Equals), "")
+	gotRules = []mm.AlertRule{}
+	err = pct.Basedir.ReadConfig("alert", &gotRules)
+	t.Check(err, IsNil)
+	t.Check(gotRules, DeepEquals, rules)
+
+	// A new manager loads the saved rules.
+	m2 := mm.NewManager(s.logger, s.factory, s.clock, s.spool, s.im)
+	err = m2.Start()
+	t.Assert(err, IsNil)
+	defer m2.Stop()
+	reply = m2.Handle(&proto.Cmd{Service: "mm", Cmd: "GetAlertRules"})
+	t.Assert(reply.Error, Equals, "")
+	gotRules = []mm.AlertRule{}
+	err = json.Unmarshal(reply.Data, &gotRules)
+	t.Check(err, IsNil)
+	t.Check(gotRules, DeepEquals, rules)
+}
+
+func (s *ManagerTestSuite) TestUnsynchronizedMonitor(t *C) {
+	schemaMonitor := mock.NewMmMonitor()
+	schemaMonitor.Unsynchronized = true
+	factory := mock.NewMmMonitorFactory(map[string]mm.Monitor{"mysql-1": schemaMonitor})
+	m := mm.NewManager(s.logger, factory, s.clock, s.spool, s.im)
+	err := m.Start()
+	t.Assert(err, IsNil)
+	defer m.Stop()
+
+	config := &mm.Config{
+		ServiceInstance: proto.ServiceInstance{
+			Service:    "mysql",
+			InstanceId: 1,
+		},
+		Monitor: "schema",
+		Collect: 600,
+		Report:  60,
+	}
+	schemaMonitor.SetConfig(config)
+	data, err := json.Marshal(config)
+	t.Assert(err, IsNil)
+	reply := m.Handle(&proto.Cmd{Service: "mm", Cmd: "StartService", Data: data})
+	t.Assert(reply.Error, Equals, "")
+	defer m.Handle(&proto.Cmd{Service: "mm", Cmd: "StopService", Data: data})
+
+	// It reports at its collect interval, so its metrics aren't stale
+	// in the 60s reports between its collections.
+	begin := int64(1388577600) // 2014-01-01 12:00:00
+	for _, ts := range []int64{begin + 5, begin + 605, begin + 1205} {
+		schemaMonitor.CollectionChan <- &mm.Collection{
+			ServiceInstance: config.ServiceInstance,
+			Ts:              ts,
+			Metrics:         []mm.Metric{{Name: "mysql/schema/app/size", Type: "gauge", Number: 100}},
+		}
+	}
+	for _, ts := range []int64{begin, begin + 600} {
+		got := test.WaitMmReport(s.dataChan)
+		t.Assert(got, NotNil)
+		t.Check(got.Ts, Equals, time.Unix(ts, 0).UTC())
+		t.Check(got.Duration, Equals, uint(600))
+		t.Check(got.Stats[0].Stats["mysql/schema/app/size"].Stale, Equals, false)
+	}
+
+	// Its collect interval can't change while running.
+	newConfig := *config
+	newConfig.Collect = 1200
+	data, err = json.Marshal(newConfig)
+	t.Assert(err, IsNil)
+	reply = m.Handle(&proto.Cmd{Service: "mm", Cmd: "SetConfig", Data: data})
+	t.Check(reply.Error, Not(Equals), "")
+}
+
+func (s *ManagerTestSuite) TestGetMetrics(t *C) {
+	m := mm.NewManager(s.logger, s.factory, s.clock, s.spool, s.im)
+	t.Assert(m, NotNil)
+	err := m.Start()
+	t.Assert(err, IsNil)
+	defer m.Stop()
+
+	mmConfig := &mysql.Config{
+		Config: mm.Config{
+			ServiceInstance: proto.ServiceInstance{
+				Service:    "mysql",
+				InstanceId: 1,
+			},
+			Collect: 1,
+			Report:  60,
+		},
+	}
+	data, err := json.Marshal(mmConfig)
+	t.Assert(err, IsNil)
+	s.mysqlMonitor.SetConfig(mmConfig)
+	reply := m.Handle(&proto.Cmd{Service: "mm", Cmd: "StartService", Data: data})
+	t.Assert(reply.Error, Equals, "")
+	defer m.Handle(&proto.Cmd{Service: "mm", Cmd: "StopService", Data: data})
+
+	// All in one report interval, so the aggregator doesn't report.
+	now := int64(1388577630) // 2014-01-01 12:00:30
+	for ts := now - 2; ts <= now; ts++ {
+		s.mysqlMonitor.CollectionChan <- &mm.Collection{
+			ServiceInstance: mmConfig.ServiceInstance,
+			Ts:              ts,
+			Metrics: []mm.Metric{
+				{Name: "mysql/threads_running", Type: "gauge", Number: float64(ts - now + 3)},
+				{Name: "mysql/threads_connected", Type: "gauge", Number: 10},
+			},
+		}
+	}
+
+	// The aggregator receives collections asynchronously.
+	q := mm.MetricsQuery{Metric: "mysql/threads_running", Begin: time.Unix(now-1, 0)}
+	data, err = json.Marshal(q)
+	t.Assert(err, IsNil)
+	got := []*mm.Collection{}
+	for i := 0; i < 20; i++ {
+		reply = m.Handle(&proto.Cmd{Service: "mm", Cmd: "GetMetrics", Data: data})
+		t.Assert(reply.Error, Equals, "")
+		err = json.Unmarshal(reply.Data, &got)
+		t.Assert(err, IsNil)
+		if len(got) == 2 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Assert(got, HasLen, 2)
+	t.Check(got[0].Ts, Equals, now-1)
+	t.Check(got[0].Metrics, DeepEquals, []mm.Metric{{Name: "mysql/threads_running", Type: "gauge", Number: 2}})
+	t.Check(got[1].Ts, Equals, now)
+
+	// Invalid query.
+	reply = m.Handle(&proto.Cmd{Service: "mm", Cmd: "GetMetrics", Data: []byte(`{"Metric": "mysql/["}`)})
+	t.Check(reply.Error, Not(Equals), "")
+}
+
+func (s *ManagerTestSuite) TestSetConfig(t *C) {
+	m := mm.NewManager(s.logger, s.factory, s.clock, s.spool, s.im)
+	t.Assert(m, NotNil)
+	err := m.Start()
+	t.Assert(err, IsNil)
+	defer m.Stop()
+
+	mmConfig := &mysql.Config{
+		Config: mm.Config{
+			ServiceInstance: proto.ServiceInstance{
+				Service:    "mysql",
+				InstanceId: 1,
+			},
+			Collect: 1,
+			Report:  60,
+		},
+		Status: map[string]string{
+			"threads_running": "gauge",
+		},
+	}
+	data, err := json.Marshal(mmConfig)
+	t.Assert(err, IsNil)
+	s.mysqlMonitor.SetConfig(mmConfig)
+	reply := m.Handle(&proto.Cmd{Service: "mm", Cmd: "StartService", Data: data})
+	t.Assert(reply.Error, Equals, "")
+
+	// Collect every 10s instead of 1s and collect another status var.
+	// The mock monitor's config is mmConfig, so make a new one.
+	newConfig := *mmConfig
+	newConfig.Collect = 10
+	newConfig.Status = map[string]string{
+		"threads_connected": "gauge",
+		"threads_running":   "gauge",
+	}
+	mmConfig = &newConfig
+	data, err = json.Marshal(mmConfig)
+	t.Assert(err, IsNil)
+	reply = m.Handle(&proto.Cmd{Service: "mm", Cmd: "SetConfig", Data: data})
+	t.Assert(reply.Error, Equals, "")
+
+	// The monitor is still running and its tickChan was re-added at the new interval.
+	t.Check(m.Status()["monitor"], Equals, "Running")
+	t.Check(s.clock.Removed, HasLen, 1)
+	t.Check(s.clock.Added, DeepEquals, []uint{1, 10})
+
+	// The new config is saved.
+	gotConfig := &mysql.Config{}
+	err = pct.Basedir.ReadConfig("mm-mysql-1", gotConfig)
+	t.Assert(err, IsNil)
+	if same, diff := test.IsDeeply(gotConfig, mmConfig); !same {
+		test.Dump(gotConfig)
+		t.Error(diff)
+	}
+
+	// The report interval can't be changed.
+	mmConfig.Report = 300
+	data, err = json.Marshal(mmConfig)
+	t.Assert(err, IsNil)
+	reply = m.Handle(&proto.Cmd{Service: "mm", Cmd: "SetConfig", Data: data})
+	t.Check(reply.Error, Not(Equals), "")
+
+	// Nor the metric rules.
+	mmConfig.Report = 60
+	mmConfig.MetricExclude = []string{"^mysql/threads_connected$"}
+	data, err = json.Marshal(mmConfig)
+	t.Assert(err, IsNil)
+	reply = m.Handle(&proto.Cmd{Service: "mm", Cmd: "SetConfig", Data: data})
+	t.Check(reply.Error, Not(Equals), "")
+
+	// Unknown monitor.
+	data, err = json.Marshal(&mm.Config{
+		ServiceInstance: proto.ServiceInstance{Service: "server", InstanceId: 1},
+		Collect:         10,
+		Report:          60,
+	})
+	t.Assert(err, IsNil)
+	reply = m.Handle(&proto.Cmd{Service: "mm", Cmd: "SetConfig", Data: data})
+	t.Check(reply.Error, Not(Equals), "")
+}
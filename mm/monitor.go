@@ -41,22 +41,58 @@ type Monitor interface {
 	Config() interface{}
 }
 
+/**
+ * A Monitor that can be re-configured while running, without stopping it.
+ * Reconfigure decodes and validates the monitor-specific config like the
+ * factory does, then applies it before the next collect.  The manager handles
+ * the mm.Config part: it re-registers the tick chan if Collect changes.
+ */
+type Reconfigurable interface {
+	Reconfigure(data []byte) error
+}
+
+/**
+ * A Monitor that collects at a long interval, e.g. every 30 minutes, and is
+ * not Synchronized gets an unsynchronized tick chan like sysconfig monitors,
+ * so it doesn't collect at the same time as every other monitor.  If it
+ * collects less often than it reports, it reports at its collect interval,
+ * so its metrics aren't stale in the reports between its collections.
+ */
+type Synchronizer interface {
+	Synchronized() bool
+}
+
+// Synchronized returns true if the monitor's tick chan should be synchronized.
+func Synchronized(monitor Monitor) bool {
+	if s, ok := monitor.(Synchronizer); ok {
+		return s.Synchronized()
+	}
+	return true
+}
+
 type MonitorFactory interface {
 	Make(service string, instanceId uint, data []byte) (Monitor, error)
 }
 
 var MetricTypes map[string]bool = map[string]bool{
-	"gauge":   true,
-	"counter": true,
+	"gauge":     true,
+	"counter":   true,
+	"string":    true,
+	"histogram": true,
 }
 
 // A single metric and its value at any time.  Monitors are responsible for
 // getting these and sending them as a Collection to an aggregator.
 type Metric struct {
 	Name   string // mysql/status/Threads_running
-	Type   string // gauge, counter, string
+	Type   string // gauge, counter, string, histogram
 	Number float64
 	String string
+	// Histogram buckets: Counts[i] is the number of values > Bounds[i-1] and
+	// <= Bounds[i], and the last count is values > all bounds, so len(Counts)
+	// = len(Bounds) + 1.  Counts are counters, e.g. since the server started.
+	Bounds []float64 `json:",omitempty"`
+	Counts []float64 `json:",omitempty"`
 }
 
 // All metrics from a service instance collected at the same time.
@@ -71,7 +107,17 @@ type Collection struct {
 // Stats for each metric from a service instance, computed at each report interval.
 type InstanceStats struct {
 	proto.ServiceInstance
-	Stats map[string]*Stats // keyed on metric name
+	Stats    map[string]*Stats // keyed on metric name
+	Coverage *Coverage         `json:",omitempty"` // only if the instance's monitors are known
+}
+
+// How many collections an aggregator expected and received from an instance
+// during a report interval, and why some are missing.
+type Coverage struct {
+	Expected uint
+	Received uint
+	Late     uint     `json:",omitempty"` // collections for past intervals, only gauges counted
+	Missing  []string `json:",omitempty"` // reasons
 }
 
 type Report struct {
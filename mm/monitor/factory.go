@@ -23,7 +23,11 @@ import (
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/instance"
 	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/agent"
+	"github.com/percona/percona-agent/mm/command"
 	"github.com/percona/percona-agent/mm/mysql"
+	"github.com/percona/percona-agent/mm/query"
+	"github.com/percona/percona-agent/mm/schema"
 	"github.com/percona/percona-agent/mm/system"
 	mysqlConn "github.com/percona/percona-agent/mysql"
 	"github.com/percona/percona-agent/pct"
@@ -43,8 +47,18 @@ func NewFactory(logChan chan *proto.LogEntry, ir *instance.Repo) *Factory {
 }
 
 func (f *Factory) Make(service string, instanceId uint, data []byte) (mm.Monitor, error) {
+	// Most services have one monitor, but some have others, e.g. mysql-query.
+	mmConfig := &mm.Config{}
+	if err := json.Unmarshal(data, mmConfig); err != nil {
+		return nil, err
+	}
+	monitorType := service
+	if mmConfig.Monitor != "" {
+		monitorType += "-" + mmConfig.Monitor
+	}
+
 	var monitor mm.Monitor
-	switch service {
+	switch monitorType {
 	case "mysql":
 		// Load the MySQL instance info (DSN, name, etc.).
 		mysqlIt := &proto.MySQLInstance{}
@@ -68,6 +82,48 @@ func (f *Factory) Make(service string, instanceId uint, data []byte) (mm.Monitor
 			pct.NewLogger(f.logChan, alias),
 			mysqlConn.NewConnection(mysqlIt.DSN),
 		)
+	case "mysql-query":
+		mysqlIt := &proto.MySQLInstance{}
+		if err := f.ir.Get(service, instanceId, mysqlIt); err != nil {
+			return nil, err
+		}
+
+		// Parse the user-defined queries config.
+		config := &query.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		alias := "mm-mysql-query-" + mysqlIt.Hostname
+
+		// Make a MySQL query metrics monitor.
+		monitor = query.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			mysqlConn.NewConnection(mysqlIt.DSN),
+		)
+	case "mysql-schema":
+		mysqlIt := &proto.MySQLInstance{}
+		if err := f.ir.Get(service, instanceId, mysqlIt); err != nil {
+			return nil, err
+		}
+
+		// Parse the schema and table sizes config.
+		config := &schema.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		alias := "mm-mysql-schema-" + mysqlIt.Hostname
+
+		// Make a schema and table growth monitor.
+		monitor = schema.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			mysqlConn.NewConnection(mysqlIt.DSN),
+		)
 	case "server":
 		// Parse the system mm config.
 		config := &system.Config{}
@@ -84,8 +140,38 @@ func (f *Factory) Make(service string, instanceId uint, data []byte) (mm.Monitor
 			config,
 			pct.NewLogger(f.logChan, alias),
 		)
+	case "server-command":
+		// Parse the external commands config.
+		config := &command.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		alias := "mm-command"
+
+		// Make an external command metrics monitor.
+		monitor = command.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+		)
+	case "agent":
+		// Parse the agent self-monitoring config.
+		config := &agent.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		alias := "mm-agent"
+
+		// Make an agent metrics monitor.
+		monitor = agent.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+		)
 	default:
-		return nil, errors.New("Unknown metrics monitor type: " + service)
+		return nil, errors.New("Unknown metrics monitor type: " + monitorType)
 	}
 	return monitor, nil
 }
@@ -23,8 +23,20 @@ import (
 
 type Config struct {
 	mm.Config
-	Status            map[string]string // SHOW STATUS variables to collect, case-sensitive
-	InnoDB            []string          // SET GLOBAL innodb_monitor_enable="<value>"
-	UserStats         bool              // SET GLOBAL userstat=ON|OFF
-	UserStatsIgnoreDb string
+	Status              map[string]string // SHOW STATUS variables to collect, case-sensitive
+	InnoDB              []string          // SET GLOBAL innodb_monitor_enable="<value>"
+	UserStats           bool              // SET GLOBAL userstat=ON|OFF
+	UserStatsIgnoreDb   string
+	InnoDBStatus        bool // SHOW ENGINE INNODB STATUS
+	Replication         bool // SHOW SLAVE STATUS
+	Processlist         bool // INFORMATION_SCHEMA.PROCESSLIST summary
+	ProcesslistMaxUsers uint // max users in mysql/processlist/user/, 0 = DEFAULT_PROCESSLIST_MAX_USERS
+	PerfSchema          bool
+	PerfSchemaInclude   []string // regexp, see PerfSchemaFilter
+	PerfSchemaExclude   []string // regexp, see PerfSchemaFilter
+	Process             bool     // mysqld /proc/<pid> and cgroup metrics
+	PidFile             string   // mysqld PID file, default @@pid_file
+	Filesystems         bool     // mysql/fs/<dir>: fs/<name> of datadir, tmpdir, etc.
+	Galera              bool     // SHOW STATUS LIKE 'wsrep_%', Galera/PXC cluster metrics
+	QueryResponseTime   bool     // INFORMATION_SCHEMA.QUERY_RESPONSE_TIME histograms, Percona Server
 }
@@ -0,0 +1,145 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/system"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// --------------------------------------------------------------------------
+// MySQL dirs and the filesystems they're on.  The system monitor reports
+// the filesystems (fs/<name>/...); this tells which ones MySQL uses.
+// --------------------------------------------------------------------------
+
+// MySQL variables that are dirs or files, keyed on the dir name reported
+// in mysql/fs/<dir>.  Some are not set or don't exist in older versions.
+var dirVars = map[string]string{
+	"datadir":                   "datadir",
+	"tmpdir":                    "tmpdir",
+	"innodb_data_home_dir":      "innodb_data_home_dir",
+	"innodb_log_group_home_dir": "innodb_log_group_home_dir",
+	"log_bin_basename":          "log_bin",
+	"relay_log_basename":        "relay_log",
+	"log_error":                 "log_error",
+	"slow_query_log_file":       "slow_query_log",
+	"general_log_file":          "general_log",
+}
+
+// @goroutine[3]
+func (m *Monitor) getDirs(conn *sql.DB) (map[string]string, error) {
+	names := make([]string, 0, len(dirVars))
+	for name := range dirVars {
+		names = append(names, "'"+name+"'")
+	}
+	sort.Strings(names)
+	rows, err := conn.Query("SHOW GLOBAL VARIABLES WHERE Variable_name IN (" + strings.Join(names, ",") + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	vars := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		vars[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return MySQLDirs(vars), nil
+}
+
+/**
+ * MySQLDirs returns absolute dir paths keyed on dir name (see dirVars) from
+ * the MySQL variables.  Relative paths are relative to datadir.  Variables
+ * that are files, like log_error, are the file's dir.  tmpdir can be several
+ * dirs separated by colons; only the first is used.  Empty values and
+ * log_error=stderr are ignored.
+ */
+func MySQLDirs(vars map[string]string) map[string]string {
+	datadir := vars["datadir"]
+	dirs := make(map[string]string)
+	for name, value := range vars {
+		dir, ok := dirVars[name]
+		if !ok || value == "" || value == "stderr" {
+			continue
+		}
+		path := value
+		if name == "tmpdir" {
+			path = strings.Split(path, ":")[0]
+		}
+		if !filepath.IsAbs(path) {
+			if datadir == "" {
+				continue
+			}
+			path = filepath.Join(datadir, path)
+		}
+		switch name {
+		case "log_bin_basename", "relay_log_basename", "log_error", "slow_query_log_file", "general_log_file":
+			path = filepath.Dir(path)
+		}
+		dirs[dir] = filepath.Clean(path)
+	}
+	return dirs
+}
+
+// @goroutine[2]
+func (m *Monitor) GetFsMetrics(c *mm.Collection) error {
+	m.logger.Debug("GetFsMetrics:call")
+	defer m.logger.Debug("GetFsMetrics:return")
+
+	m.status.Update(m.name, "Getting MySQL filesystems")
+
+	// Mounts can change, so read them every time.
+	mounts, err := system.ReadMounts("/proc/mounts")
+	if err != nil {
+		return err
+	}
+	c.Metrics = append(c.Metrics, DirMountMetrics(m.dirs, mounts)...)
+	return nil
+}
+
+// DirMountMetrics returns a mysql/fs/<dir> string metric for each dir whose
+// value is the name of the filesystem it's on, the same name as the system
+// monitor's fs/<name> metrics (see system.FsNames), e.g. mysql/fs/datadir is
+// "var-lib-mysql" for fs/var-lib-mysql/bytes_free.  The metrics are sorted by
+// name.
+func DirMountMetrics(dirs map[string]string, mounts []system.Mount) []mm.Metric {
+	names := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		names = append(names, dir)
+	}
+	sort.Strings(names)
+	fsNames := system.FsNames(mounts)
+	metrics := []mm.Metric{}
+	for _, dir := range names {
+		mount, ok := system.MountPoint(mounts, dirs[dir])
+		if !ok {
+			continue
+		}
+		metrics = append(metrics, mm.Metric{Name: "mysql/fs/" + dir, Type: "string", String: fsNames[mount.Path]})
+	}
+	return metrics
+}
@@ -0,0 +1,200 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/percona/percona-agent/mm"
+	"sort"
+	"strings"
+)
+
+// --------------------------------------------------------------------------
+// Galera and Percona XtraDB Cluster
+// http://galeracluster.com/documentation-webpages/galerastatusvariables.html
+// --------------------------------------------------------------------------
+
+// wsrep_ status vars (without the prefix) and their metric types.  Other
+// wsrep_ vars are ignored.
+var GaleraMetrics map[string]string = map[string]string{
+	// Cluster
+	"cluster_size":   "gauge",
+	"cluster_status": "string", // Primary, Non-Primary, Disconnected
+	// Node
+	"local_state":         "gauge",
+	"local_state_comment": "string", // Joining, Donor/Desynced, Joined, Synced
+	"ready":               "string", // ON, OFF
+	"connected":           "string", // ON, OFF
+	// Replication queues
+	"local_recv_queue":     "gauge",
+	"local_recv_queue_avg": "gauge",
+	"local_recv_queue_max": "gauge",
+	"local_send_queue":     "gauge",
+	"local_send_queue_avg": "gauge",
+	"local_send_queue_max": "gauge",
+	"replicated":           "counter",
+	"replicated_bytes":     "counter",
+	"received":             "counter",
+	"received_bytes":       "counter",
+	"apply_window":         "gauge",
+	"commit_window":        "gauge",
+	// Flow control
+	"flow_control_paused":    "gauge", // fraction of time paused since last SHOW STATUS
+	"flow_control_paused_ns": "counter",
+	"flow_control_sent":      "counter",
+	"flow_control_recv":      "counter",
+	// Certification
+	"cert_deps_distance":  "gauge",
+	"cert_index_size":     "gauge",
+	"local_cert_failures": "counter",
+	"local_bf_aborts":     "counter",
+}
+
+// @goroutine[2]
+func (m *Monitor) GetGaleraMetrics(conn *sql.DB, c *mm.Collection) error {
+	m.logger.Debug("GetGaleraMetrics:call")
+	defer m.logger.Debug("GetGaleraMetrics:return")
+
+	m.status.Update(m.name, "Getting Galera metrics")
+
+	rows, err := conn.Query("SHOW GLOBAL STATUS LIKE 'wsrep\\_%'")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	status := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		status[strings.TrimPrefix(strings.ToLower(name), "wsrep_")] = value
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(status) == 0 {
+		return nil // not a Galera node
+	}
+
+	c.Metrics = append(c.Metrics, GaleraStatusMetrics(status)...)
+
+	// Log cluster membership and node state changes since the last collect.
+	if m.galeraStatus != nil {
+		for _, event := range GaleraEvents(m.galeraStatus, status) {
+			m.logger.Warn(event)
+		}
+	}
+	m.galeraStatus = status
+
+	return nil
+}
+
+/**
+ * GaleraStatusMetrics returns mysql/galera/<var> metrics, sorted by name, for
+ * the wsrep_ status vars (without the prefix) in GaleraMetrics.  String values
+ * like wsrep_cluster_status are string metrics, not numbers.
+ */
+func GaleraStatusMetrics(status map[string]string) []mm.Metric {
+	names := make([]string, 0, len(GaleraMetrics))
+	for name := range GaleraMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := []mm.Metric{}
+	for _, name := range names {
+		val, ok := status[name]
+		if !ok {
+			continue // older versions don't have all vars
+		}
+		metric := mm.Metric{Name: "mysql/galera/" + name, Type: GaleraMetrics[name]}
+		if metric.Type == "string" {
+			metric.String = val
+		} else {
+			metric.Number = strToFloat(val)
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics
+}
+
+/**
+ * GaleraEvents compares the wsrep_ status vars from two collects and returns
+ * a message for each change: cluster membership (wsrep_cluster_conf_id changes
+ * when nodes join or leave; wsrep_incoming_addresses tells which ones), and
+ * the node's state and cluster status.
+ */
+func GaleraEvents(prev, cur map[string]string) []string {
+	events := []string{}
+
+	if prev["cluster_conf_id"] != cur["cluster_conf_id"] {
+		joined, left := diffAddresses(prev["incoming_addresses"], cur["incoming_addresses"])
+		msg := fmt.Sprintf("Galera cluster membership changed: conf_id %s -> %s, size %s -> %s",
+			prev["cluster_conf_id"], cur["cluster_conf_id"], prev["cluster_size"], cur["cluster_size"])
+		if len(joined) > 0 {
+			msg += ", joined: " + strings.Join(joined, ",")
+		}
+		if len(left) > 0 {
+			msg += ", left: " + strings.Join(left, ",")
+		}
+		events = append(events, msg)
+	}
+
+	if prev["cluster_status"] != cur["cluster_status"] {
+		events = append(events, fmt.Sprintf("Galera cluster status changed: %s -> %s", prev["cluster_status"], cur["cluster_status"]))
+	}
+
+	if prev["local_state_comment"] != cur["local_state_comment"] {
+		events = append(events, fmt.Sprintf("Galera node state changed: %s -> %s", prev["local_state_comment"], cur["local_state_comment"]))
+	}
+
+	return events
+}
+
+// diffAddresses returns the wsrep_incoming_addresses in cur but not in prev
+// (joined) and vice versa (left), sorted.
+func diffAddresses(prev, cur string) (joined, left []string) {
+	prevSet := addressSet(prev)
+	curSet := addressSet(cur)
+	for addr := range curSet {
+		if !prevSet[addr] {
+			joined = append(joined, addr)
+		}
+	}
+	for addr := range prevSet {
+		if !curSet[addr] {
+			left = append(left, addr)
+		}
+	}
+	sort.Strings(joined)
+	sort.Strings(left)
+	return joined, left
+}
+
+func addressSet(addresses string) map[string]bool {
+	set := make(map[string]bool)
+	for _, addr := range strings.Split(addresses, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			set[addr] = true
+		}
+	}
+	return set
+}
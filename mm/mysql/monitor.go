@@ -19,12 +19,15 @@ package mysql
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/system"
 	"github.com/percona/percona-agent/mysql"
 	"github.com/percona/percona-agent/pct"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -43,6 +46,30 @@ type Monitor struct {
 	status         *pct.Status
 	sync           *pct.SyncChan
 	running        bool
+	// SHOW ENGINE INNODB STATUS
+	innodbStatusParsed bool
+	lastDeadlock       string
+	deadlocks          float64
+	// Performance Schema
+	pfsFilter *PerfSchemaFilter
+	// mysqld process
+	pidFile string
+	pid     int
+	cgroup  *system.Cgroup // of pid, nil if unknown
+	// MySQL dirs, e.g. datadir => /var/lib/mysql
+	dirs map[string]string
+	// Galera wsrep_ status vars from last collect
+	galeraStatus map[string]string
+	// INFORMATION_SCHEMA.INNODB_TRX failed, e.g. InnoDB is disabled
+	noInnoDBTrx bool
+	// New config from Reconfigure()
+	configChan chan newConfig
+}
+
+type newConfig struct {
+	config    *Config
+	pfsFilter *PerfSchemaFilter
+	done      chan bool
 }
 
 func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Connector) *Monitor {
@@ -55,6 +82,7 @@ func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Conn
 		connectedChan: make(chan bool, 1),
 		status:        pct.NewStatus([]string{name, name + "-mysql"}),
 		sync:          pct.NewSyncChan(),
+		configChan:    make(chan newConfig),
 	}
 	return m
 }
@@ -72,6 +100,14 @@ func (m *Monitor) Start(tickChan chan time.Time, collectionChan chan *mm.Collect
 		return pct.ServiceIsRunningError{m.name}
 	}
 
+	if m.config.PerfSchema {
+		filter, err := NewPerfSchemaFilter(m.config.PerfSchemaInclude, m.config.PerfSchemaExclude)
+		if err != nil {
+			return err
+		}
+		m.pfsFilter = filter
+	}
+
 	m.tickChan = tickChan
 	m.collectionChan = collectionChan
 
@@ -120,6 +156,40 @@ func (m *Monitor) Config() interface{} {
 	return m.config
 }
 
+/**
+ * Reconfigure applies a new config before the next collect, so the current
+ * interval's metrics aren't lost and MySQL isn't reconnected.  Only new global
+ * vars are set, e.g. for InnoDB modules that weren't enabled before.
+ */
+// @goroutine[0]
+func (m *Monitor) Reconfigure(data []byte) error {
+	m.logger.Debug("Reconfigure:call")
+	defer m.logger.Debug("Reconfigure:return")
+
+	if !m.running {
+		return fmt.Errorf("Not running")
+	}
+
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return err
+	}
+	var pfsFilter *PerfSchemaFilter
+	if config.PerfSchema {
+		var err error
+		pfsFilter, err = NewPerfSchemaFilter(config.PerfSchemaInclude, config.PerfSchemaExclude)
+		if err != nil {
+			return err
+		}
+	}
+
+	done := make(chan bool)
+	m.configChan <- newConfig{config, pfsFilter, done}
+	<-done
+	m.logger.Info("Reconfigured")
+	return nil
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Implementation
 /////////////////////////////////////////////////////////////////////////////
@@ -146,27 +216,7 @@ func (m *Monitor) connect(err error) {
 			continue
 		}
 
-		// Set global vars we need.  If these fail, that's ok: they won't work,
-		// but don't let that stop us from collecting other metrics.
-		if len(m.config.InnoDB) > 0 {
-			for _, module := range m.config.InnoDB {
-				sql := "SET GLOBAL innodb_monitor_enable = '" + module + "'"
-				if _, err := m.conn.DB().Exec(sql); err != nil {
-					m.logger.Error(sql, err)
-				}
-			}
-		}
-
-		if m.config.UserStats {
-			// 5.1.49 <= v <= 5.5.10: SET GLOBAL userstat_running=ON
-			// 5.5.10 <  v:           SET GLOBAL userstat=ON
-			sql := "SET GLOBAL userstat=ON"
-			if _, err := m.conn.DB().Exec(sql); err != nil {
-				m.logger.Error(sql, err)
-			}
-		}
-
-		// Tell run() goroutine that it can try to collect metrics.
+		// Tell run() goroutine that it can set up and try to collect metrics.
 		// If connection is lost, it will call us again.
 		m.logger.Info("Connected")
 		m.status.Update(m.name+"-mysql", "Connected")
@@ -175,6 +225,69 @@ func (m *Monitor) connect(err error) {
 	}
 }
 
+/**
+ * setup sets global vars and gets info needed for the new config, but only
+ * for what's not already done for the old config.  On connect, the old config
+ * is empty, so everything is done.  Nothing is undone: InnoDB modules and
+ * userstat aren't disabled because other tools may be using them, too.
+ */
+// @goroutine[2]
+func (m *Monitor) setup(conn *sql.DB, config, old *Config) {
+	enabled := make(map[string]bool)
+	for _, module := range old.InnoDB {
+		enabled[module] = true
+	}
+	for _, module := range config.InnoDB {
+		if enabled[module] {
+			continue
+		}
+		sql := "SET GLOBAL innodb_monitor_enable = '" + module + "'"
+		if _, err := conn.Exec(sql); err != nil {
+			m.logger.Error(sql, err)
+		}
+	}
+
+	// Get mysqld's PID file if not configured.  It's re-read on every
+	// collect so we get the new PID if mysqld restarts.
+	if config.Process && config.PidFile == "" && (!old.Process || old.PidFile != "") {
+		var pidFile, datadir string
+		sql := "SELECT @@pid_file, @@datadir"
+		if err := conn.QueryRow(sql).Scan(&pidFile, &datadir); err != nil {
+			m.logger.Error(sql, err)
+		} else {
+			if !filepath.IsAbs(pidFile) {
+				pidFile = filepath.Join(datadir, pidFile)
+			}
+			m.pidFile = pidFile
+		}
+	}
+
+	// Get MySQL dirs once per connection; they rarely change.
+	if config.Filesystems && !old.Filesystems {
+		if dirs, err := m.getDirs(conn); err != nil {
+			m.logger.Error(err)
+		} else {
+			m.dirs = dirs
+		}
+	}
+
+	if config.QueryResponseTime && !old.QueryResponseTime {
+		sql := "SET GLOBAL query_response_time_stats=ON"
+		if _, err := conn.Exec(sql); err != nil {
+			m.logger.Error(sql, err)
+		}
+	}
+
+	if config.UserStats && !old.UserStats {
+		// 5.1.49 <= v <= 5.5.10: SET GLOBAL userstat_running=ON
+		// 5.5.10 <  v:           SET GLOBAL userstat=ON
+		sql := "SET GLOBAL userstat=ON"
+		if _, err := conn.Exec(sql); err != nil {
+			m.logger.Error(sql, err)
+		}
+	}
+}
+
 // @goroutine[2]
 func (m *Monitor) run() {
 	m.logger.Debug("run:call")
@@ -230,6 +343,62 @@ func (m *Monitor) run() {
 				}
 			}
 
+			// SHOW ENGINE INNODB STATUS
+			if m.config.InnoDBStatus {
+				if err := m.GetInnoDBStatusMetrics(conn, c); err != nil {
+					m.logger.Warn(err)
+				}
+			}
+
+			// SHOW SLAVE STATUS
+			if m.config.Replication {
+				if err := m.GetReplicationMetrics(conn, c); err != nil {
+					m.logger.Warn(err)
+				}
+			}
+
+			// SELECT ... FROM INFORMATION_SCHEMA.PROCESSLIST
+			if m.config.Processlist {
+				if err := m.GetProcesslistMetrics(conn, c); err != nil {
+					m.logger.Warn(err)
+				}
+			}
+
+			// SELECT ... FROM performance_schema.*_summary_*
+			if m.config.PerfSchema {
+				if err := m.GetPerfSchemaMetrics(conn, c); err != nil {
+					m.logger.Warn(err)
+				}
+			}
+
+			// SHOW STATUS LIKE 'wsrep_%'
+			if m.config.Galera {
+				if err := m.GetGaleraMetrics(conn, c); err != nil {
+					m.logger.Warn(err)
+				}
+			}
+
+			// SELECT ... FROM INFORMATION_SCHEMA.QUERY_RESPONSE_TIME
+			if m.config.QueryResponseTime {
+				if err := m.GetQueryResponseTimeMetrics(conn, c); err != nil {
+					m.logger.Warn(err)
+				}
+			}
+
+			// /proc/<mysqld pid>/*
+			if m.config.Process {
+				if err := m.GetProcessMetrics(c); err != nil {
+					m.logger.Warn(err)
+				}
+			}
+
+			// /proc/mounts
+			if m.config.Filesystems {
+				if err := m.GetFsMetrics(c); err != nil {
+					m.logger.Warn(err)
+				}
+			}
+
 			if m.config.UserStats {
 				// SELECT ... FROM INFORMATION_SCHEMA.TABLE_STATISTICS
 				if err := m.getTableUserStats(conn, c, m.config.UserStatsIgnoreDb); err != nil {
@@ -251,6 +420,7 @@ func (m *Monitor) run() {
 				case <-time.After(500 * time.Millisecond):
 					// lost collection
 					m.logger.Debug("Lost MySQL metrics; timeout spooling after 500ms")
+					pct.Metrics.Inc("mm/lost_collections", 1)
 					lastError = "Spool timeout"
 				}
 			} else {
@@ -259,10 +429,22 @@ func (m *Monitor) run() {
 			}
 
 			m.logger.Debug("run:collect:stop")
+		case nc := <-m.configChan:
+			m.logger.Debug("run:config")
+			if m.connected {
+				m.setup(m.conn.DB(), nc.config, m.config)
+			} // else run() does setup with the new config when connected
+			m.config = nc.config
+			m.pfsFilter = nc.pfsFilter
+			close(nc.done)
 		case connected := <-m.connectedChan:
 			m.connected = connected
 			if connected {
 				m.logger.Debug("run:connected:true")
+				// Set global vars we need.  If these fail, that's ok: they won't
+				// work, but don't let that stop us from collecting other metrics.
+				m.setup(m.conn.DB(), m.config, &Config{})
+				m.noInnoDBTrx = false
 				m.status.Update(m.name, "Ready")
 			} else {
 				m.logger.Debug("run:connected:false")
@@ -305,12 +487,17 @@ func (m *Monitor) GetShowStatusMetrics(conn *sql.DB, c *mm.Collection) error {
 		}
 
 		metricName := statName
+		if metricType == "string" {
+			// E.g. wsrep_cluster_status=Primary
+			c.Metrics = append(c.Metrics, mm.Metric{Name: "mysql/" + metricName, Type: metricType, String: statValue})
+			continue
+		}
 		metricValue, err := strconv.ParseFloat(statValue, 64)
 		if err != nil {
 			metricValue = 0.0
 		}
 
-		c.Metrics = append(c.Metrics, mm.Metric{"mysql/" + metricName, metricType, metricValue, ""})
+		c.Metrics = append(c.Metrics, mm.Metric{Name: "mysql/" + metricName, Type: metricType, Number: metricValue})
 	}
 	err = rows.Err()
 	if err != nil {
@@ -319,6 +506,59 @@ func (m *Monitor) GetShowStatusMetrics(conn *sql.DB, c *mm.Collection) error {
 	return nil
 }
 
+// --------------------------------------------------------------------------
+// mysqld process
+// --------------------------------------------------------------------------
+
+// @goroutine[2]
+func (m *Monitor) GetProcessMetrics(c *mm.Collection) error {
+	m.logger.Debug("GetProcessMetrics:call")
+	defer m.logger.Debug("GetProcessMetrics:return")
+
+	m.status.Update(m.name, "Getting mysqld process metrics")
+
+	pidFile := m.config.PidFile
+	if pidFile == "" {
+		pidFile = m.pidFile
+	}
+	if pidFile == "" {
+		return fmt.Errorf("Unknown mysqld PID file")
+	}
+
+	// The PID file is stale if mysqld crashed, and the PID may have been
+	// reused by another process, so make sure it's mysqld.
+	pid, err := system.ReadPidFile(pidFile)
+	if err != nil {
+		return err
+	}
+	name, err := system.ProcessName("/proc", pid)
+	if err != nil {
+		return fmt.Errorf("mysqld PID %d from %s: %s", pid, pidFile, err)
+	}
+	if !strings.HasPrefix(name, "mysqld") {
+		return fmt.Errorf("PID %d from %s is %s, not mysqld", pid, pidFile, name)
+	}
+	if pid != m.pid {
+		m.logger.Info("mysqld PID", pid)
+		m.pid = pid
+		// mysqld's cgroup, if it can be resolved from here, else no
+		// mysql/process/cgroup/ metrics.
+		if m.cgroup, err = system.DetectCgroup("/", pid); err != nil {
+			m.logger.Debug("GetProcessMetrics:DetectCgroup:", err)
+		}
+	}
+
+	metrics, err := system.ProcessMetrics("/proc", pid, "mysql/process/")
+	if err != nil {
+		return err
+	}
+	c.Metrics = append(c.Metrics, metrics...)
+	if m.cgroup != nil {
+		c.Metrics = append(c.Metrics, m.cgroup.Metrics("mysql/process/")...)
+	}
+	return nil
+}
+
 // --------------------------------------------------------------------------
 // InnoDB Metrics
 // http://dev.mysql.com/doc/refman/5.6/en/innodb-metrics-table.html
@@ -358,7 +598,7 @@ func (m *Monitor) GetInnoDBMetrics(conn *sql.DB, c *mm.Collection) error {
 		} else {
 			metricType = "counter"
 		}
-		c.Metrics = append(c.Metrics, mm.Metric{metricName, metricType, metricValue, ""})
+		c.Metrics = append(c.Metrics, mm.Metric{Name: metricName, Type: metricType, Number: metricValue})
 	}
 	err = rows.Err()
 	if err != nil {
@@ -463,7 +703,7 @@ func (m *Monitor) getIndexUserStats(conn *sql.DB, c *mm.Collection, ignoreDb str
 
 		metricName := "mysql/db." + tableSchema + "/t." + tableName + "/idx." + indexName + "/rows_read"
 		metricValue := float64(rowsRead)
-		c.Metrics = append(c.Metrics, mm.Metric{metricName, "counter", metricValue, ""})
+		c.Metrics = append(c.Metrics, mm.Metric{Name: metricName, Type: "counter", Number: metricValue})
 	}
 	err = rows.Err()
 	if err != nil {
@@ -23,11 +23,14 @@ import (
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/mm"
 	"github.com/percona/percona-agent/mm/mysql"
+	"github.com/percona/percona-agent/mm/system"
 	mysqlConn "github.com/percona/percona-agent/mysql"
 	"github.com/percona/percona-agent/pct"
 	"github.com/percona/percona-agent/test"
+	"io/ioutil"
 	. "launchpad.net/gocheck"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -37,6 +40,8 @@ import (
  */
 var dsn = os.Getenv("PCT_TEST_MYSQL_DSN")
 
+var sample = test.RootDir + "/mm"
+
 // Hook up gocheck into the "go test" runner.
 func Test(t *testing.T) { TestingT(t) }
 
@@ -355,3 +360,707 @@ func (s *TestSuite) TestCollectUserstats(t *C) {
 	// Stop montior, clean up.
 	m.Stop()
 }
+
+/////////////////////////////////////////////////////////////////////////////
+// SHOW ENGINE INNODB STATUS
+/////////////////////////////////////////////////////////////////////////////
+
+type InnoDBStatusTestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&InnoDBStatusTestSuite{})
+
+func (s *InnoDBStatusTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 1000)
+	s.logger = pct.NewLogger(s.logChan, "mm-mysql-innodb-status-test")
+}
+
+func (s *InnoDBStatusTestSuite) SetUpTest(t *C) {
+	test.DrainLogChan(s.logChan)
+}
+
+// parseInnoDBStatus parses the sample file and returns the metrics without
+// the latest_deadlock string metric, which is returned separately.
+func parseInnoDBStatus(m *mysql.Monitor, file string) ([]mm.Metric, string, error) {
+	content, err := ioutil.ReadFile(sample + "/innodb/" + file)
+	if err != nil {
+		return nil, "", err
+	}
+	got, err := m.ParseInnoDBStatus(content)
+	if err != nil {
+		return nil, "", err
+	}
+	metrics := []mm.Metric{}
+	deadlock := ""
+	for _, metric := range got {
+		if metric.Name == "mysql/innodb_status/latest_deadlock" {
+			deadlock = metric.String
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, deadlock, nil
+}
+
+func (s *InnoDBStatusTestSuite) TestInnoDBStatus55(t *C) {
+	m := mysql.NewMonitor("", &mysql.Config{}, s.logger, nil)
+	got, deadlock, err := parseInnoDBStatus(m, "status-5.5.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 5.5 prints transaction IDs in hex: Trx id counter 50F = 1295.
+	expect := []mm.Metric{
+		{Name: "mysql/innodb_status/os_wait_array_reservation_count", Type: "counter", Number: 15},
+		{Name: "mysql/innodb_status/os_wait_array_signal_count", Type: "counter", Number: 14},
+		{Name: "mysql/innodb_status/mutex_spin_waits", Type: "counter", Number: 3},
+		{Name: "mysql/innodb_status/mutex_spin_rounds", Type: "counter", Number: 90},
+		{Name: "mysql/innodb_status/mutex_os_waits", Type: "counter", Number: 2},
+		{Name: "mysql/innodb_status/rw_shared_spin_waits", Type: "counter", Number: 12},
+		{Name: "mysql/innodb_status/rw_shared_spin_rounds", Type: "counter", Number: 360},
+		{Name: "mysql/innodb_status/rw_shared_os_waits", Type: "counter", Number: 11},
+		{Name: "mysql/innodb_status/rw_excl_spin_waits", Type: "counter", Number: 1},
+		{Name: "mysql/innodb_status/rw_excl_spin_rounds", Type: "counter", Number: 30},
+		{Name: "mysql/innodb_status/rw_excl_os_waits", Type: "counter", Number: 1},
+		{Name: "mysql/innodb_status/semaphore_waits", Type: "gauge", Number: 1},
+		{Name: "mysql/innodb_status/semaphore_max_wait_time", Type: "gauge", Number: 2},
+		{Name: "mysql/innodb_status/trx_id_counter", Type: "counter", Number: 1295},
+		{Name: "mysql/innodb_status/history_list_length", Type: "gauge", Number: 18},
+		{Name: "mysql/innodb_status/active_transactions", Type: "gauge", Number: 2},
+		{Name: "mysql/innodb_status/lock_wait_transactions", Type: "gauge", Number: 1},
+		{Name: "mysql/innodb_status/pending_normal_aio_reads", Type: "gauge", Number: 2},
+		{Name: "mysql/innodb_status/pending_normal_aio_writes", Type: "gauge", Number: 1},
+		{Name: "mysql/innodb_status/pending_ibuf_aio_reads", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_log_ios", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_sync_ios", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_log_fsyncs", Type: "gauge", Number: 1},
+		{Name: "mysql/innodb_status/pending_buffer_pool_fsyncs", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/os_file_reads", Type: "counter", Number: 431},
+		{Name: "mysql/innodb_status/os_file_writes", Type: "counter", Number: 57},
+		{Name: "mysql/innodb_status/os_fsyncs", Type: "counter", Number: 7},
+		{Name: "mysql/innodb_status/ibuf_size", Type: "gauge", Number: 1},
+		{Name: "mysql/innodb_status/ibuf_free_list_len", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/ibuf_seg_size", Type: "gauge", Number: 2},
+		{Name: "mysql/innodb_status/ibuf_merges", Type: "counter", Number: 5},
+		{Name: "mysql/innodb_status/hash_table_size", Type: "gauge", Number: 276671},
+		{Name: "mysql/innodb_status/hash_node_heap_buffers", Type: "gauge", Number: 1},
+		{Name: "mysql/innodb_status/log_sequence_number", Type: "counter", Number: 1626523},
+		{Name: "mysql/innodb_status/log_flushed_up_to", Type: "counter", Number: 1626500},
+		{Name: "mysql/innodb_status/last_checkpoint_at", Type: "counter", Number: 1626014},
+		{Name: "mysql/innodb_status/pending_log_writes", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_checkpoint_writes", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/log_ios_done", Type: "counter", Number: 10},
+		{Name: "mysql/innodb_status/checkpoint_age", Type: "gauge", Number: 509},
+		{Name: "mysql/innodb_status/log_unflushed", Type: "gauge", Number: 23},
+		{Name: "mysql/innodb_status/total_memory_allocated", Type: "gauge", Number: 137363456},
+		{Name: "mysql/innodb_status/dictionary_memory_allocated", Type: "gauge", Number: 31102},
+		{Name: "mysql/innodb_status/buffer_pool_pages_total", Type: "gauge", Number: 8191},
+		{Name: "mysql/innodb_status/buffer_pool_pages_free", Type: "gauge", Number: 7884},
+		{Name: "mysql/innodb_status/buffer_pool_pages_data", Type: "gauge", Number: 307},
+		{Name: "mysql/innodb_status/buffer_pool_pages_old", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pages_dirty", Type: "gauge", Number: 3},
+		{Name: "mysql/innodb_status/buffer_pool_pending_reads", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pending_writes_lru", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pending_writes_flush_list", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pending_writes_single_page", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pages_read", Type: "counter", Number: 293},
+		{Name: "mysql/innodb_status/buffer_pool_pages_created", Type: "counter", Number: 14},
+		{Name: "mysql/innodb_status/buffer_pool_pages_written", Type: "counter", Number: 53},
+		{Name: "mysql/innodb_status/queries_inside", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/queries_in_queue", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/read_views_open", Type: "gauge", Number: 2},
+		{Name: "mysql/innodb_status/rows_inserted", Type: "counter", Number: 5},
+		{Name: "mysql/innodb_status/rows_updated", Type: "counter", Number: 2},
+		{Name: "mysql/innodb_status/rows_deleted", Type: "counter", Number: 0},
+		{Name: "mysql/innodb_status/rows_read", Type: "counter", Number: 8},
+		{Name: "mysql/innodb_status/deadlocks", Type: "counter", Number: 0},
+	}
+	if ok, diff := test.IsDeeply(got, expect); !ok {
+		t.Error(diff)
+	}
+
+	t.Check(strings.HasPrefix(deadlock, "141006 11:58:12\n*** (1) TRANSACTION:"), Equals, true)
+	t.Check(strings.HasSuffix(deadlock, "*** WE ROLL BACK TRANSACTION (1)"), Equals, true)
+}
+
+func (s *InnoDBStatusTestSuite) TestInnoDBStatus56(t *C) {
+	m := mysql.NewMonitor("", &mysql.Config{}, s.logger, nil)
+	got, deadlock, err := parseInnoDBStatus(m, "status-5.6.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []mm.Metric{
+		{Name: "mysql/innodb_status/os_wait_array_reservation_count", Type: "counter", Number: 22},
+		{Name: "mysql/innodb_status/os_wait_array_signal_count", Type: "counter", Number: 21},
+		{Name: "mysql/innodb_status/mutex_spin_waits", Type: "counter", Number: 18},
+		{Name: "mysql/innodb_status/mutex_spin_rounds", Type: "counter", Number: 540},
+		{Name: "mysql/innodb_status/mutex_os_waits", Type: "counter", Number: 9},
+		{Name: "mysql/innodb_status/rw_shared_spin_waits", Type: "counter", Number: 13},
+		{Name: "mysql/innodb_status/rw_shared_spin_rounds", Type: "counter", Number: 390},
+		{Name: "mysql/innodb_status/rw_shared_os_waits", Type: "counter", Number: 12},
+		{Name: "mysql/innodb_status/rw_excl_spin_waits", Type: "counter", Number: 0},
+		{Name: "mysql/innodb_status/rw_excl_spin_rounds", Type: "counter", Number: 30},
+		{Name: "mysql/innodb_status/rw_excl_os_waits", Type: "counter", Number: 1},
+		{Name: "mysql/innodb_status/semaphore_waits", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/semaphore_max_wait_time", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/trx_id_counter", Type: "counter", Number: 1297},
+		{Name: "mysql/innodb_status/history_list_length", Type: "gauge", Number: 42},
+		{Name: "mysql/innodb_status/active_transactions", Type: "gauge", Number: 1},
+		{Name: "mysql/innodb_status/lock_wait_transactions", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_normal_aio_reads", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_normal_aio_writes", Type: "gauge", Number: 4},
+		{Name: "mysql/innodb_status/pending_ibuf_aio_reads", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_log_ios", Type: "gauge", Number: 1},
+		{Name: "mysql/innodb_status/pending_sync_ios", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_log_fsyncs", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_buffer_pool_fsyncs", Type: "gauge", Number: 2},
+		{Name: "mysql/innodb_status/os_file_reads", Type: "counter", Number: 584},
+		{Name: "mysql/innodb_status/os_file_writes", Type: "counter", Number: 312},
+		{Name: "mysql/innodb_status/os_fsyncs", Type: "counter", Number: 96},
+		{Name: "mysql/innodb_status/ibuf_size", Type: "gauge", Number: 1},
+		{Name: "mysql/innodb_status/ibuf_free_list_len", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/ibuf_seg_size", Type: "gauge", Number: 2},
+		{Name: "mysql/innodb_status/ibuf_merges", Type: "counter", Number: 0},
+		{Name: "mysql/innodb_status/hash_table_size", Type: "gauge", Number: 276707},
+		{Name: "mysql/innodb_status/hash_node_heap_buffers", Type: "gauge", Number: 2},
+		{Name: "mysql/innodb_status/log_sequence_number", Type: "counter", Number: 2139042},
+		{Name: "mysql/innodb_status/log_flushed_up_to", Type: "counter", Number: 2139042},
+		{Name: "mysql/innodb_status/pages_flushed_up_to", Type: "counter", Number: 2138990},
+		{Name: "mysql/innodb_status/last_checkpoint_at", Type: "counter", Number: 2138990},
+		{Name: "mysql/innodb_status/pending_log_writes", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_checkpoint_writes", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/log_ios_done", Type: "counter", Number: 64},
+		{Name: "mysql/innodb_status/checkpoint_age", Type: "gauge", Number: 52},
+		{Name: "mysql/innodb_status/log_unflushed", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/total_memory_allocated", Type: "gauge", Number: 137363456},
+		{Name: "mysql/innodb_status/dictionary_memory_allocated", Type: "gauge", Number: 65283},
+		{Name: "mysql/innodb_status/buffer_pool_pages_total", Type: "gauge", Number: 8191},
+		{Name: "mysql/innodb_status/buffer_pool_pages_free", Type: "gauge", Number: 7735},
+		{Name: "mysql/innodb_status/buffer_pool_pages_data", Type: "gauge", Number: 455},
+		{Name: "mysql/innodb_status/buffer_pool_pages_old", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pages_dirty", Type: "gauge", Number: 1},
+		{Name: "mysql/innodb_status/buffer_pool_pending_reads", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pending_writes_lru", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pending_writes_flush_list", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pending_writes_single_page", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pages_read", Type: "counter", Number: 421},
+		{Name: "mysql/innodb_status/buffer_pool_pages_created", Type: "counter", Number: 34},
+		{Name: "mysql/innodb_status/buffer_pool_pages_written", Type: "counter", Number: 185},
+		{Name: "mysql/innodb_status/queries_inside", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/queries_in_queue", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/read_views_open", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/rows_inserted", Type: "counter", Number: 12},
+		{Name: "mysql/innodb_status/rows_updated", Type: "counter", Number: 3},
+		{Name: "mysql/innodb_status/rows_deleted", Type: "counter", Number: 1},
+		{Name: "mysql/innodb_status/rows_read", Type: "counter", Number: 97},
+		{Name: "mysql/innodb_status/deadlocks", Type: "counter", Number: 0},
+	}
+	if ok, diff := test.IsDeeply(got, expect); !ok {
+		t.Error(diff)
+	}
+
+	t.Check(strings.HasPrefix(deadlock, "2014-10-06 11:58:12 7f2b0c1f9700\n*** (1) TRANSACTION:"), Equals, true)
+}
+
+func (s *InnoDBStatusTestSuite) TestInnoDBStatus57(t *C) {
+	m := mysql.NewMonitor("", &mysql.Config{}, s.logger, nil)
+	got, deadlock, err := parseInnoDBStatus(m, "status-5.7.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 5.7 has no mutex spin waits, no pending aio totals (so the per-thread
+	// counts are summed), and 8 adaptive hash index partitions (also summed).
+	expect := []mm.Metric{
+		{Name: "mysql/innodb_status/os_wait_array_reservation_count", Type: "counter", Number: 31},
+		{Name: "mysql/innodb_status/os_wait_array_signal_count", Type: "counter", Number: 30},
+		{Name: "mysql/innodb_status/rw_shared_spin_waits", Type: "counter", Number: 0},
+		{Name: "mysql/innodb_status/rw_shared_spin_rounds", Type: "counter", Number: 44},
+		{Name: "mysql/innodb_status/rw_shared_os_waits", Type: "counter", Number: 21},
+		{Name: "mysql/innodb_status/rw_excl_spin_waits", Type: "counter", Number: 0},
+		{Name: "mysql/innodb_status/rw_excl_spin_rounds", Type: "counter", Number: 1},
+		{Name: "mysql/innodb_status/rw_excl_os_waits", Type: "counter", Number: 0},
+		{Name: "mysql/innodb_status/rw_sx_spin_waits", Type: "counter", Number: 2},
+		{Name: "mysql/innodb_status/rw_sx_spin_rounds", Type: "counter", Number: 60},
+		{Name: "mysql/innodb_status/rw_sx_os_waits", Type: "counter", Number: 2},
+		{Name: "mysql/innodb_status/semaphore_waits", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/semaphore_max_wait_time", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/trx_id_counter", Type: "counter", Number: 8462},
+		{Name: "mysql/innodb_status/history_list_length", Type: "gauge", Number: 7},
+		{Name: "mysql/innodb_status/active_transactions", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/lock_wait_transactions", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_normal_aio_reads", Type: "gauge", Number: 3},
+		{Name: "mysql/innodb_status/pending_normal_aio_writes", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_log_fsyncs", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_buffer_pool_fsyncs", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/os_file_reads", Type: "counter", Number: 1103},
+		{Name: "mysql/innodb_status/os_file_writes", Type: "counter", Number: 873},
+		{Name: "mysql/innodb_status/os_fsyncs", Type: "counter", Number: 242},
+		{Name: "mysql/innodb_status/ibuf_size", Type: "gauge", Number: 1},
+		{Name: "mysql/innodb_status/ibuf_free_list_len", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/ibuf_seg_size", Type: "gauge", Number: 2},
+		{Name: "mysql/innodb_status/ibuf_merges", Type: "counter", Number: 0},
+		{Name: "mysql/innodb_status/hash_table_size", Type: "gauge", Number: 277384},
+		{Name: "mysql/innodb_status/hash_node_heap_buffers", Type: "gauge", Number: 3},
+		{Name: "mysql/innodb_status/log_sequence_number", Type: "counter", Number: 12618712},
+		{Name: "mysql/innodb_status/log_flushed_up_to", Type: "counter", Number: 12618712},
+		{Name: "mysql/innodb_status/pages_flushed_up_to", Type: "counter", Number: 12618712},
+		{Name: "mysql/innodb_status/last_checkpoint_at", Type: "counter", Number: 12618703},
+		{Name: "mysql/innodb_status/pending_log_writes", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/pending_checkpoint_writes", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/log_ios_done", Type: "counter", Number: 152},
+		{Name: "mysql/innodb_status/checkpoint_age", Type: "gauge", Number: 9},
+		{Name: "mysql/innodb_status/log_unflushed", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/total_memory_allocated", Type: "gauge", Number: 137428992},
+		{Name: "mysql/innodb_status/dictionary_memory_allocated", Type: "gauge", Number: 101739},
+		{Name: "mysql/innodb_status/buffer_pool_pages_total", Type: "gauge", Number: 8191},
+		{Name: "mysql/innodb_status/buffer_pool_pages_free", Type: "gauge", Number: 7064},
+		{Name: "mysql/innodb_status/buffer_pool_pages_data", Type: "gauge", Number: 1127},
+		{Name: "mysql/innodb_status/buffer_pool_pages_old", Type: "gauge", Number: 396},
+		{Name: "mysql/innodb_status/buffer_pool_pages_dirty", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pending_reads", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pending_writes_lru", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pending_writes_flush_list", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pending_writes_single_page", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/buffer_pool_pages_read", Type: "counter", Number: 1062},
+		{Name: "mysql/innodb_status/buffer_pool_pages_created", Type: "counter", Number: 65},
+		{Name: "mysql/innodb_status/buffer_pool_pages_written", Type: "counter", Number: 671},
+		{Name: "mysql/innodb_status/queries_inside", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/queries_in_queue", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/read_views_open", Type: "gauge", Number: 0},
+		{Name: "mysql/innodb_status/rows_inserted", Type: "counter", Number: 210},
+		{Name: "mysql/innodb_status/rows_updated", Type: "counter", Number: 4},
+		{Name: "mysql/innodb_status/rows_deleted", Type: "counter", Number: 0},
+		{Name: "mysql/innodb_status/rows_read", Type: "counter", Number: 5713},
+		{Name: "mysql/innodb_status/deadlocks", Type: "counter", Number: 0},
+	}
+	if ok, diff := test.IsDeeply(got, expect); !ok {
+		t.Error(diff)
+	}
+
+	t.Check(deadlock, Equals, "")
+}
+
+func (s *InnoDBStatusTestSuite) TestDeadlocks(t *C) {
+	m := mysql.NewMonitor("", &mysql.Config{}, s.logger, nil)
+
+	// Returns the deadlocks counter and the latest_deadlock text, if reported.
+	deadlocks := func(file string) (float64, string) {
+		got, deadlock, err := parseInnoDBStatus(m, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, metric := range got {
+			if metric.Name == "mysql/innodb_status/deadlocks" {
+				return metric.Number, deadlock
+			}
+		}
+		t.Fatal("No mysql/innodb_status/deadlocks metric")
+		return 0, ""
+	}
+
+	// The first deadlock seen is not counted because it could have happened
+	// before the monitor started, but its text is reported.
+	n, deadlock := deadlocks("status-5.5.txt")
+	t.Check(n, Equals, float64(0))
+	t.Check(deadlock, Not(Equals), "")
+
+	// Same deadlock, so not a new one and its text isn't reported again.
+	n, deadlock = deadlocks("status-5.5.txt")
+	t.Check(n, Equals, float64(0))
+	t.Check(deadlock, Equals, "")
+
+	// Different deadlock, so a new one.
+	n, deadlock = deadlocks("status-5.6.txt")
+	t.Check(n, Equals, float64(1))
+	t.Check(deadlock, Not(Equals), "")
+
+	// No deadlock (e.g. MySQL restarted) is not a new deadlock.
+	n, deadlock = deadlocks("status-5.7.txt")
+	t.Check(n, Equals, float64(1))
+	t.Check(deadlock, Equals, "")
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// SHOW SLAVE STATUS
+/////////////////////////////////////////////////////////////////////////////
+
+type ReplicationTestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&ReplicationTestSuite{})
+
+func (s *ReplicationTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 1000)
+	s.logger = pct.NewLogger(s.logChan, "mm-mysql-replication-test")
+}
+
+func (s *ReplicationTestSuite) TestGtidSetSize(t *C) {
+	n, err := mysql.GtidSetSize("3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5:11-18,\n4D8B564F-2A34-11E4-A3C5-00163E3C4A26:1-3")
+	t.Check(err, IsNil)
+	t.Check(n, Equals, float64(16))
+
+	n, err = mysql.GtidSetSize("3E11FA47-71CA-11E1-9E33-C80AA9429562:7")
+	t.Check(err, IsNil)
+	t.Check(n, Equals, float64(1))
+
+	_, err = mysql.GtidSetSize("3E11FA47-71CA-11E1-9E33-C80AA9429562:1-x")
+	t.Check(err, NotNil)
+}
+
+func (s *ReplicationTestSuite) TestDefaultChannel(t *C) {
+	m := mysql.NewMonitor("", &mysql.Config{}, s.logger, nil)
+
+	// Seconds_Behind_Master is NULL (not in the map) because the SQL thread is
+	// not running, and the IO thread is on a newer binlog than the SQL thread,
+	// so there's no master position lag.
+	got := m.ReplicationMetrics([]map[string]string{
+		{
+			"Slave_IO_State":        "Waiting for master to send event",
+			"Master_Log_File":       "mysql-bin.000012",
+			"Read_Master_Log_Pos":   "4096",
+			"Relay_Master_Log_File": "mysql-bin.000011",
+			"Slave_IO_Running":      "Yes",
+			"Slave_SQL_Running":     "No",
+			"Last_Errno":            "1062",
+			"Exec_Master_Log_Pos":   "1024",
+			"Relay_Log_Space":       "8192",
+			"Last_IO_Errno":         "0",
+			"Last_SQL_Errno":        "1062",
+			"Executed_Gtid_Set":     "",
+		},
+	})
+	expect := []mm.Metric{
+		{Name: "mysql/replication/relay_log_space", Type: "gauge", Number: 8192},
+		{Name: "mysql/replication/slave_io_running", Type: "gauge", Number: 1},
+		{Name: "mysql/replication/slave_io_state", Type: "string", String: "Waiting for master to send event"},
+		{Name: "mysql/replication/slave_sql_running", Type: "gauge", Number: 0},
+		{Name: "mysql/replication/last_errno", Type: "gauge", Number: 1062},
+		{Name: "mysql/replication/last_io_errno", Type: "gauge", Number: 0},
+		{Name: "mysql/replication/last_sql_errno", Type: "gauge", Number: 1062},
+		{Name: "mysql/replication/read_master_log_pos", Type: "counter", Number: 4096},
+		{Name: "mysql/replication/exec_master_log_pos", Type: "counter", Number: 1024},
+	}
+	if ok, diff := test.IsDeeply(got, expect); !ok {
+		t.Error(diff)
+	}
+}
+
+func (s *ReplicationTestSuite) TestMultiSourceChannels(t *C) {
+	m := mysql.NewMonitor("", &mysql.Config{}, s.logger, nil)
+
+	got := m.ReplicationMetrics([]map[string]string{
+		{
+			"Channel_Name":            "Master1",
+			"Master_Log_File":         "mysql-bin.000003",
+			"Read_Master_Log_Pos":     "5000",
+			"Relay_Master_Log_File":   "mysql-bin.000003",
+			"Slave_IO_Running":        "Connecting",
+			"Slave_SQL_Running":       "Yes",
+			"Slave_SQL_Running_State": "Slave has read all relay log; waiting for more updates",
+			"Exec_Master_Log_Pos":     "4000",
+			"Seconds_Behind_Master":   "3",
+			"Executed_Gtid_Set":       "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-10",
+		},
+		{
+			"Channel_Name":          "master1",
+			"Master_Log_File":       "mysql-bin.000007",
+			"Read_Master_Log_Pos":   "120",
+			"Relay_Master_Log_File": "mysql-bin.000007",
+			"Exec_Master_Log_Pos":   "120",
+			"Seconds_Behind_Master": "0",
+		},
+	})
+	expect := []mm.Metric{
+		{Name: "mysql/replication/Master1/seconds_behind_master", Type: "gauge", Number: 3},
+		{Name: "mysql/replication/Master1/slave_io_running", Type: "gauge", Number: 0},
+		{Name: "mysql/replication/Master1/slave_sql_running", Type: "gauge", Number: 1},
+		{Name: "mysql/replication/Master1/slave_sql_running_state", Type: "string", String: "Slave has read all relay log; waiting for more updates"},
+		{Name: "mysql/replication/Master1/executed_gtid_set_size", Type: "gauge", Number: 10},
+		{Name: "mysql/replication/Master1/read_master_log_pos", Type: "counter", Number: 5000},
+		{Name: "mysql/replication/Master1/exec_master_log_pos", Type: "counter", Number: 4000},
+		{Name: "mysql/replication/Master1/master_log_pos_lag", Type: "gauge", Number: 1000},
+		// Channel names differing only in case don't collide.
+		{Name: "mysql/replication/master1/seconds_behind_master", Type: "gauge", Number: 0},
+		{Name: "mysql/replication/master1/read_master_log_pos", Type: "counter", Number: 120},
+		{Name: "mysql/replication/master1/exec_master_log_pos", Type: "counter", Number: 120},
+		{Name: "mysql/replication/master1/master_log_pos_lag", Type: "gauge", Number: 0},
+	}
+	if ok, diff := test.IsDeeply(got, expect); !ok {
+		t.Error(diff)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// INFORMATION_SCHEMA.PROCESSLIST
+/////////////////////////////////////////////////////////////////////////////
+
+type ProcesslistTestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&ProcesslistTestSuite{})
+
+func (s *ProcesslistTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 1000)
+	s.logger = pct.NewLogger(s.logChan, "mm-mysql-processlist-test")
+}
+
+func (s *ProcesslistTestSuite) TestProcesslistMetrics(t *C) {
+	config := &mysql.Config{
+		ProcesslistMaxUsers: 2,
+	}
+	m := mysql.NewMonitor("", config, s.logger, nil)
+
+	got := m.ProcesslistMetrics([]mysql.Process{
+		{User: "app", Command: "Query", State: "Sending data", Time: 12},
+		{User: "app", Command: "Query", State: "Sending data", Time: 3},
+		{User: "app", Command: "Sleep", State: "", Time: 300},
+		{User: "root", Command: "Query", State: "executing", Time: 0},
+		{User: "root", Command: "Sleep", State: "", Time: 5},
+		{User: "backup", Command: "Sleep", State: "", Time: 1000},
+		{User: "system user", Command: "Connect", State: "Waiting for master to send event", Time: 2000},
+		{User: "other", Command: "Sleep", State: "", Time: 1},
+	})
+
+	// Only 2 users: app (3) and root (2); backup, system user, and the user
+	// named other are other_users.
+	// Max query time is 12, not 2000 because only Query commands count.
+	expect := []mm.Metric{
+		{Name: "mysql/processlist/command/connect", Type: "gauge", Number: 1},
+		{Name: "mysql/processlist/command/query", Type: "gauge", Number: 3},
+		{Name: "mysql/processlist/command/sleep", Type: "gauge", Number: 4},
+		{Name: "mysql/processlist/state/executing", Type: "gauge", Number: 1},
+		{Name: "mysql/processlist/state/none", Type: "gauge", Number: 4},
+		{Name: "mysql/processlist/state/sending_data", Type: "gauge", Number: 2},
+		{Name: "mysql/processlist/state/waiting_for_master_to_send_event", Type: "gauge", Number: 1},
+		{Name: "mysql/processlist/user/app", Type: "gauge", Number: 3},
+		{Name: "mysql/processlist/user/root", Type: "gauge", Number: 2},
+		{Name: "mysql/processlist/other_users", Type: "gauge", Number: 3},
+		{Name: "mysql/processlist/max_query_time", Type: "gauge", Number: 12},
+	}
+	if ok, diff := test.IsDeeply(got, expect); !ok {
+		t.Error(diff)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Performance Schema
+/////////////////////////////////////////////////////////////////////////////
+
+type PerfSchemaTestSuite struct {
+}
+
+var _ = Suite(&PerfSchemaTestSuite{})
+
+func (s *PerfSchemaTestSuite) TestFilter(t *C) {
+	// No patterns = everything.
+	f, err := mysql.NewPerfSchemaFilter(nil, nil)
+	t.Assert(err, IsNil)
+	t.Check(f.Match("mysql/pfs/table/test.t1"), Equals, true)
+	t.Check(f.Match("mysql/pfs/wait/synch/mutex/sql/LOCK_open"), Equals, true)
+
+	// Include only tables and InnoDB mutexes, but not tables in the mysql db.
+	f, err = mysql.NewPerfSchemaFilter(
+		[]string{"^mysql/pfs/table/", "^mysql/pfs/wait/synch/mutex/innodb/"},
+		[]string{"^mysql/pfs/table/mysql\\."},
+	)
+	t.Assert(err, IsNil)
+	t.Check(f.Match("mysql/pfs/table/test.t1"), Equals, true)
+	t.Check(f.Match("mysql/pfs/table/mysql.user"), Equals, false)
+	t.Check(f.Match("mysql/pfs/wait/synch/mutex/innodb/buf_pool_mutex"), Equals, true)
+	t.Check(f.Match("mysql/pfs/wait/synch/mutex/sql/LOCK_open"), Equals, false)
+	t.Check(f.Match("mysql/pfs/file/innodb/innodb_data_file"), Equals, false)
+
+	// Exclude only.
+	f, err = mysql.NewPerfSchemaFilter(nil, []string{"/file/"})
+	t.Assert(err, IsNil)
+	t.Check(f.Match("mysql/pfs/file/innodb/innodb_data_file"), Equals, false)
+	t.Check(f.Match("mysql/pfs/table/test.t1"), Equals, true)
+
+	// Invalid regexp.
+	_, err = mysql.NewPerfSchemaFilter([]string{"("}, nil)
+	t.Check(err, NotNil)
+}
+
+func (s *PerfSchemaTestSuite) TestInvalidFilter(t *C) {
+	logChan := make(chan *proto.LogEntry, 1000)
+	config := &mysql.Config{
+		PerfSchema:        true,
+		PerfSchemaExclude: []string{"["},
+	}
+	m := mysql.NewMonitor("mm-mysql-pfs-test", config, pct.NewLogger(logChan, "mm-mysql-pfs-test"), nil)
+	err := m.Start(make(chan time.Time), make(chan *mm.Collection))
+	t.Check(err, NotNil)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Filesystems
+/////////////////////////////////////////////////////////////////////////////
+
+type FilesystemTestSuite struct {
+}
+
+var _ = Suite(&FilesystemTestSuite{})
+
+func (s *FilesystemTestSuite) TestDirMountMetrics(t *C) {
+	vars := map[string]string{
+		"datadir":                   "/var/lib/mysql/",
+		"tmpdir":                    "/tmp:/var/tmp",
+		"innodb_data_home_dir":      "",
+		"innodb_log_group_home_dir": "./",
+		"log_bin_basename":          "/var/log/mysql logs/mysql-bin",
+		"log_error":                 "./db1.err",
+		"slow_query_log_file":       "slow.log",
+		"general_log_file":          "stderr",
+	}
+	dirs := mysql.MySQLDirs(vars)
+	expectDirs := map[string]string{
+		"datadir":                   "/var/lib/mysql",
+		"tmpdir":                    "/tmp",
+		"innodb_log_group_home_dir": "/var/lib/mysql",
+		"log_bin":                   "/var/log/mysql logs",
+		"log_error":                 "/var/lib/mysql",
+		"slow_query_log":            "/var/lib/mysql",
+	}
+	t.Check(dirs, DeepEquals, expectDirs)
+
+	mounts, err := system.ReadMounts(sample + "/proc/mounts001.txt")
+	t.Assert(err, IsNil)
+	got := mysql.DirMountMetrics(dirs, mounts)
+	expect := []mm.Metric{
+		{Name: "mysql/fs/datadir", Type: "string", String: "var-lib-mysql"},
+		{Name: "mysql/fs/innodb_log_group_home_dir", Type: "string", String: "var-lib-mysql"},
+		{Name: "mysql/fs/log_bin", Type: "string", String: "var-log-mysql_logs"},
+		{Name: "mysql/fs/log_error", Type: "string", String: "var-lib-mysql"},
+		{Name: "mysql/fs/slow_query_log", Type: "string", String: "var-lib-mysql"},
+		{Name: "mysql/fs/tmpdir", Type: "string", String: "root"},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Galera
+/////////////////////////////////////////////////////////////////////////////
+
+type QueryResponseTimeTestSuite struct {
+}
+
+var _ = Suite(&QueryResponseTimeTestSuite{})
+
+func (s *QueryResponseTimeTestSuite) TestMetrics(t *C) {
+	rows := []mysql.QRTRow{
+		{"       0.000001", "      0", "       0.000000"},
+		{"       0.000010", "     17", "       0.000094"},
+		{"       0.000100", "   4301", "       0.236555"},
+		{"       0.001000", "   1499", "       0.824450"},
+		{"       1.000000", "      3", "       0.500000"},
+		{"TOO LONG      ", "      2", "TOO LONG       "},
+	}
+	got, err := mysql.QueryResponseTimeMetrics("mysql/query_response_time/all", rows)
+	t.Assert(err, IsNil)
+	expect := []mm.Metric{
+		{
+			Name:   "mysql/query_response_time/all",
+			Type:   "histogram",
+			Bounds: []float64{0.000001, 0.00001, 0.0001, 0.001, 1},
+			Counts: []float64{0, 17, 4301, 1499, 3, 2},
+		},
+		{Name: "mysql/query_response_time/all_time", Type: "counter", Number: 0.000094 + 0.236555 + 0.824450 + 0.5},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	// Bounds must ascend.
+	rows = []mysql.QRTRow{
+		{"0.000010", "1", "0.000001"},
+		{"0.000001", "1", "0.000001"},
+	}
+	_, err = mysql.QueryResponseTimeMetrics("mysql/query_response_time/all", rows)
+	t.Check(err, NotNil)
+
+	// No buckets
+	_, err = mysql.QueryResponseTimeMetrics("mysql/query_response_time/all", []mysql.QRTRow{})
+	t.Check(err, NotNil)
+}
+
+type GaleraTestSuite struct {
+}
+
+var _ = Suite(&GaleraTestSuite{})
+
+func (s *GaleraTestSuite) TestStatusMetrics(t *C) {
+	// SHOW STATUS LIKE 'wsrep_%' without the prefix, lowercase.
+	status := map[string]string{
+		"cluster_size":        "3",
+		"cluster_status":      "Primary",
+		"local_state_comment": "Synced",
+		"local_recv_queue":    "2",
+		"flow_control_sent":   "11",
+		"cert_deps_distance":  "1.5",
+		"provider_name":       "Galera", // ignored
+	}
+	got := mysql.GaleraStatusMetrics(status)
+	expect := []mm.Metric{
+		{Name: "mysql/galera/cert_deps_distance", Type: "gauge", Number: 1.5},
+		{Name: "mysql/galera/cluster_size", Type: "gauge", Number: 3},
+		{Name: "mysql/galera/cluster_status", Type: "string", String: "Primary"},
+		{Name: "mysql/galera/flow_control_sent", Type: "counter", Number: 11},
+		{Name: "mysql/galera/local_recv_queue", Type: "gauge", Number: 2},
+		{Name: "mysql/galera/local_state_comment", Type: "string", String: "Synced"},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
+func (s *GaleraTestSuite) TestEvents(t *C) {
+	prev := map[string]string{
+		"cluster_conf_id":     "7",
+		"cluster_size":        "3",
+		"cluster_status":      "Primary",
+		"incoming_addresses":  "10.0.0.1:3306,10.0.0.2:3306,10.0.0.3:3306",
+		"local_state_comment": "Synced",
+	}
+
+	// No change, no events.
+	t.Check(mysql.GaleraEvents(prev, prev), HasLen, 0)
+
+	// Node 3 leaves and node 4 joins, and this node becomes a donor.
+	cur := map[string]string{
+		"cluster_conf_id":     "9",
+		"cluster_size":        "3",
+		"cluster_status":      "Primary",
+		"incoming_addresses":  "10.0.0.4:3306,10.0.0.1:3306,10.0.0.2:3306",
+		"local_state_comment": "Donor/Desynced",
+	}
+	t.Check(mysql.GaleraEvents(prev, cur), DeepEquals, []string{
+		"Galera cluster membership changed: conf_id 7 -> 9, size 3 -> 3, joined: 10.0.0.4:3306, left: 10.0.0.3:3306",
+		"Galera node state changed: Synced -> Donor/Desynced",
+	})
+
+	// Node is partitioned from the cluster.
+	cur = map[string]string{
+		"cluster_conf_id":     "18446744073709551615",
+		"cluster_size":        "1",
+		"cluster_status":      "non-Primary",
+		"incoming_addresses":  "10.0.0.1:3306",
+		"local_state_comment": "Synced",
+	}
+	t.Check(mysql.GaleraEvents(prev, cur), DeepEquals, []string{
+		"Galera cluster membership changed: conf_id 7 -> 18446744073709551615, size 3 -> 1, left: 10.0.0.2:3306,10.0.0.3:3306",
+		"Galera cluster status changed: Primary -> non-Primary",
+	})
+}
@@ -0,0 +1,219 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/percona/percona-agent/mm"
+	"regexp"
+	"strings"
+)
+
+// --------------------------------------------------------------------------
+// Performance Schema
+// http://dev.mysql.com/doc/refman/5.6/en/performance-schema-summary-tables.html
+// --------------------------------------------------------------------------
+
+// Performance Schema timers are in picoseconds, metrics are in seconds.
+const picoseconds = 1e12
+
+/**
+ * PerfSchemaFilter selects which Performance Schema metrics are collected.
+ * Patterns are regular expressions matched against the metric name without
+ * the stat, e.g. "mysql/pfs/table/test.t1" or
+ * "mysql/pfs/wait/synch/mutex/innodb/buf_pool_mutex".  If there are include
+ * patterns, a metric must match one of them; then it must not match any of
+ * the exclude patterns.
+ */
+type PerfSchemaFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func NewPerfSchemaFilter(include, exclude []string) (*PerfSchemaFilter, error) {
+	f := &PerfSchemaFilter{
+		include: make([]*regexp.Regexp, len(include)),
+		exclude: make([]*regexp.Regexp, len(exclude)),
+	}
+	for i, pattern := range include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.include[i] = re
+	}
+	for i, pattern := range exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude[i] = re
+	}
+	return f, nil
+}
+
+func (f *PerfSchemaFilter) Match(name string) bool {
+	if len(f.include) > 0 {
+		included := false
+		for _, re := range f.include {
+			if re.MatchString(name) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// @goroutine[2]
+func (m *Monitor) GetPerfSchemaMetrics(conn *sql.DB, c *mm.Collection) error {
+	m.logger.Debug("GetPerfSchemaMetrics:call")
+	defer m.logger.Debug("GetPerfSchemaMetrics:return")
+
+	// Each table is independent, so collect as many as possible.
+	errs := []string{}
+	if err := m.getTableIOWaits(conn, c); err != nil {
+		errs = append(errs, "table_io_waits_summary_by_table: "+err.Error())
+	}
+	if err := m.getFileSummary(conn, c); err != nil {
+		errs = append(errs, "file_summary_by_event_name: "+err.Error())
+	}
+	if err := m.getEventWaits(conn, c); err != nil {
+		errs = append(errs, "events_waits_summary_global_by_event_name: "+err.Error())
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// @goroutine[2]
+func (m *Monitor) getTableIOWaits(conn *sql.DB, c *mm.Collection) error {
+	m.status.Update(m.name, "Getting Performance Schema table I/O metrics")
+
+	rows, err := conn.Query("SELECT OBJECT_SCHEMA, OBJECT_NAME," +
+		" COUNT_READ, COUNT_WRITE, COUNT_FETCH, COUNT_INSERT, COUNT_UPDATE, COUNT_DELETE," +
+		" SUM_TIMER_READ, SUM_TIMER_WRITE" +
+		" FROM performance_schema.table_io_waits_summary_by_table" +
+		" WHERE OBJECT_TYPE = 'TABLE'")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schema, table string
+		var read, write, fetch, insert, update, del float64
+		var readTime, writeTime float64
+		err := rows.Scan(&schema, &table, &read, &write, &fetch, &insert, &update, &del, &readTime, &writeTime)
+		if err != nil {
+			return err
+		}
+		prefix := "mysql/pfs/table/" + schema + "." + table
+		if !m.pfsFilter.Match(prefix) {
+			continue
+		}
+		c.Metrics = append(c.Metrics,
+			mm.Metric{Name: prefix + "/io_read", Type: "counter", Number: read},
+			mm.Metric{Name: prefix + "/io_write", Type: "counter", Number: write},
+			mm.Metric{Name: prefix + "/io_fetch", Type: "counter", Number: fetch},
+			mm.Metric{Name: prefix + "/io_insert", Type: "counter", Number: insert},
+			mm.Metric{Name: prefix + "/io_update", Type: "counter", Number: update},
+			mm.Metric{Name: prefix + "/io_delete", Type: "counter", Number: del},
+			mm.Metric{Name: prefix + "/io_read_time", Type: "counter", Number: readTime / picoseconds},
+			mm.Metric{Name: prefix + "/io_write_time", Type: "counter", Number: writeTime / picoseconds},
+		)
+	}
+	return rows.Err()
+}
+
+// @goroutine[2]
+func (m *Monitor) getFileSummary(conn *sql.DB, c *mm.Collection) error {
+	m.status.Update(m.name, "Getting Performance Schema file I/O metrics")
+
+	rows, err := conn.Query("SELECT EVENT_NAME," +
+		" COUNT_READ, SUM_NUMBER_OF_BYTES_READ, SUM_TIMER_READ," +
+		" COUNT_WRITE, SUM_NUMBER_OF_BYTES_WRITE, SUM_TIMER_WRITE" +
+		" FROM performance_schema.file_summary_by_event_name")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event string
+		var reads, bytesRead, readTime float64
+		var writes, bytesWritten, writeTime float64
+		err := rows.Scan(&event, &reads, &bytesRead, &readTime, &writes, &bytesWritten, &writeTime)
+		if err != nil {
+			return err
+		}
+		// wait/io/file/innodb/innodb_data_file -> mysql/pfs/file/innodb/innodb_data_file
+		prefix := "mysql/pfs/file/" + strings.TrimPrefix(event, "wait/io/file/")
+		if !m.pfsFilter.Match(prefix) {
+			continue
+		}
+		c.Metrics = append(c.Metrics,
+			mm.Metric{Name: prefix + "/reads", Type: "counter", Number: reads},
+			mm.Metric{Name: prefix + "/bytes_read", Type: "counter", Number: bytesRead},
+			mm.Metric{Name: prefix + "/read_time", Type: "counter", Number: readTime / picoseconds},
+			mm.Metric{Name: prefix + "/writes", Type: "counter", Number: writes},
+			mm.Metric{Name: prefix + "/bytes_written", Type: "counter", Number: bytesWritten},
+			mm.Metric{Name: prefix + "/write_time", Type: "counter", Number: writeTime / picoseconds},
+		)
+	}
+	return rows.Err()
+}
+
+// @goroutine[2]
+func (m *Monitor) getEventWaits(conn *sql.DB, c *mm.Collection) error {
+	m.status.Update(m.name, "Getting Performance Schema wait event metrics")
+
+	// Idle is not a wait, it's time waiting for the client.
+	rows, err := conn.Query("SELECT EVENT_NAME, COUNT_STAR, SUM_TIMER_WAIT" +
+		" FROM performance_schema.events_waits_summary_global_by_event_name" +
+		" WHERE EVENT_NAME != 'idle'")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event string
+		var count, waitTime float64
+		if err := rows.Scan(&event, &count, &waitTime); err != nil {
+			return err
+		}
+		// wait/synch/mutex/innodb/buf_pool_mutex -> mysql/pfs/wait/synch/mutex/innodb/buf_pool_mutex
+		prefix := "mysql/pfs/" + event
+		if !m.pfsFilter.Match(prefix) {
+			continue
+		}
+		c.Metrics = append(c.Metrics,
+			mm.Metric{Name: prefix + "/count", Type: "counter", Number: count},
+			mm.Metric{Name: prefix + "/time", Type: "counter", Number: waitTime / picoseconds},
+		)
+	}
+	return rows.Err()
+}
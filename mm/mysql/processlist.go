@@ -0,0 +1,208 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"github.com/percona/percona-agent/mm"
+	"sort"
+	"strings"
+)
+
+// --------------------------------------------------------------------------
+// Processlist
+// http://dev.mysql.com/doc/refman/5.6/en/processlist-table.html
+// --------------------------------------------------------------------------
+
+// Max number of users reported in mysql/processlist/user/ if not configured.
+// Users with fewer connections are counted as mysql/processlist/other_users,
+// which is not under user/ so it can't be mistaken for a user named "other".
+const DEFAULT_PROCESSLIST_MAX_USERS = 20
+
+// A row from INFORMATION_SCHEMA.PROCESSLIST.
+type Process struct {
+	User    string
+	Command string
+	State   string
+	Time    int64 // seconds
+}
+
+// @goroutine[2]
+func (m *Monitor) GetProcesslistMetrics(conn *sql.DB, c *mm.Collection) error {
+	m.logger.Debug("GetProcesslistMetrics:call")
+	defer m.logger.Debug("GetProcesslistMetrics:return")
+
+	m.status.Update(m.name, "Getting processlist metrics")
+
+	rows, err := conn.Query("SELECT USER, COMMAND, STATE, TIME FROM INFORMATION_SCHEMA.PROCESSLIST")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	procs := []Process{}
+	for rows.Next() {
+		var user, command, state sql.NullString
+		var time sql.NullInt64
+		if err := rows.Scan(&user, &command, &state, &time); err != nil {
+			return err
+		}
+		procs = append(procs, Process{
+			User:    user.String,
+			Command: command.String,
+			State:   state.String,
+			Time:    time.Int64,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	c.Metrics = append(c.Metrics, m.ProcesslistMetrics(procs)...)
+
+	/**
+	 * An idle transaction is an InnoDB transaction whose connection is sleeping:
+	 * the app began a trx then stopped sending queries, but the trx holds locks
+	 * and a read view until it commits.  Processlist TIME is how long the
+	 * connection has been sleeping, not how long the trx has been open, so get
+	 * the trx age from INNODB_TRX.  This fails if InnoDB is disabled, which is
+	 * ok: it's not tried again until MySQL is reconnected.
+	 */
+	if m.noInnoDBTrx {
+		return nil
+	}
+	var idleTrxTime sql.NullInt64
+	err = conn.QueryRow("SELECT MAX(UNIX_TIMESTAMP() - UNIX_TIMESTAMP(t.trx_started))" +
+		" FROM INFORMATION_SCHEMA.INNODB_TRX t" +
+		" JOIN INFORMATION_SCHEMA.PROCESSLIST p ON t.trx_mysql_thread_id = p.ID" +
+		" WHERE p.COMMAND = 'Sleep'").Scan(&idleTrxTime)
+	if err != nil {
+		m.logger.Debug("No max_idle_trx_time:", err)
+		m.noInnoDBTrx = true
+		return nil
+	}
+	c.Metrics = append(c.Metrics, mm.Metric{
+		Name:   "mysql/processlist/max_idle_trx_time",
+		Type:   "gauge",
+		Number: float64(idleTrxTime.Int64), // 0 if NULL, i.e. no idle trx
+	})
+
+	return nil
+}
+
+/**
+ * ProcesslistMetrics summarizes the processlist: number of connections by
+ * command, by state, and by user, and the time of the longest running query.
+ * Only the users with the most connections are reported individually (see
+ * DEFAULT_PROCESSLIST_MAX_USERS) because every user name is a new metric.
+ */
+func (m *Monitor) ProcesslistMetrics(procs []Process) []mm.Metric {
+	commands := make(map[string]float64)
+	states := make(map[string]float64)
+	users := make(map[string]float64)
+	var maxQueryTime int64
+	for _, p := range procs {
+		commands[metricNamePart(p.Command)]++
+		state := metricNamePart(p.State)
+		if state == "" {
+			state = "none"
+		}
+		states[state]++
+		users[p.User]++
+		if p.Command == "Query" && p.Time > maxQueryTime {
+			maxQueryTime = p.Time
+		}
+	}
+
+	metrics := []mm.Metric{}
+	metrics = append(metrics, countMetrics("mysql/processlist/command/", commands)...)
+	metrics = append(metrics, countMetrics("mysql/processlist/state/", states)...)
+
+	maxUsers := DEFAULT_PROCESSLIST_MAX_USERS
+	if m.config.ProcesslistMaxUsers > 0 {
+		maxUsers = int(m.config.ProcesslistMaxUsers)
+	}
+	otherUsers := 0.0
+	if len(users) > maxUsers {
+		// Keep the top users by number of connections, sum the rest.
+		names := make([]string, 0, len(users))
+		for user := range users {
+			names = append(names, user)
+		}
+		sort.Sort(byCount{names, users})
+		for _, user := range names[maxUsers:] {
+			otherUsers += users[user]
+			delete(users, user)
+		}
+	}
+	userCounts := make(map[string]float64, len(users))
+	for user, n := range users {
+		userCounts[metricNamePart(user)] += n
+	}
+	metrics = append(metrics, countMetrics("mysql/processlist/user/", userCounts)...)
+	metrics = append(metrics, mm.Metric{
+		Name:   "mysql/processlist/other_users",
+		Type:   "gauge",
+		Number: otherUsers,
+	})
+
+	metrics = append(metrics, mm.Metric{
+		Name:   "mysql/processlist/max_query_time",
+		Type:   "gauge",
+		Number: float64(maxQueryTime),
+	})
+
+	return metrics
+}
+
+// countMetrics returns a gauge for each count, sorted by name.
+func countMetrics(prefix string, counts map[string]float64) []mm.Metric {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]mm.Metric, len(names))
+	for i, name := range names {
+		metrics[i] = mm.Metric{Name: prefix + name, Type: "gauge", Number: counts[name]}
+	}
+	return metrics
+}
+
+// metricNamePart makes s usable as part of a metric name: "Sending data"
+// becomes "sending_data".  Slashes are replaced because they separate
+// metric name parts.
+func metricNamePart(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.NewReplacer(" ", "_", "/", "_").Replace(s)
+}
+
+// Sort names by count descending, then by name so ties are deterministic.
+type byCount struct {
+	names  []string
+	counts map[string]float64
+}
+
+func (s byCount) Len() int      { return len(s.names) }
+func (s byCount) Swap(i, j int) { s.names[i], s.names[j] = s.names[j], s.names[i] }
+func (s byCount) Less(i, j int) bool {
+	ci, cj := s.counts[s.names[i]], s.counts[s.names[j]]
+	if ci != cj {
+		return ci > cj
+	}
+	return s.names[i] < s.names[j]
+}
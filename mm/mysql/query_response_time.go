@@ -0,0 +1,148 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/percona/percona-agent/mm"
+	"strconv"
+	"strings"
+)
+
+// --------------------------------------------------------------------------
+// Query response time (Percona Server)
+// http://www.percona.com/doc/percona-server/5.6/diagnostics/response_time_distribution.html
+// --------------------------------------------------------------------------
+
+// Query response time tables and their metric names.  The read and write
+// tables are only in 5.6 and newer.
+var qrtTables = []struct {
+	table string
+	name  string
+}{
+	{"QUERY_RESPONSE_TIME", "all"},
+	{"QUERY_RESPONSE_TIME_READ", "read"},
+	{"QUERY_RESPONSE_TIME_WRITE", "write"},
+}
+
+// A row of INFORMATION_SCHEMA.QUERY_RESPONSE_TIME.
+type QRTRow struct {
+	Time  string // bucket upper bound (seconds), or TOO LONG for the last bucket
+	Count string
+	Total string // seconds
+}
+
+// @goroutine[2]
+func (m *Monitor) GetQueryResponseTimeMetrics(conn *sql.DB, c *mm.Collection) error {
+	m.logger.Debug("GetQueryResponseTimeMetrics:call")
+	defer m.logger.Debug("GetQueryResponseTimeMetrics:return")
+
+	m.status.Update(m.name, "Getting query response time metrics")
+
+	for i, t := range qrtTables {
+		rows, err := getQRTRows(conn, t.table)
+		if err != nil {
+			if i == 0 {
+				return err
+			}
+			m.logger.Debug("GetQueryResponseTimeMetrics:", t.table, err)
+			continue
+		}
+		metrics, err := QueryResponseTimeMetrics("mysql/query_response_time/"+t.name, rows)
+		if err != nil {
+			return fmt.Errorf("%s: %s", t.table, err)
+		}
+		c.Metrics = append(c.Metrics, metrics...)
+	}
+	return nil
+}
+
+func getQRTRows(conn *sql.DB, table string) ([]QRTRow, error) {
+	rows, err := conn.Query("SELECT TIME, COUNT, TOTAL FROM INFORMATION_SCHEMA." + table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	qrtRows := []QRTRow{}
+	for rows.Next() {
+		var row QRTRow
+		if err := rows.Scan(&row.Time, &row.Count, &row.Total); err != nil {
+			return nil, err
+		}
+		qrtRows = append(qrtRows, row)
+	}
+	return qrtRows, rows.Err()
+}
+
+/**
+ * QueryResponseTimeMetrics returns a histogram metric of the query response
+ * time buckets, and a <name>_time counter of the total response time (seconds)
+ * of all queries.  Values are padded with spaces, e.g.:
+ *
+ *   TIME            COUNT  TOTAL
+ *         0.000001      0        0.000000
+ *         0.000010     17        0.000094
+ *   ...
+ *   TOO LONG            0  TOO LONG
+ *
+ * TOTAL for TOO LONG is TOO LONG in some versions, so it's ignored.
+ */
+func QueryResponseTimeMetrics(name string, rows []QRTRow) ([]mm.Metric, error) {
+	bounds := []float64{}
+	counts := []float64{}
+	var overflow, total float64
+	haveOverflow := false
+	for _, row := range rows {
+		t := strings.TrimSpace(row.Time)
+		count, err := strconv.ParseFloat(strings.TrimSpace(row.Count), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid COUNT for %s: %s", t, row.Count)
+		}
+		if n, err := strconv.ParseFloat(strings.TrimSpace(row.Total), 64); err == nil {
+			total += n
+		}
+		if t == "TOO LONG" {
+			overflow = count
+			haveOverflow = true
+			continue
+		}
+		if haveOverflow {
+			return nil, fmt.Errorf("Bucket %s after TOO LONG", t)
+		}
+		bound, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid TIME: %s", row.Time)
+		}
+		if len(bounds) > 0 && bound <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("TIME not ascending: %s", t)
+		}
+		bounds = append(bounds, bound)
+		counts = append(counts, count)
+	}
+	if len(bounds) == 0 {
+		return nil, fmt.Errorf("No buckets")
+	}
+	counts = append(counts, overflow)
+
+	metrics := []mm.Metric{
+		{Name: name, Type: "histogram", Bounds: bounds, Counts: counts},
+		{Name: name + "_time", Type: "counter", Number: total},
+	}
+	return metrics, nil
+}
@@ -0,0 +1,207 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"github.com/percona/percona-agent/mm"
+	"strconv"
+	"strings"
+)
+
+// --------------------------------------------------------------------------
+// Replication
+// http://dev.mysql.com/doc/refman/5.7/en/show-slave-status.html
+// --------------------------------------------------------------------------
+
+// @goroutine[2]
+func (m *Monitor) GetReplicationMetrics(conn *sql.DB, c *mm.Collection) error {
+	m.logger.Debug("GetReplicationMetrics:call")
+	defer m.logger.Debug("GetReplicationMetrics:return")
+
+	m.status.Update(m.name, "Getting replication metrics")
+
+	/**
+	 * SHOW SLAVE STATUS returns one row per replication channel (5.7 multi-source),
+	 * or one row for the default channel, or no rows if not a slave.  Columns
+	 * vary by version, so scan every row into a map keyed on column name.
+	 */
+	rows, err := conn.Query("SHOW SLAVE STATUS")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	channels := []map[string]string{}
+	for rows.Next() {
+		vals := make([]sql.RawBytes, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		status := make(map[string]string)
+		for i, col := range columns {
+			if vals[i] == nil {
+				continue // NULL, e.g. Seconds_Behind_Master when SQL thread is not running
+			}
+			status[col] = string(vals[i])
+		}
+		channels = append(channels, status)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	c.Metrics = append(c.Metrics, m.ReplicationMetrics(channels)...)
+	return nil
+}
+
+/**
+ * ReplicationMetrics returns metrics for each replication channel, where each
+ * channel is a row of SHOW SLAVE STATUS keyed on column name.  NULL columns
+ * are not in the map.  Metrics for the default channel are named
+ * mysql/replication/<metric>; metrics for other channels are named
+ * mysql/replication/<channel>/<metric>.  Channel names are case-sensitive,
+ * so they're kept as is except slashes, which separate metric name parts.
+ */
+func (m *Monitor) ReplicationMetrics(channels []map[string]string) []mm.Metric {
+	metrics := []mm.Metric{}
+	for _, status := range channels {
+		prefix := "mysql/replication/"
+		if channel := status["Channel_Name"]; channel != "" {
+			prefix += strings.Replace(channel, "/", "_", -1) + "/"
+		}
+
+		// NULL if the SQL thread is not running or the IO thread is not connected.
+		if val, ok := status["Seconds_Behind_Master"]; ok {
+			metrics = append(metrics, mm.Metric{Name: prefix + "seconds_behind_master", Type: "gauge", Number: strToFloat(val)})
+		}
+		if val, ok := status["Relay_Log_Space"]; ok {
+			metrics = append(metrics, mm.Metric{Name: prefix + "relay_log_space", Type: "gauge", Number: strToFloat(val)})
+		}
+
+		// Slave_IO_Running is Yes, No or Connecting.  The states say what the
+		// threads are doing, e.g. "Waiting for master to send event".
+		if val, ok := status["Slave_IO_Running"]; ok {
+			metrics = append(metrics, mm.Metric{Name: prefix + "slave_io_running", Type: "gauge", Number: yesToFloat(val)})
+		}
+		if val, ok := status["Slave_IO_State"]; ok {
+			metrics = append(metrics, mm.Metric{Name: prefix + "slave_io_state", Type: "string", String: val})
+		}
+		if val, ok := status["Slave_SQL_Running"]; ok {
+			metrics = append(metrics, mm.Metric{Name: prefix + "slave_sql_running", Type: "gauge", Number: yesToFloat(val)})
+		}
+		if val, ok := status["Slave_SQL_Running_State"]; ok { // 5.6 and newer
+			metrics = append(metrics, mm.Metric{Name: prefix + "slave_sql_running_state", Type: "string", String: val})
+		}
+
+		for _, col := range []string{"Last_Errno", "Last_IO_Errno", "Last_SQL_Errno"} {
+			if val, ok := status[col]; ok {
+				metrics = append(metrics, mm.Metric{Name: prefix + strings.ToLower(col), Type: "gauge", Number: strToFloat(val)})
+			}
+		}
+
+		// 5.6 and newer with GTID.  The set is empty if GTID is not enabled.
+		if val, ok := status["Executed_Gtid_Set"]; ok && val != "" {
+			if n, err := GtidSetSize(val); err != nil {
+				m.logger.Warn("Invalid Executed_Gtid_Set:", err)
+			} else {
+				metrics = append(metrics, mm.Metric{Name: prefix + "executed_gtid_set_size", Type: "gauge", Number: n})
+			}
+		}
+
+		/**
+		 * Master position lag is how many bytes of the master's binary log the
+		 * SQL thread has not executed yet.  It's only known when the IO and SQL
+		 * threads are on the same master binlog; else the IO thread has rotated
+		 * to a newer binlog and we don't know the size of the older ones.
+		 */
+		readPos, haveReadPos := status["Read_Master_Log_Pos"]
+		execPos, haveExecPos := status["Exec_Master_Log_Pos"]
+		if haveReadPos && haveExecPos {
+			metrics = append(metrics, mm.Metric{Name: prefix + "read_master_log_pos", Type: "counter", Number: strToFloat(readPos)})
+			metrics = append(metrics, mm.Metric{Name: prefix + "exec_master_log_pos", Type: "counter", Number: strToFloat(execPos)})
+			if status["Master_Log_File"] == status["Relay_Master_Log_File"] {
+				lag := strToFloat(readPos) - strToFloat(execPos)
+				if lag < 0 {
+					lag = 0
+				}
+				metrics = append(metrics, mm.Metric{Name: prefix + "master_log_pos_lag", Type: "gauge", Number: lag})
+			}
+		}
+	}
+	return metrics
+}
+
+/**
+ * GtidSetSize returns the number of transactions in a GTID set, e.g.
+ *   3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5:11-18,
+ *   4D8B564F-2A34-11E4-A3C5-00163E3C4A26:1-3
+ * is 5 + 8 + 3 = 16 transactions.
+ */
+func GtidSetSize(set string) (float64, error) {
+	var n float64
+	for _, uuidSet := range strings.Split(set, ",") {
+		// SHOW SLAVE STATUS wraps long sets with newlines.
+		uuidSet = strings.TrimSpace(uuidSet)
+		if uuidSet == "" {
+			continue
+		}
+		intervals := strings.Split(uuidSet, ":")
+		for _, interval := range intervals[1:] {
+			startEnd := strings.SplitN(interval, "-", 2)
+			start, err := strconv.ParseUint(startEnd[0], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			end := start
+			if len(startEnd) == 2 {
+				if end, err = strconv.ParseUint(startEnd[1], 10, 64); err != nil {
+					return 0, err
+				}
+			}
+			if end >= start {
+				n += float64(end - start + 1)
+			}
+		}
+	}
+	return n, nil
+}
+
+func strToFloat(s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+func yesToFloat(s string) float64 {
+	if s == "Yes" {
+		return 1
+	}
+	return 0
+}
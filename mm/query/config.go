@@ -0,0 +1,91 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package query
+
+import (
+	"errors"
+	"fmt"
+	"github.com/percona/percona-agent/mm"
+	"regexp"
+	"strings"
+)
+
+// Seconds to wait for a query if Query.Timeout is not set.
+const DEFAULT_TIMEOUT = 5
+
+type Config struct {
+	mm.Config
+	Queries []Query
+}
+
+/**
+ * A user-defined query.  Each row returned by SQL is one set of metrics: for
+ * each column in Metrics, the metric is named <Name>/<column>.  Name can have
+ * {column} placeholders which are replaced by that column's value in the row,
+ * e.g. Name "app/queue/{queue}" and Metrics {"depth": "gauge"} for
+ *   SELECT queue, COUNT(*) AS depth FROM jobs GROUP BY queue
+ * returns metrics like app/queue/email/depth.
+ */
+type Query struct {
+	Name    string            // metric name prefix, can have {column} placeholders
+	SQL     string            // SELECT or SHOW
+	Metrics map[string]string // column => metric type: gauge or counter
+	Timeout uint              // seconds, 0 = DEFAULT_TIMEOUT
+}
+
+var placeholder = regexp.MustCompile(`{([^{}]+)}`)
+
+// SELECT clauses that write or lock rows.
+var writeClause = regexp.MustCompile(`(?i)\b(INTO|FOR\s+UPDATE|FOR\s+SHARE|LOCK\s+IN\s+SHARE\s+MODE)\b`)
+
+// Validate returns an error if the query is not read-only or its metrics are
+// invalid.  The SQL is checked, not parsed, so the MySQL user should only have
+// SELECT privileges, too.
+func (q Query) Validate() error {
+	if q.Name == "" {
+		return errors.New("Query has no Name")
+	}
+	if strings.ContainsAny(placeholder.ReplaceAllString(q.Name, ""), "{}") {
+		return fmt.Errorf("Query %s: invalid {column} placeholder", q.Name)
+	}
+	sql := strings.TrimRight(strings.TrimSpace(q.SQL), ";")
+	words := strings.Fields(sql)
+	if len(words) == 0 {
+		return fmt.Errorf("Query %s: no SQL", q.Name)
+	}
+	switch strings.ToUpper(words[0]) {
+	case "SELECT", "SHOW":
+	default:
+		return fmt.Errorf("Query %s: SQL is not SELECT or SHOW", q.Name)
+	}
+	if strings.Contains(sql, ";") {
+		return fmt.Errorf("Query %s: SQL has more than one statement", q.Name)
+	}
+	if clause := writeClause.FindString(sql); clause != "" {
+		return fmt.Errorf("Query %s: SQL is not read-only: %s", q.Name, clause)
+	}
+	if len(q.Metrics) == 0 {
+		return fmt.Errorf("Query %s: no Metrics", q.Name)
+	}
+	for column, metricType := range q.Metrics {
+		if metricType != "gauge" && metricType != "counter" {
+			return fmt.Errorf("Query %s: column %s: invalid metric type: %s", q.Name, column, metricType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,418 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package query
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type result struct {
+	metrics []mm.Metric
+	err     error
+}
+
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   mysql.Connector
+	// --
+	tickChan       chan time.Time
+	collectionChan chan *mm.Collection
+	connected      bool
+	connectedChan  chan bool
+	status         *pct.Status
+	sync           *pct.SyncChan
+	running        bool
+	// Queries that timed out but haven't returned yet, by Queries index
+	pending map[int]chan result
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Connector) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		connectedChan: make(chan bool, 1),
+		status:        pct.NewStatus([]string{name, name + "-mysql"}),
+		sync:          pct.NewSyncChan(),
+		pending:       make(map[int]chan result),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, collectionChan chan *mm.Collection) error {
+	m.logger.Debug("Start:call")
+	defer m.logger.Debug("Start:return")
+
+	if m.running {
+		return pct.ServiceIsRunningError{m.name}
+	}
+
+	if len(m.config.Queries) == 0 {
+		return fmt.Errorf("No queries")
+	}
+	for _, q := range m.config.Queries {
+		if err := q.Validate(); err != nil {
+			return err
+		}
+	}
+
+	m.tickChan = tickChan
+	m.collectionChan = collectionChan
+
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	m.logger.Debug("Stop:call")
+	defer m.logger.Debug("Stop:return")
+
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+
+	m.config = nil // no config if not running
+	m.running = false
+	m.logger.Info("Stopped")
+
+	// Do not update status to "Stopped" here; run() does that on return.
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// run:@goroutine[3]
+func (m *Monitor) connect(err error) {
+	m.logger.Debug("connect:call")
+	defer m.logger.Debug("connect:return")
+
+	// Close/release previous connection, if any.
+	m.conn.Close()
+
+	// Try forever to connect to MySQL...
+	for {
+		m.logger.Debug("connect:try")
+
+		if err != nil {
+			m.status.Update(m.name+"-mysql", fmt.Sprintf("Connecting (%s)", err))
+		} else {
+			m.status.Update(m.name+"-mysql", fmt.Sprintf("Connecting"))
+		}
+		if err = m.conn.Connect(1); err != nil {
+			m.logger.Warn(err)
+			continue
+		}
+
+		// Tell run() goroutine that it can try to collect metrics.
+		m.logger.Info("Connected")
+		m.status.Update(m.name+"-mysql", "Connected")
+		m.connectedChan <- true
+		return
+	}
+}
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	m.logger.Debug("run:call")
+	defer func() {
+		m.conn.Close()
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+		m.logger.Debug("run:return")
+	}()
+
+	go m.connect(nil)
+
+	m.status.Update(m.name, "Ready")
+
+	var lastTs int64
+	var lastError string
+	for {
+		t := time.Unix(lastTs, 0)
+		if lastError == "" {
+			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s)", t))
+		} else {
+			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s, error: %s)", t, lastError))
+		}
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:collect:start")
+			if !m.connected {
+				m.logger.Debug("run:collect:disconnected")
+				lastError = "Not connected to MySQL"
+				continue
+			}
+			m.status.Update(m.name, "Running")
+
+			c := &mm.Collection{
+				ServiceInstance: proto.ServiceInstance{
+					Service:    m.config.Service,
+					InstanceId: m.config.InstanceId,
+				},
+				Ts:      now.UTC().Unix(),
+				Metrics: []mm.Metric{},
+			}
+
+			conn := m.conn.DB()
+			lastError = ""
+			for i, q := range m.config.Queries {
+				metrics, err := m.collect(conn, i, q)
+				if err != nil {
+					m.logger.Warn(err)
+					lastError = err.Error()
+					continue
+				}
+				c.Metrics = append(c.Metrics, metrics...)
+			}
+
+			// Send the metrics to an mm.Aggregator.
+			m.status.Update(m.name, "Sending metrics")
+			if len(c.Metrics) > 0 {
+				select {
+				case m.collectionChan <- c:
+					lastTs = c.Ts
+				case <-time.After(500 * time.Millisecond):
+					// lost collection
+					m.logger.Debug("Lost query metrics; timeout spooling after 500ms")
+					pct.Metrics.Inc("mm/lost_collections", 1)
+					lastError = "Spool timeout"
+				}
+			} else {
+				m.logger.Debug("run:no metrics")
+				if lastError == "" {
+					lastError = "No metrics"
+				}
+			}
+
+			m.logger.Debug("run:collect:stop")
+		case connected := <-m.connectedChan:
+			m.connected = connected
+			if connected {
+				m.logger.Debug("run:connected:true")
+				m.status.Update(m.name, "Ready")
+			} else {
+				m.logger.Debug("run:connected:false")
+				go m.connect(nil)
+			}
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+/**
+ * collect runs query i on its own connection and waits for its metrics until
+ * it times out.  On timeout the query is killed so it doesn't keep running on
+ * MySQL.  Until it returns, the query is not run again, else a slow query would
+ * pile up on MySQL.
+ */
+// @goroutine[2]
+func (m *Monitor) collect(db *sql.DB, i int, q Query) ([]mm.Metric, error) {
+	m.logger.Debug("collect:call")
+	defer m.logger.Debug("collect:return")
+
+	if resultChan, ok := m.pending[i]; ok {
+		select {
+		case <-resultChan:
+			delete(m.pending, i) // late result, discard it
+		default:
+			return nil, fmt.Errorf("Query %s: skipped, still running from previous collect", q.Name)
+		}
+	}
+
+	m.status.Update(m.name, "Running query "+q.Name)
+
+	timeout := q.Timeout
+	if timeout == 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+
+	// A transaction holds one connection, so the query can be killed by its
+	// connection ID.  Nothing is written; it's rolled back.
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Query %s: %s", q.Name, err)
+	}
+	var id uint64
+	if err := tx.QueryRow("SELECT CONNECTION_ID()").Scan(&id); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("Query %s: %s", q.Name, err)
+	}
+
+	resultChan := make(chan result, 1)
+	go func() {
+		defer tx.Rollback()
+		metrics, err := Run(tx, q)
+		resultChan <- result{metrics, err}
+	}()
+
+	select {
+	case r := <-resultChan:
+		if r.err != nil {
+			return nil, fmt.Errorf("Query %s: %s", q.Name, r.err)
+		}
+		return r.metrics, nil
+	case <-time.After(time.Duration(timeout) * time.Second):
+		if _, err := db.Exec(fmt.Sprintf("KILL QUERY %d", id)); err != nil {
+			m.logger.Warn(fmt.Sprintf("Query %s: cannot kill query on connection %d: %s", q.Name, id, err))
+		}
+		m.pending[i] = resultChan
+		return nil, fmt.Errorf("Query %s: timeout after %ds", q.Name, timeout)
+	}
+}
+
+// Run runs the query in tx and returns the metrics for all rows.
+func Run(tx *sql.Tx, q Query) ([]mm.Metric, error) {
+	rows, err := tx.Query(q.SQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := []mm.Metric{}
+	for rows.Next() {
+		vals := make([]sql.RawBytes, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string)
+		for i, col := range columns {
+			if vals[i] == nil {
+				continue // NULL
+			}
+			row[col] = string(vals[i])
+		}
+		rowMetrics, err := RowMetrics(q, row)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, rowMetrics...)
+	}
+	return metrics, rows.Err()
+}
+
+/**
+ * RowMetrics returns the metrics for one row, keyed on column name, sorted
+ * by column.  Column names are matched case-insensitively.  NULL columns
+ * are not in the row, so they're not reported.  It's an error if a metric
+ * column is not a number or a {column} placeholder isn't in the row.
+ */
+func RowMetrics(q Query, row map[string]string) ([]mm.Metric, error) {
+	values := make(map[string]string, len(row))
+	for col, val := range row {
+		values[strings.ToLower(col)] = val
+	}
+
+	var err error
+	prefix := placeholder.ReplaceAllStringFunc(q.Name, func(s string) string {
+		col := s[1 : len(s)-1]
+		val, ok := values[strings.ToLower(col)]
+		if !ok {
+			err = fmt.Errorf("Name placeholder %s: no value for column", s)
+			return ""
+		}
+		return nameValue(val)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, len(q.Metrics))
+	for col := range q.Metrics {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+
+	metrics := []mm.Metric{}
+	for _, col := range columns {
+		val, ok := values[strings.ToLower(col)]
+		if !ok {
+			continue // NULL or no such column
+		}
+		n, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Column %s: value is not a number: %s", col, val)
+		}
+		metrics = append(metrics, mm.Metric{Name: prefix + "/" + col, Type: q.Metrics[col], Number: n})
+	}
+	return metrics, nil
+}
+
+// nameValue makes a column value usable as part of a metric name: slashes
+// separate metric name parts, so they're replaced, and so are spaces.
+func nameValue(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "none"
+	}
+	return strings.NewReplacer("/", "_", " ", "_").Replace(s)
+}
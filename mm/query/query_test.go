@@ -0,0 +1,143 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package query_test
+
+import (
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/query"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/test"
+	. "launchpad.net/gocheck"
+	"testing"
+	"time"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type QueryTestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&QueryTestSuite{})
+
+func (s *QueryTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 1000)
+	s.logger = pct.NewLogger(s.logChan, "mm-query-test")
+}
+
+// --------------------------------------------------------------------------
+
+func (s *QueryTestSuite) TestValidate(t *C) {
+	q := query.Query{
+		Name:    "app/queue/{queue}",
+		SQL:     "SELECT queue, COUNT(*) AS depth FROM app.jobs GROUP BY queue;",
+		Metrics: map[string]string{"depth": "gauge"},
+	}
+	t.Check(q.Validate(), IsNil)
+
+	q.SQL = "  show global status like 'Com_select'"
+	t.Check(q.Validate(), IsNil)
+
+	q.SQL = "DELETE FROM app.jobs"
+	t.Check(q.Validate(), NotNil)
+
+	q.SQL = "SELECT 1; DROP TABLE app.jobs"
+	t.Check(q.Validate(), NotNil)
+
+	q.SQL = "SELECT queue FROM app.jobs INTO OUTFILE '/tmp/jobs'"
+	t.Check(q.Validate(), NotNil)
+
+	q.SQL = "SELECT COUNT(*) INTO @depth FROM app.jobs"
+	t.Check(q.Validate(), NotNil)
+
+	q.SQL = "SELECT COUNT(*) AS depth FROM app.jobs FOR UPDATE"
+	t.Check(q.Validate(), NotNil)
+
+	q.SQL = "SELECT COUNT(*) AS depth FROM app.jobs lock in share mode"
+	t.Check(q.Validate(), NotNil)
+
+	q.SQL = "SELECT COUNT(*) AS depth FROM app.jobs WHERE state = 'pointer'"
+	t.Check(q.Validate(), IsNil)
+
+	q.SQL = ""
+	t.Check(q.Validate(), NotNil)
+
+	q.SQL = "SELECT 1 AS depth"
+	q.Name = "app/queue/{queue"
+	t.Check(q.Validate(), NotNil)
+
+	q.Name = ""
+	t.Check(q.Validate(), NotNil)
+
+	q.Name = "app/queue"
+	q.Metrics = map[string]string{"depth": "string"}
+	t.Check(q.Validate(), NotNil)
+
+	q.Metrics = nil
+	t.Check(q.Validate(), NotNil)
+}
+
+func (s *QueryTestSuite) TestRowMetrics(t *C) {
+	q := query.Query{
+		Name:    "app/queue/{queue}",
+		SQL:     "SELECT queue, COUNT(*) AS depth, MAX(age) AS oldest, SUM(done) AS done FROM app.jobs GROUP BY queue",
+		Metrics: map[string]string{"depth": "gauge", "oldest": "gauge", "Done": "counter"},
+	}
+
+	// MySQL returns column names as written in the query, so they're matched
+	// case-insensitively.  NULL columns (oldest) aren't in the row.
+	got, err := query.RowMetrics(q, map[string]string{"queue": "email out", "DEPTH": "12", "done": "4096"})
+	t.Assert(err, IsNil)
+	expect := []mm.Metric{
+		{Name: "app/queue/email_out/Done", Type: "counter", Number: 4096},
+		{Name: "app/queue/email_out/depth", Type: "gauge", Number: 12},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	// No value for {queue}.
+	_, err = query.RowMetrics(q, map[string]string{"depth": "12"})
+	t.Check(err, NotNil)
+
+	// Not a number.
+	_, err = query.RowMetrics(q, map[string]string{"queue": "sms", "depth": "many"})
+	t.Check(err, NotNil)
+}
+
+func (s *QueryTestSuite) TestInvalidConfig(t *C) {
+	config := &query.Config{
+		Queries: []query.Query{
+			{
+				Name:    "app/cleanup",
+				SQL:     "UPDATE app.jobs SET done = 1",
+				Metrics: map[string]string{"done": "gauge"},
+			},
+		},
+	}
+	m := query.NewMonitor("mm-query-test", config, s.logger, nil)
+	err := m.Start(make(chan time.Time), make(chan *mm.Collection))
+	t.Check(err, NotNil)
+
+	m = query.NewMonitor("mm-query-test", &query.Config{}, s.logger, nil)
+	err = m.Start(make(chan time.Time), make(chan *mm.Collection))
+	t.Check(err, NotNil)
+}
@@ -0,0 +1,130 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mm
+
+import (
+	"fmt"
+	"sort"
+)
+
+/**
+ * A rollup merges an aggregator's reports into reports for a longer interval,
+ * e.g. five 1m reports into one 5m report.  Stats are merged from the values
+ * of each report, not their summaries, but memory is bounded, so percentiles
+ * are approximate if there are many values; see Stats.merge().  A rollup
+ * interval is reported when its last report interval is reported, or when a
+ * report for a later rollup interval arrives if some were missed.  Reports
+ * for an older rollup interval are ignored.
+ */
+type rollup struct {
+	interval int64
+	// Current rollup interval:
+	ts    int64 // start, Unix ts, 0 if none
+	stats map[string]*InstanceStats
+	// --
+	lastTs int64 // start of the last reported rollup interval, 0 if none
+}
+
+func newRollup(interval int64) *rollup {
+	r := &rollup{
+		interval: interval,
+	}
+	return r
+}
+
+// add merges the report and returns the rollup report if its interval is
+// complete.  A rollup report for the previous interval, if not reported yet,
+// is returned first.  It's an error if the report is for an older rollup
+// interval.
+func (r *rollup) add(report *Report) ([]*Report, error) {
+	reports := []*Report{}
+	ts := report.Ts.Unix()
+	start := (ts / r.interval) * r.interval
+	if (r.ts != 0 && start < r.ts) || start <= r.lastTs {
+		return nil, fmt.Errorf("Report for %s is older than the current %d second rollup interval", report.Ts, r.interval)
+	}
+	if r.ts != 0 && start != r.ts {
+		reports = append(reports, r.report())
+	}
+	if r.ts == 0 {
+		r.ts = start
+		r.stats = make(map[string]*InstanceStats)
+	}
+
+	for _, is := range report.Stats {
+		key := instanceKey(is.ServiceInstance)
+		ris, ok := r.stats[key]
+		if !ok {
+			ris = &InstanceStats{
+				ServiceInstance: is.ServiceInstance,
+				Stats:           make(map[string]*Stats),
+			}
+			r.stats[key] = ris
+		}
+		for metric, s := range is.Stats {
+			rs, ok := ris.Stats[metric]
+			if !ok {
+				rs, _ = NewStats(s.metricType)
+				rs.Stale = true
+				ris.Stats[metric] = rs
+			}
+			rs.merge(s)
+		}
+		if is.Coverage != nil {
+			if ris.Coverage == nil {
+				ris.Coverage = &Coverage{}
+			}
+			ris.Coverage.Expected += is.Coverage.Expected
+			ris.Coverage.Received += is.Coverage.Received
+			ris.Coverage.Late += is.Coverage.Late
+		}
+	}
+
+	if ts+int64(report.Duration) >= r.ts+r.interval {
+		reports = append(reports, r.report())
+	}
+	return reports, nil
+}
+
+// report summarizes and returns the current rollup interval, and resets it.
+func (r *rollup) report() *Report {
+	keys := make([]string, 0, len(r.stats))
+	for key := range r.stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	is := make([]*InstanceStats, len(keys))
+	for n, key := range keys {
+		is[n] = r.stats[key]
+		for _, s := range is[n].Stats {
+			s.Summarize()
+		}
+		if is[n].Coverage != nil {
+			is[n].Coverage.setMissing()
+		}
+	}
+	report := &Report{
+		Ts:       GoTime(r.interval, r.ts),
+		Duration: uint(r.interval),
+		Stats:    is,
+	}
+	r.lastTs = r.ts
+	r.ts = 0
+	r.stats = nil
+	return report
+}
@@ -0,0 +1,139 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package schema
+
+import (
+	"fmt"
+	"github.com/percona/percona-agent/mm"
+	"regexp"
+	"strings"
+)
+
+// Reading INFORMATION_SCHEMA.TABLES is slow on servers with many tables, so
+// it's not collected more often than this (seconds).
+const MIN_COLLECT = 60
+
+type Config struct {
+	mm.Config
+	SchemaInclude []string `json:",omitempty"` // regexp, all schemas if empty
+	SchemaExclude []string `json:",omitempty"` // regexp
+	MaxTables     uint     `json:",omitempty"` // largest tables to report, 0 = all
+	FileSize      bool     `json:",omitempty"` // .ibd file sizes, MySQL must be local
+}
+
+// System schemas are never reported; they're excluded in SQL, see Where().
+var systemSchemas = []string{"information_schema", "mysql", "performance_schema", "sys"}
+
+/**
+ * SchemaFilter matches schema names against Config.SchemaInclude and
+ * Config.SchemaExclude.  A schema is reported if it matches an include
+ * regexp (or there are none) and doesn't match an exclude regexp.  If every
+ * include regexp is a literal name, e.g. ^app$, the names are also used in
+ * SQL so other schemas aren't read at all.
+ */
+type SchemaFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	names   []string // literal include names, nil if any isn't one
+}
+
+func NewSchemaFilter(include, exclude []string) (*SchemaFilter, error) {
+	f := &SchemaFilter{
+		include: make([]*regexp.Regexp, len(include)),
+		exclude: make([]*regexp.Regexp, len(exclude)),
+	}
+	for i, pattern := range include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("SchemaInclude: %s", err)
+		}
+		f.include[i] = re
+		if name, ok := literalName(pattern); ok && (i == 0 || f.names != nil) {
+			f.names = append(f.names, name)
+		} else {
+			f.names = nil
+		}
+	}
+	for i, pattern := range exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("SchemaExclude: %s", err)
+		}
+		f.exclude[i] = re
+	}
+	return f, nil
+}
+
+// literalName returns the name in a ^name$ pattern, if it has no other
+// regexp syntax.
+func literalName(pattern string) (string, bool) {
+	if len(pattern) < 3 || pattern[0] != '^' || pattern[len(pattern)-1] != '$' {
+		return "", false
+	}
+	name := pattern[1 : len(pattern)-1]
+	if regexp.QuoteMeta(name) != name {
+		return "", false
+	}
+	return name, true
+}
+
+/**
+ * Where returns the SQL condition on TABLE_SCHEMA and its args: the literal
+ * include names if there are only those, else all but the system schemas.
+ * Match still has to be called on the schemas it returns.
+ */
+func (f *SchemaFilter) Where() (string, []interface{}) {
+	conds := []string{}
+	args := []interface{}{}
+	if f.names != nil {
+		conds = append(conds, "TABLE_SCHEMA IN ("+placeholders(len(f.names))+")")
+		for _, name := range f.names {
+			args = append(args, name)
+		}
+	}
+	conds = append(conds, "TABLE_SCHEMA NOT IN ("+placeholders(len(systemSchemas))+")")
+	for _, name := range systemSchemas {
+		args = append(args, name)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func (f *SchemaFilter) Match(schema string) bool {
+	if len(f.include) > 0 {
+		included := false
+		for _, re := range f.include {
+			if re.MatchString(schema) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(schema) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,281 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package schema
+
+import (
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+	"time"
+)
+
+/**
+ * Monitor reports how big schemas and tables are, so their growth can be
+ * charted.  It collects at a long interval, e.g. every 30 minutes, and its
+ * tick chan is not synchronized so it doesn't read the data dictionary at
+ * the same time as other monitors.
+ */
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   mysql.Connector
+	// --
+	tickChan       chan time.Time
+	collectionChan chan *mm.Collection
+	filter         *SchemaFilter
+	connected      bool
+	connectedChan  chan bool
+	status         *pct.Status
+	sync           *pct.SyncChan
+	running        bool
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Connector) *Monitor {
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		connectedChan: make(chan bool, 1),
+		status:        pct.NewStatus([]string{name, name + "-mysql"}),
+		sync:          pct.NewSyncChan(),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, collectionChan chan *mm.Collection) error {
+	m.logger.Debug("Start:call")
+	defer m.logger.Debug("Start:return")
+
+	if m.running {
+		return pct.ServiceIsRunningError{m.name}
+	}
+
+	if m.config.Collect < MIN_COLLECT {
+		return fmt.Errorf("Collect must be at least %d seconds", MIN_COLLECT)
+	}
+	filter, err := NewSchemaFilter(m.config.SchemaInclude, m.config.SchemaExclude)
+	if err != nil {
+		return err
+	}
+	m.filter = filter
+
+	m.tickChan = tickChan
+	m.collectionChan = collectionChan
+
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	m.logger.Debug("Stop:call")
+	defer m.logger.Debug("Stop:return")
+
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+
+	m.config = nil // no config if not running
+	m.running = false
+	m.logger.Info("Stopped")
+
+	// Do not update status to "Stopped" here; run() does that on return.
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+// Schema and table sizes change slowly, so they're collected like sysconfig.
+// @goroutine[0]
+func (m *Monitor) Synchronized() bool {
+	return false
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// run:@goroutine[3]
+func (m *Monitor) connect(err error) {
+	m.logger.Debug("connect:call")
+	defer m.logger.Debug("connect:return")
+
+	// Close/release previous connection, if any.
+	m.conn.Close()
+
+	// Try forever to connect to MySQL...
+	for {
+		m.logger.Debug("connect:try")
+
+		if err != nil {
+			m.status.Update(m.name+"-mysql", fmt.Sprintf("Connecting (%s)", err))
+		} else {
+			m.status.Update(m.name+"-mysql", fmt.Sprintf("Connecting"))
+		}
+		if err = m.conn.Connect(1); err != nil {
+			m.logger.Warn(err)
+			continue
+		}
+
+		// Tell run() goroutine that it can try to collect metrics.
+		m.logger.Info("Connected")
+		m.status.Update(m.name+"-mysql", "Connected")
+		m.connectedChan <- true
+		return
+	}
+}
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	m.logger.Debug("run:call")
+	defer func() {
+		m.conn.Close()
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+		m.logger.Debug("run:return")
+	}()
+
+	go m.connect(nil)
+
+	m.status.Update(m.name, "Ready")
+
+	var lastTs int64
+	var lastError string
+	for {
+		t := time.Unix(lastTs, 0)
+		if lastError == "" {
+			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s)", t))
+		} else {
+			m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s, error: %s)", t, lastError))
+		}
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:collect:start")
+			if !m.connected {
+				m.logger.Debug("run:collect:disconnected")
+				lastError = "Not connected to MySQL"
+				continue
+			}
+			lastError = ""
+
+			c := &mm.Collection{
+				ServiceInstance: proto.ServiceInstance{
+					Service:    m.config.Service,
+					InstanceId: m.config.InstanceId,
+				},
+				Ts: now.UTC().Unix(),
+			}
+
+			metrics, err := m.collect()
+			if err != nil {
+				m.logger.Warn(err)
+				lastError = err.Error()
+				continue
+			}
+			c.Metrics = metrics
+
+			// Send the metrics to an mm.Aggregator.
+			m.status.Update(m.name, "Sending metrics")
+			if len(c.Metrics) > 0 {
+				select {
+				case m.collectionChan <- c:
+					lastTs = c.Ts
+				case <-time.After(500 * time.Millisecond):
+					// lost collection
+					m.logger.Debug("Lost schema metrics; timeout spooling after 500ms")
+					pct.Metrics.Inc("mm/lost_collections", 1)
+					lastError = "Spool timeout"
+				}
+			} else {
+				m.logger.Debug("run:no metrics")
+				lastError = "No tables"
+			}
+
+			m.logger.Debug("run:collect:stop")
+		case connected := <-m.connectedChan:
+			m.connected = connected
+			if connected {
+				m.logger.Debug("run:connected:true")
+				m.status.Update(m.name, "Ready")
+			} else {
+				m.logger.Debug("run:connected:false")
+				go m.connect(nil)
+			}
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+// @goroutine[2]
+func (m *Monitor) collect() ([]mm.Metric, error) {
+	m.logger.Debug("collect:call")
+	defer m.logger.Debug("collect:return")
+
+	conn := m.conn.DB()
+
+	m.status.Update(m.name, "Getting table sizes")
+	tables, err := GetTables(conn, m.filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.config.FileSize {
+		m.status.Update(m.name, "Getting file sizes")
+		var datadir string
+		if err := conn.QueryRow("SELECT @@datadir").Scan(&datadir); err != nil {
+			m.logger.Warn("Get datadir:", err)
+		} else {
+			SetFileSizes(datadir, tables)
+		}
+	}
+
+	return TableMetrics(tables, m.config.MaxTables), nil
+}
@@ -0,0 +1,213 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package schema
+
+import (
+	"database/sql"
+	"github.com/percona/percona-agent/mm"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A base table in INFORMATION_SCHEMA.TABLES.  Values are estimates for InnoDB.
+type Table struct {
+	Schema      string
+	Name        string
+	DataLength  float64 // bytes
+	IndexLength float64 // bytes
+	Rows        float64
+	DataFree    float64 // bytes
+	FileSize    float64 // .ibd file bytes, -1 if unknown
+}
+
+// GetTables returns the base tables in the schemas matched by the filter.
+func GetTables(conn *sql.DB, filter *SchemaFilter) ([]Table, error) {
+	where, args := filter.Where()
+	rows, err := conn.Query("SELECT TABLE_SCHEMA, TABLE_NAME, DATA_LENGTH, INDEX_LENGTH, TABLE_ROWS, DATA_FREE"+
+		" FROM INFORMATION_SCHEMA.TABLES"+
+		" WHERE TABLE_TYPE = 'BASE TABLE' AND "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := []Table{}
+	for rows.Next() {
+		var schema, name string
+		var dataLength, indexLength, nRows, dataFree sql.NullFloat64 // NULL if table is corrupt, etc.
+		if err := rows.Scan(&schema, &name, &dataLength, &indexLength, &nRows, &dataFree); err != nil {
+			return nil, err
+		}
+		if !filter.Match(schema) {
+			continue
+		}
+		tables = append(tables, Table{
+			Schema:      schema,
+			Name:        name,
+			DataLength:  dataLength.Float64,
+			IndexLength: indexLength.Float64,
+			Rows:        nRows.Float64,
+			DataFree:    dataFree.Float64,
+			FileSize:    -1,
+		})
+	}
+	return tables, rows.Err()
+}
+
+// MySQL encodes other chars in file names, e.g. @002d for -, so only tables
+// with plain names have a known .ibd file.
+var plainName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+/**
+ * SetFileSizes sets the FileSize of tables that have a <datadir>/<schema>/
+ * <table>.ibd file, i.e. InnoDB tables with innodb_file_per_table.  Other
+ * tables, like MyISAM and partitioned tables, don't, so their FileSize stays
+ * -1.
+ */
+func SetFileSizes(datadir string, tables []Table) {
+	for i := range tables {
+		t := &tables[i]
+		if !plainName.MatchString(t.Schema) || !plainName.MatchString(t.Name) {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(datadir, t.Schema, t.Name+".ibd"))
+		if err != nil {
+			continue
+		}
+		t.FileSize = float64(fi.Size())
+	}
+}
+
+/**
+ * TableMetrics returns per-schema totals of all tables, as mysql/schema/
+ * <schema>/<metric> gauges, then per-table mysql/table/<schema>/<table>/
+ * <metric> gauges for the maxTables largest tables (data + index length),
+ * or all tables if maxTables is zero.  Both are sorted by name.  file_size
+ * is only reported if it's known.
+ */
+func TableMetrics(tables []Table, maxTables uint) []mm.Metric {
+	metrics := []mm.Metric{}
+
+	// Per-schema totals
+	totals := make(map[string]*schemaTotal)
+	for _, t := range tables {
+		st, ok := totals[t.Schema]
+		if !ok {
+			st = &schemaTotal{fileSize: -1}
+			totals[t.Schema] = st
+		}
+		st.tables++
+		st.dataLength += t.DataLength
+		st.indexLength += t.IndexLength
+		st.rows += t.Rows
+		st.dataFree += t.DataFree
+		if t.FileSize >= 0 {
+			if st.fileSize < 0 {
+				st.fileSize = 0
+			}
+			st.fileSize += t.FileSize
+		}
+	}
+	schemas := make([]string, 0, len(totals))
+	for schema := range totals {
+		schemas = append(schemas, schema)
+	}
+	sort.Strings(schemas)
+	for _, schema := range schemas {
+		st := totals[schema]
+		prefix := "mysql/schema/" + nameValue(schema) + "/"
+		metrics = append(metrics,
+			mm.Metric{Name: prefix + "data_free", Type: "gauge", Number: st.dataFree},
+			mm.Metric{Name: prefix + "data_length", Type: "gauge", Number: st.dataLength},
+		)
+		if st.fileSize >= 0 {
+			metrics = append(metrics, mm.Metric{Name: prefix + "file_size", Type: "gauge", Number: st.fileSize})
+		}
+		metrics = append(metrics,
+			mm.Metric{Name: prefix + "index_length", Type: "gauge", Number: st.indexLength},
+			mm.Metric{Name: prefix + "rows", Type: "gauge", Number: st.rows},
+			mm.Metric{Name: prefix + "tables", Type: "gauge", Number: st.tables},
+		)
+	}
+
+	// Per-table, largest first to cap them, then by name.
+	top := make([]Table, len(tables))
+	copy(top, tables)
+	if maxTables > 0 && uint(len(top)) > maxTables {
+		sort.Sort(bySize(top))
+		top = top[0:maxTables]
+	}
+	sort.Sort(byName(top))
+	for _, t := range top {
+		prefix := "mysql/table/" + nameValue(t.Schema) + "/" + nameValue(t.Name) + "/"
+		metrics = append(metrics,
+			mm.Metric{Name: prefix + "data_free", Type: "gauge", Number: t.DataFree},
+			mm.Metric{Name: prefix + "data_length", Type: "gauge", Number: t.DataLength},
+		)
+		if t.FileSize >= 0 {
+			metrics = append(metrics, mm.Metric{Name: prefix + "file_size", Type: "gauge", Number: t.FileSize})
+		}
+		metrics = append(metrics,
+			mm.Metric{Name: prefix + "index_length", Type: "gauge", Number: t.IndexLength},
+			mm.Metric{Name: prefix + "rows", Type: "gauge", Number: t.Rows},
+		)
+	}
+
+	return metrics
+}
+
+type schemaTotal struct {
+	tables      float64
+	dataLength  float64
+	indexLength float64
+	rows        float64
+	dataFree    float64
+	fileSize    float64 // -1 if unknown for all tables
+}
+
+type bySize []Table
+
+func (a bySize) Len() int      { return len(a) }
+func (a bySize) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a bySize) Less(i, j int) bool {
+	si, sj := a[i].DataLength+a[i].IndexLength, a[j].DataLength+a[j].IndexLength
+	if si != sj {
+		return si > sj
+	}
+	return byName(a).Less(i, j)
+}
+
+type byName []Table
+
+func (a byName) Len() int      { return len(a) }
+func (a byName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool {
+	if a[i].Schema != a[j].Schema {
+		return a[i].Schema < a[j].Schema
+	}
+	return a[i].Name < a[j].Name
+}
+
+// nameValue makes a schema or table name usable as part of a metric name:
+// slashes separate metric name parts, so they're replaced.
+func nameValue(s string) string {
+	return strings.Replace(s, "/", "_", -1)
+}
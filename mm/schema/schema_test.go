@@ -0,0 +1,136 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package schema_test
+
+import (
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/mm/schema"
+	"github.com/percona/percona-agent/test"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type SchemaTestSuite struct {
+}
+
+var _ = Suite(&SchemaTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *SchemaTestSuite) TestSchemaFilter(t *C) {
+	// System schemas are excluded in SQL.
+	f, err := schema.NewSchemaFilter(nil, nil)
+	t.Assert(err, IsNil)
+	t.Check(f.Match("app"), Equals, true)
+	where, args := f.Where()
+	t.Check(where, Equals, "TABLE_SCHEMA NOT IN (?, ?, ?, ?)")
+	t.Check(args, DeepEquals, []interface{}{"information_schema", "mysql", "performance_schema", "sys"})
+
+	f, err = schema.NewSchemaFilter([]string{"^app", "^shop$"}, []string{"_archive$"})
+	t.Assert(err, IsNil)
+	t.Check(f.Match("app"), Equals, true)
+	t.Check(f.Match("app2"), Equals, true)
+	t.Check(f.Match("app_archive"), Equals, false)
+	t.Check(f.Match("shop"), Equals, true)
+	t.Check(f.Match("shop2"), Equals, false)
+	t.Check(f.Match("test"), Equals, false)
+	where, _ = f.Where()
+	t.Check(where, Equals, "TABLE_SCHEMA NOT IN (?, ?, ?, ?)")
+
+	// Only literal names, so they're in SQL, too.
+	f, err = schema.NewSchemaFilter([]string{"^app$", "^shop_1$"}, nil)
+	t.Assert(err, IsNil)
+	where, args = f.Where()
+	t.Check(where, Equals, "TABLE_SCHEMA IN (?, ?) AND TABLE_SCHEMA NOT IN (?, ?, ?, ?)")
+	t.Check(args, DeepEquals, []interface{}{"app", "shop_1", "information_schema", "mysql", "performance_schema", "sys"})
+	t.Check(f.Match("app"), Equals, true)
+	t.Check(f.Match("app2"), Equals, false)
+
+	_, err = schema.NewSchemaFilter([]string{"(app"}, nil)
+	t.Check(err, NotNil)
+	_, err = schema.NewSchemaFilter(nil, []string{"(app"})
+	t.Check(err, NotNil)
+}
+
+func (s *SchemaTestSuite) TestTableMetrics(t *C) {
+	tables := []schema.Table{
+		{Schema: "shop", Name: "orders", DataLength: 1000, IndexLength: 500, Rows: 10, DataFree: 0, FileSize: 2048},
+		{Schema: "app", Name: "users", DataLength: 300, IndexLength: 100, Rows: 3, DataFree: 10, FileSize: -1},
+		{Schema: "app", Name: "log", DataLength: 5000, IndexLength: 0, Rows: 50, DataFree: 20, FileSize: -1},
+	}
+
+	// Largest 2 tables, but schema totals include all tables.
+	got := schema.TableMetrics(tables, 2)
+	expect := []mm.Metric{
+		{Name: "mysql/schema/app/data_free", Type: "gauge", Number: 30},
+		{Name: "mysql/schema/app/data_length", Type: "gauge", Number: 5300},
+		{Name: "mysql/schema/app/index_length", Type: "gauge", Number: 100},
+		{Name: "mysql/schema/app/rows", Type: "gauge", Number: 53},
+		{Name: "mysql/schema/app/tables", Type: "gauge", Number: 2},
+		{Name: "mysql/schema/shop/data_free", Type: "gauge", Number: 0},
+		{Name: "mysql/schema/shop/data_length", Type: "gauge", Number: 1000},
+		{Name: "mysql/schema/shop/file_size", Type: "gauge", Number: 2048},
+		{Name: "mysql/schema/shop/index_length", Type: "gauge", Number: 500},
+		{Name: "mysql/schema/shop/rows", Type: "gauge", Number: 10},
+		{Name: "mysql/schema/shop/tables", Type: "gauge", Number: 1},
+		{Name: "mysql/table/app/log/data_free", Type: "gauge", Number: 20},
+		{Name: "mysql/table/app/log/data_length", Type: "gauge", Number: 5000},
+		{Name: "mysql/table/app/log/index_length", Type: "gauge", Number: 0},
+		{Name: "mysql/table/app/log/rows", Type: "gauge", Number: 50},
+		{Name: "mysql/table/shop/orders/data_free", Type: "gauge", Number: 0},
+		{Name: "mysql/table/shop/orders/data_length", Type: "gauge", Number: 1000},
+		{Name: "mysql/table/shop/orders/file_size", Type: "gauge", Number: 2048},
+		{Name: "mysql/table/shop/orders/index_length", Type: "gauge", Number: 500},
+		{Name: "mysql/table/shop/orders/rows", Type: "gauge", Number: 10},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	// No max, all tables.
+	got = schema.TableMetrics(tables, 0)
+	t.Check(got, HasLen, len(expect)+4)
+
+	got = schema.TableMetrics([]schema.Table{}, 0)
+	t.Check(got, HasLen, 0)
+}
+
+func (s *SchemaTestSuite) TestSetFileSizes(t *C) {
+	datadir, err := ioutil.TempDir("", "percona-agent-test-schema")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(datadir)
+
+	t.Assert(os.Mkdir(filepath.Join(datadir, "app"), 0755), IsNil)
+	t.Assert(ioutil.WriteFile(filepath.Join(datadir, "app", "users.ibd"), make([]byte, 1024), 0644), IsNil)
+
+	tables := []schema.Table{
+		{Schema: "app", Name: "users", FileSize: -1},
+		{Schema: "app", Name: "myisam", FileSize: -1}, // no .ibd file
+		{Schema: "app", Name: "a-b", FileSize: -1},    // a@002db.ibd
+	}
+	schema.SetFileSizes(datadir, tables)
+	t.Check(tables[0].FileSize, Equals, float64(1024))
+	t.Check(tables[1].FileSize, Equals, float64(-1))
+	t.Check(tables[2].FileSize, Equals, float64(-1))
+}
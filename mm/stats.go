@@ -25,7 +25,8 @@ import (
 
 type Stats struct {
 	metricType string    `json:"-"` // ignore
-	str        string    `json:",omitempty"`
+	Str        string    `json:",omitempty"`
+	Stale      bool      `json:",omitempty"` // reported before but not this interval
 	firstVal   bool      `json:"-"`
 	prevTs     int64     `json:"-"`
 	prevVal    float64   `json:"-"`
@@ -38,8 +39,20 @@ type Stats struct {
 	Med        float64
 	Pct95      float64
 	Max        float64
+	// Histogram: bucket bounds and counts for the interval, see Metric.
+	Bounds     []float64 `json:",omitempty"`
+	Counts     []float64 `json:",omitempty"`
+	prevCounts []float64 `json:"-"`
+
+	// Merged stats: vals are a bounded sample where vals[i] stands for
+	// weights[i] values, nil if not merged, see merge().
+	weights []float64 `json:"-"`
 }
 
+// Max values kept when merging stats, see merge().  Percentiles of merged
+// stats with more values are approximate.
+const MAX_MERGED_VALS = 1000
+
 func NewStats(metricType string) (*Stats, error) {
 	if !MetricTypes[metricType] {
 		return nil, errors.New("Invalid metric type: " + metricType)
@@ -84,6 +97,39 @@ func (s *Stats) Add(m *Metric, ts int64) {
 			s.prevVal = m.Number
 			s.firstVal = false
 		}
+	case "string":
+		// Strings can't be summarized, so only the latest value is reported.
+		s.Str = m.String
+		s.Cnt++
+	case "histogram":
+		if len(m.Counts) != len(m.Bounds)+1 {
+			return // invalid histogram
+		}
+		if s.firstVal || !sameBounds(s.Bounds, m.Bounds) {
+			// First value, or the buckets changed so previous counts don't
+			// apply: restart the distribution.
+			s.Bounds = m.Bounds
+			s.Counts = make([]float64, len(m.Counts))
+			s.prevCounts = append([]float64{}, m.Counts...)
+			s.firstVal = false
+			return
+		}
+		// Like a counter, the interval's distribution is how much each bucket
+		// increased.  If any bucket decreased, the histogram was reset, e.g.
+		// FLUSH QUERY_RESPONSE_TIME.
+		reset := false
+		for i := range m.Counts {
+			if m.Counts[i] < s.prevCounts[i] {
+				reset = true
+				break
+			}
+		}
+		if !reset {
+			for i := range m.Counts {
+				s.Counts[i] += m.Counts[i] - s.prevCounts[i]
+			}
+		}
+		s.prevCounts = append(s.prevCounts[:0], m.Counts...)
 	default:
 		// This should not happen because type is checked in NewStats().
 		log.Panic("mm:Aggregator:Add: Invalid metric type: " + s.metricType)
@@ -92,7 +138,17 @@ func (s *Stats) Add(m *Metric, ts int64) {
 
 func (s *Stats) Summarize() {
 	switch s.metricType {
+	case "histogram":
+		// Cnt is the number of values in the interval, e.g. queries.
+		s.Cnt = 0
+		for _, n := range s.Counts {
+			s.Cnt += int(n)
+		}
 	case "gauge", "counter":
+		if s.weights != nil {
+			s.summarizeMerged()
+			return
+		}
 		s.Cnt = len(s.vals)
 		if s.Cnt > 1 {
 			sort.Float64s(s.vals)
@@ -113,3 +169,131 @@ func (s *Stats) Summarize() {
 		}
 	}
 }
+
+/**
+ * merge adds the values of another interval's summarized stats, so summarizing
+ * the merged stats is like all values were in one interval.  To bound memory,
+ * at most MAX_MERGED_VALS values are kept: when there are more, adjacent values
+ * are merged into their weighted average.  Cnt, Min, Avg, and Max are exact,
+ * but then percentiles are approximate.  Stale is true only if the metric was
+ * stale in every merged interval, so stats for merging should start Stale.
+ */
+func (s *Stats) merge(o *Stats) {
+	if !o.Stale {
+		s.Stale = false
+	}
+	switch s.metricType {
+	case "gauge", "counter":
+		if o.Cnt == 0 {
+			return
+		}
+		if s.weights == nil {
+			s.weights = []float64{}
+			s.Min = o.Min
+			s.Max = o.Max
+		}
+		if o.Min < s.Min {
+			s.Min = o.Min
+		}
+		if o.Max > s.Max {
+			s.Max = o.Max
+		}
+		s.vals = append(s.vals, o.vals...)
+		if o.weights != nil {
+			s.weights = append(s.weights, o.weights...)
+		} else {
+			for _ = range o.vals {
+				s.weights = append(s.weights, 1)
+			}
+		}
+		s.sum += o.sum
+		s.Cnt += o.Cnt
+		for len(s.vals) > MAX_MERGED_VALS {
+			s.compact()
+		}
+	case "string":
+		if o.Cnt > 0 {
+			s.Str = o.Str
+			s.Cnt += o.Cnt
+		}
+	case "histogram":
+		if len(o.Counts) == 0 {
+			return
+		}
+		if !sameBounds(s.Bounds, o.Bounds) {
+			// Buckets changed, so only the latest distribution applies.
+			s.Bounds = o.Bounds
+			s.Counts = make([]float64, len(o.Counts))
+		}
+		for i := range o.Counts {
+			s.Counts[i] += o.Counts[i]
+		}
+	}
+}
+
+// compact halves the merged values by merging pairs of adjacent values.
+func (s *Stats) compact() {
+	sort.Sort(weightedVals{s.vals, s.weights})
+	n := 0
+	for i := 0; i < len(s.vals); i += 2 {
+		if i+1 == len(s.vals) {
+			s.vals[n], s.weights[n] = s.vals[i], s.weights[i]
+		} else {
+			w := s.weights[i] + s.weights[i+1]
+			s.vals[n] = (s.vals[i]*s.weights[i] + s.vals[i+1]*s.weights[i+1]) / w
+			s.weights[n] = w
+		}
+		n++
+	}
+	s.vals = s.vals[:n]
+	s.weights = s.weights[:n]
+}
+
+// summarizeMerged summarizes merged stats; Cnt, Min, and Max are set by merge().
+func (s *Stats) summarizeMerged() {
+	if s.Cnt == 0 {
+		return
+	}
+	sort.Sort(weightedVals{s.vals, s.weights})
+	pct := func(p int) float64 {
+		// Like vals[(p*Cnt)/100] if all values were kept.
+		rank := float64((p * s.Cnt) / 100)
+		var n float64
+		for i, w := range s.weights {
+			n += w
+			if n > rank {
+				return s.vals[i]
+			}
+		}
+		return s.vals[len(s.vals)-1]
+	}
+	s.Pct5 = pct(5)
+	s.Avg = s.sum / float64(s.Cnt)
+	s.Med = pct(50)
+	s.Pct95 = pct(95)
+}
+
+// weightedVals sorts merged values and their weights by value.
+type weightedVals struct {
+	vals    []float64
+	weights []float64
+}
+
+func (w weightedVals) Len() int           { return len(w.vals) }
+func (w weightedVals) Less(i, j int) bool { return w.vals[i] < w.vals[j] }
+func (w weightedVals) Swap(i, j int) {
+	w.vals[i], w.vals[j] = w.vals[j], w.vals[i]
+	w.weights[i], w.weights[j] = w.weights[j], w.weights[i]
+}
+
+func sameBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,370 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"errors"
+	"github.com/percona/percona-agent/mm"
+	"github.com/percona/percona-agent/pct"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/**
+ * In a container, /proc/meminfo and /proc/stat are the host's, but the
+ * container is limited by its cgroup.  Cgroup metrics are the CPU, memory and
+ * block I/O limits and usage of a cgroup: the system monitor reports the
+ * cgroup the agent runs in, and the MySQL monitor reports mysqld's cgroup
+ * with its process metrics (mysql/process/cgroup/).  Cgroup v1 has
+ * a hierarchy per controller (cpu, cpuacct, memory, blkio); v2 has one unified
+ * hierarchy.  The metric names are the same for both versions.
+ *
+ * https://www.kernel.org/doc/Documentation/cgroup-v1/
+ * https://www.kernel.org/doc/Documentation/cgroup-v2.txt
+ */
+
+// Memory limits this big mean no limit: v1 reports unlimited as the max
+// int64 rounded down to the page size.
+const cgroupNoLimit = 1 << 62
+
+type Cgroup struct {
+	Version int
+	dirs    map[string]string // v1: controller => dir, v2: "" => dir
+}
+
+/**
+ * DetectCgroup returns the cgroup of process pid, or of this process if pid
+ * is 0.  root is the filesystem root, usually "/", so tests can use a fake
+ * one: the process's cgroup is read from <root>/proc/<pid>/cgroup and cgroup
+ * filesystems are mounted at <root>/sys/fs/cgroup.  If
+ * <root>/sys/fs/cgroup/cgroup.controllers exists, it's cgroup v2, else v1.
+ */
+func DetectCgroup(root string, pid int) (*Cgroup, error) {
+	self := pid <= 0
+	proc := "self"
+	if !self {
+		proc = strconv.Itoa(pid)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(root, "proc", proc, "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	mountDir := filepath.Join(root, "sys", "fs", "cgroup")
+
+	/**
+	 * v1, one line per hierarchy:
+	 *   4:cpu,cpuacct:/docker/0d1c2b...
+	 *   3:memory:/docker/0d1c2b...
+	 * v2, one line:
+	 *   0::/system.slice/mysql.service
+	 */
+	cgroup := &Cgroup{dirs: make(map[string]string)}
+	if pct.FileExists(filepath.Join(mountDir, "cgroup.controllers")) {
+		cgroup.Version = 2
+		for _, line := range strings.Split(string(content), "\n") {
+			fields := strings.SplitN(line, ":", 3)
+			if len(fields) == 3 && fields[0] == "0" && fields[1] == "" {
+				if dir := cgroupDir(mountDir, fields[2], self); dir != "" {
+					cgroup.dirs[""] = dir
+				}
+				break
+			}
+		}
+	} else {
+		cgroup.Version = 1
+		for _, line := range strings.Split(string(content), "\n") {
+			fields := strings.SplitN(line, ":", 3)
+			if len(fields) != 3 || fields[1] == "" {
+				continue
+			}
+			// The hierarchy is mounted at its controller list, e.g. cpu,cpuacct,
+			// and usually there are symlinks for each controller, e.g. cpu.
+			controllers := strings.Split(fields[1], ",")
+			for _, name := range append([]string{fields[1]}, controllers...) {
+				dir := filepath.Join(mountDir, name)
+				if !pct.FileExists(dir) {
+					continue
+				}
+				if dir = cgroupDir(dir, fields[2], self); dir != "" {
+					for _, controller := range controllers {
+						cgroup.dirs[controller] = dir
+					}
+				}
+				break
+			}
+		}
+	}
+	if len(cgroup.dirs) == 0 {
+		return nil, errors.New("No cgroup in " + mountDir)
+	}
+	return cgroup, nil
+}
+
+// InContainer returns true if root is the root of a Docker or Podman container.
+func InContainer(root string) bool {
+	return pct.FileExists(filepath.Join(root, ".dockerenv")) || pct.FileExists(filepath.Join(root, "run", ".containerenv"))
+}
+
+// cgroupDir returns the dir of cgroup path in the hierarchy mounted at
+// mountDir, or "" if it doesn't exist.  In a container without a cgroup
+// namespace the path is the container's cgroup on the host, e.g. /docker/<id>,
+// but the container's cgroup is mounted at mountDir, so for this process
+// (self) mountDir is its cgroup.  For another process, e.g. mysqld in another
+// container, mountDir is not its cgroup, so it can't be resolved.
+func cgroupDir(mountDir, path string, self bool) string {
+	dir := filepath.Join(mountDir, path)
+	if !pct.FileExists(dir) {
+		if self {
+			return mountDir
+		}
+		return ""
+	}
+	return dir
+}
+
+// Metrics returns cgroup/ metrics prefixed with prefix, e.g. mysql/process/.  Files that don't exist are skipped:
+// which exist depends on the kernel, the controllers enabled for the cgroup,
+// and whether it's the root cgroup which has no limits.
+func (cg *Cgroup) Metrics(prefix string) []mm.Metric {
+	if cg.Version == 2 {
+		return cg.metricsV2(prefix)
+	}
+	return cg.metricsV1(prefix)
+}
+
+func (cg *Cgroup) metricsV1(prefix string) []mm.Metric {
+	metrics := []mm.Metric{}
+
+	if dir, ok := cg.dirs["cpu"]; ok {
+		quota, err1 := readCgroupValue(filepath.Join(dir, "cpu.cfs_quota_us"))
+		period, err2 := readCgroupValue(filepath.Join(dir, "cpu.cfs_period_us"))
+		if err1 == nil && err2 == nil && quota > 0 && period > 0 {
+			// Quota -1 means no limit.
+			metrics = append(metrics, mm.Metric{Name: prefix + "cgroup/cpu_limit", Type: "gauge", Number: quota / period})
+		}
+		if stat, err := readCgroupStats(filepath.Join(dir, "cpu.stat")); err == nil {
+			metrics = append(metrics,
+				mm.Metric{Name: prefix + "cgroup/cpu_nr_periods", Type: "counter", Number: stat["nr_periods"]},
+				mm.Metric{Name: prefix + "cgroup/cpu_nr_throttled", Type: "counter", Number: stat["nr_throttled"]},
+				mm.Metric{Name: prefix + "cgroup/cpu_throttled_time", Type: "counter", Number: stat["throttled_time"] / 1e9}, // ns
+			)
+		}
+	}
+
+	if dir, ok := cg.dirs["cpuacct"]; ok {
+		if usage, err := readCgroupValue(filepath.Join(dir, "cpuacct.usage")); err == nil {
+			metrics = append(metrics, mm.Metric{Name: prefix + "cgroup/cpu_usage", Type: "counter", Number: usage / 1e9}) // ns
+		}
+		if stat, err := readCgroupStats(filepath.Join(dir, "cpuacct.stat")); err == nil {
+			metrics = append(metrics,
+				mm.Metric{Name: prefix + "cgroup/cpu_user", Type: "counter", Number: stat["user"] / CLOCK_TICKS},
+				mm.Metric{Name: prefix + "cgroup/cpu_system", Type: "counter", Number: stat["system"] / CLOCK_TICKS},
+			)
+		}
+	}
+
+	if dir, ok := cg.dirs["memory"]; ok {
+		if limit, err := readCgroupValue(filepath.Join(dir, "memory.limit_in_bytes")); err == nil && limit < cgroupNoLimit {
+			metrics = append(metrics, mm.Metric{Name: prefix + "cgroup/memory_limit", Type: "gauge", Number: limit})
+		}
+		if usage, err := readCgroupValue(filepath.Join(dir, "memory.usage_in_bytes")); err == nil {
+			metrics = append(metrics, mm.Metric{Name: prefix + "cgroup/memory_usage", Type: "gauge", Number: usage})
+		}
+		if stat, err := readCgroupStats(filepath.Join(dir, "memory.stat")); err == nil {
+			metrics = append(metrics,
+				mm.Metric{Name: prefix + "cgroup/memory_rss", Type: "gauge", Number: stat["rss"]},
+				mm.Metric{Name: prefix + "cgroup/memory_cache", Type: "gauge", Number: stat["cache"]},
+			)
+			if swap, ok := stat["swap"]; ok { // only if swap accounting is enabled
+				metrics = append(metrics, mm.Metric{Name: prefix + "cgroup/memory_swap", Type: "gauge", Number: swap})
+			}
+		}
+		if failcnt, err := readCgroupValue(filepath.Join(dir, "memory.failcnt")); err == nil {
+			metrics = append(metrics, mm.Metric{Name: prefix + "cgroup/memory_limit_hits", Type: "counter", Number: failcnt})
+		}
+		// oom_kill is new in 4.13.
+		if oom, err := readCgroupStats(filepath.Join(dir, "memory.oom_control")); err == nil {
+			if kills, ok := oom["oom_kill"]; ok {
+				metrics = append(metrics, mm.Metric{Name: prefix + "cgroup/memory_oom_kills", Type: "counter", Number: kills})
+			}
+		}
+	}
+
+	if dir, ok := cg.dirs["blkio"]; ok {
+		// Throttle stats count all I/O; the CFQ stats (blkio.io_*) only count
+		// I/O when the CFQ scheduler is used.
+		if bytes, err := readBlkioStats(filepath.Join(dir, "blkio.throttle.io_service_bytes")); err == nil {
+			metrics = append(metrics,
+				mm.Metric{Name: prefix + "cgroup/blkio_read_bytes", Type: "counter", Number: bytes["Read"]},
+				mm.Metric{Name: prefix + "cgroup/blkio_write_bytes", Type: "counter", Number: bytes["Write"]},
+			)
+		}
+		if ios, err := readBlkioStats(filepath.Join(dir, "blkio.throttle.io_serviced")); err == nil {
+			metrics = append(metrics,
+				mm.Metric{Name: prefix + "cgroup/blkio_reads", Type: "counter", Number: ios["Read"]},
+				mm.Metric{Name: prefix + "cgroup/blkio_writes", Type: "counter", Number: ios["Write"]},
+			)
+		}
+	}
+
+	return metrics
+}
+
+func (cg *Cgroup) metricsV2(prefix string) []mm.Metric {
+	metrics := []mm.Metric{}
+	dir := cg.dirs[""]
+
+	// "max 100000" if no limit, else "<quota> <period>", both in microseconds.
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "cpu.max")); err == nil {
+		fields := strings.Fields(string(content))
+		if len(fields) == 2 && fields[0] != "max" {
+			quota, period := StrToFloat(fields[0]), StrToFloat(fields[1])
+			if quota > 0 && period > 0 {
+				metrics = append(metrics, mm.Metric{Name: prefix + "cgroup/cpu_limit", Type: "gauge", Number: quota / period})
+			}
+		}
+	}
+	if stat, err := readCgroupStats(filepath.Join(dir, "cpu.stat")); err == nil {
+		metrics = append(metrics,
+			mm.Metric{Name: prefix + "cgroup/cpu_usage", Type: "counter", Number: stat["usage_usec"] / 1e6},
+			mm.Metric{Name: prefix + "cgroup/cpu_user", Type: "counter", Number: stat["user_usec"] / 1e6},
+			mm.Metric{Name: prefix + "cgroup/cpu_system", Type: "counter", Number: stat["system_usec"] / 1e6},
+		)
+		// Only if the cpu controller is enabled.
+		if _, ok := stat["nr_periods"]; ok {
+			metrics = append(metrics,
+				mm.Metric{Name: prefix + "cgroup/cpu_nr_periods", Type: "counter", Number: stat["nr_periods"]},
+				mm.Metric{Name: prefix + "cgroup/cpu_nr_throttled", Type: "counter", Number: stat["nr_throttled"]},
+				mm.Metric{Name: prefix + "cgroup/cpu_throttled_time", Type: "counter", Number: stat["throttled_usec"] / 1e6},
+			)
+		}
+	}
+
+	// "max" if no limit.
+	if limit, err := readCgroupValue(filepath.Join(dir, "memory.max")); err == nil {
+		metrics = append(metrics, mm.Metric{Name: prefix + "cgroup/memory_limit", Type: "gauge", Number: limit})
+	}
+	if usage, err := readCgroupValue(filepath.Join(dir, "memory.current")); err == nil {
+		metrics = append(metrics, mm.Metric{Name: prefix + "cgroup/memory_usage", Type: "gauge", Number: usage})
+	}
+	if stat, err := readCgroupStats(filepath.Join(dir, "memory.stat")); err == nil {
+		metrics = append(metrics,
+			mm.Metric{Name: prefix + "cgroup/memory_rss", Type: "gauge", Number: stat["anon"]},
+			mm.Metric{Name: prefix + "cgroup/memory_cache", Type: "gauge", Number: stat["file"]},
+		)
+	}
+	if swap, err := readCgroupValue(filepath.Join(dir, "memory.swap.current")); err == nil {
+		metrics = append(metrics, mm.Metric{Name: prefix + "cgroup/memory_swap", Type: "gauge", Number: swap})
+	}
+	if events, err := readCgroupStats(filepath.Join(dir, "memory.events")); err == nil {
+		metrics = append(metrics,
+			mm.Metric{Name: prefix + "cgroup/memory_limit_hits", Type: "counter", Number: events["max"]},
+			mm.Metric{Name: prefix + "cgroup/memory_oom_events", Type: "counter", Number: events["oom"]},
+			mm.Metric{Name: prefix + "cgroup/memory_oom_kills", Type: "counter", Number: events["oom_kill"]},
+		)
+	}
+
+	/**
+	 * One line per device:
+	 *   8:0 rbytes=90430464 wbytes=299008 rios=8950 wios=40 dbytes=0 dios=0
+	 */
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "io.stat")); err == nil {
+		io := make(map[string]float64)
+		for _, line := range strings.Split(string(content), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			for _, field := range fields[1:] {
+				keyVal := strings.SplitN(field, "=", 2)
+				if len(keyVal) == 2 {
+					io[keyVal[0]] += StrToFloat(keyVal[1])
+				}
+			}
+		}
+		metrics = append(metrics,
+			mm.Metric{Name: prefix + "cgroup/blkio_read_bytes", Type: "counter", Number: io["rbytes"]},
+			mm.Metric{Name: prefix + "cgroup/blkio_write_bytes", Type: "counter", Number: io["wbytes"]},
+			mm.Metric{Name: prefix + "cgroup/blkio_reads", Type: "counter", Number: io["rios"]},
+			mm.Metric{Name: prefix + "cgroup/blkio_writes", Type: "counter", Number: io["wios"]},
+		)
+	}
+
+	return metrics
+}
+
+// readCgroupValue returns the number in a single-value file like
+// memory.usage_in_bytes, or an error if the value is "max" (no limit).
+func readCgroupValue(file string) (float64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return 0, errors.New(file + ": max")
+	}
+	return StrToFloat(value), nil
+}
+
+// readCgroupStats returns the values in a flat keyed file like cpu.stat.
+func readCgroupStats(file string) (map[string]float64, error) {
+	/**
+	 * nr_periods 12345
+	 * nr_throttled 67
+	 * ...
+	 */
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]float64)
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		stats[fields[0]] = StrToFloat(fields[1])
+	}
+	return stats, nil
+}
+
+// readBlkioStats returns the v1 blkio stats summed for all devices.
+func readBlkioStats(file string) (map[string]float64, error) {
+	/**
+	 * 8:0 Read 90430464
+	 * 8:0 Write 299008
+	 * ...
+	 * Total 90729472
+	 */
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]float64)
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue // Total line
+		}
+		stats[fields[1]] += StrToFloat(fields[2])
+	}
+	return stats, nil
+}
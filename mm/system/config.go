@@ -22,4 +22,11 @@ import (
 
 type Config struct {
 	mm.Config
+	FsTypeInclude []string // only these fstypes, e.g. ext4, xfs; default all but VirtualFsTypes
+	FsTypeExclude []string
+	MountInclude  []string // regexp, see FsFilter
+	MountExclude  []string // regexp, see FsFilter
+	// Seconds between tcp/state/ metrics from /proc/net/tcp, which is
+	// expensive with many sockets; 0 (default) disables them.
+	TCPStatesInterval uint `json:",omitempty"`
 }
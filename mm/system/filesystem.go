@@ -0,0 +1,362 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"fmt"
+	"github.com/percona/percona-agent/mm"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Seconds to wait for statfs, which blocks if a network filesystem is hung.
+const STATFS_TIMEOUT = 2
+
+// Virtual filesystems have no capacity, so they're never reported unless
+// their fstype is in Config.FsTypeInclude.
+var VirtualFsTypes = map[string]bool{
+	"autofs":      true,
+	"binfmt_misc": true,
+	"bpf":         true,
+	"cgroup":      true,
+	"cgroup2":     true,
+	"configfs":    true,
+	"debugfs":     true,
+	"devpts":      true,
+	"devtmpfs":    true,
+	"fusectl":     true,
+	"hugetlbfs":   true,
+	"mqueue":      true,
+	"nsfs":        true,
+	"proc":        true,
+	"pstore":      true,
+	"ramfs":       true,
+	"rpc_pipefs":  true,
+	"securityfs":  true,
+	"sysfs":       true,
+	"tmpfs":       true,
+	"tracefs":     true,
+}
+
+// Network filesystems can hang, so they're not reported unless their fstype
+// is in Config.FsTypeInclude.
+var NetworkFsTypes = map[string]bool{
+	"9p":             true,
+	"afs":            true,
+	"ceph":           true,
+	"cifs":           true,
+	"fuse.glusterfs": true,
+	"fuse.sshfs":     true,
+	"glusterfs":      true,
+	"ncpfs":          true,
+	"nfs":            true,
+	"nfs4":           true,
+	"smb3":           true,
+	"smbfs":          true,
+}
+
+// A mounted filesystem from /proc/mounts.
+type Mount struct {
+	Device  string
+	Path    string // mount point
+	FsType  string
+	Options string // e.g. rw,noatime
+}
+
+/**
+ * FsFilter selects which mounted filesystems are reported.  If FsTypeInclude
+ * is set, only those fstypes are reported, else all but VirtualFsTypes and
+ * NetworkFsTypes.  Then
+ * fstypes in FsTypeExclude are not reported.  Mount paths are matched the same
+ * way against the MountInclude and MountExclude regular expressions.
+ */
+type FsFilter struct {
+	typeInclude  map[string]bool
+	typeExclude  map[string]bool
+	mountInclude []*regexp.Regexp
+	mountExclude []*regexp.Regexp
+}
+
+func NewFsFilter(config *Config) (*FsFilter, error) {
+	f := &FsFilter{
+		typeInclude: make(map[string]bool),
+		typeExclude: make(map[string]bool),
+	}
+	for _, fsType := range config.FsTypeInclude {
+		f.typeInclude[fsType] = true
+	}
+	for _, fsType := range config.FsTypeExclude {
+		f.typeExclude[fsType] = true
+	}
+	for _, pattern := range config.MountInclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.mountInclude = append(f.mountInclude, re)
+	}
+	for _, pattern := range config.MountExclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.mountExclude = append(f.mountExclude, re)
+	}
+	return f, nil
+}
+
+func (f *FsFilter) Match(mount Mount) bool {
+	if len(f.typeInclude) > 0 {
+		if !f.typeInclude[mount.FsType] {
+			return false
+		}
+	} else if VirtualFsTypes[mount.FsType] || NetworkFsTypes[mount.FsType] {
+		return false
+	}
+	if f.typeExclude[mount.FsType] {
+		return false
+	}
+	if len(f.mountInclude) > 0 {
+		included := false
+		for _, re := range f.mountInclude {
+			if re.MatchString(mount.Path) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, re := range f.mountExclude {
+		if re.MatchString(mount.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadMounts returns the filesystems mounted according to file, usually /proc/mounts.
+func ReadMounts(file string) ([]Mount, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ProcMounts(content)
+}
+
+func ProcMounts(content []byte) ([]Mount, error) {
+	/**
+	 * rootfs / rootfs rw 0 0
+	 * proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+	 * /dev/sda1 / ext4 rw,relatime,errors=remount-ro,data=ordered 0 0
+	 * /dev/mapper/vg-mysql /var/lib/mysql xfs rw,noatime,attr2,inode64,noquota 0 0
+	 *
+	 * Fields: device, mount point, fstype, options, dump, pass.  Spaces, tabs,
+	 * newlines and backslashes in the device and mount point are escaped as
+	 * octal, e.g. \040 for a space.
+	 *
+	 * A path can be mounted more than once, e.g. rootfs and /dev/sda1 on /.
+	 * The last mount is the one that's visible, so it replaces the earlier ones.
+	 */
+	mounts := []Mount{}
+	index := make(map[string]int) // path => mounts index
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		mount := Mount{
+			Device: unescapeMount(fields[0]),
+			Path:   unescapeMount(fields[1]),
+			FsType: fields[2],
+		}
+		if len(fields) > 3 {
+			mount.Options = fields[3]
+		}
+		if i, ok := index[mount.Path]; ok {
+			mounts[i] = mount
+			continue
+		}
+		index[mount.Path] = len(mounts)
+		mounts = append(mounts, mount)
+	}
+	return mounts, nil
+}
+
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				buf = append(buf, byte(c))
+				i += 3
+				continue
+			}
+		}
+		buf = append(buf, s[i])
+	}
+	return string(buf)
+}
+
+// MountPoint returns the mount that dir is on: the mount with the longest path
+// that is dir or a parent of dir.  It returns false if dir is not absolute or
+// there are no mounts.
+func MountPoint(mounts []Mount, dir string) (Mount, bool) {
+	var mount Mount
+	found := false
+	if !filepath.IsAbs(dir) {
+		return mount, false
+	}
+	dir = filepath.Clean(dir)
+	for _, m := range mounts {
+		if m.Path != "/" && dir != m.Path && !strings.HasPrefix(dir, m.Path+"/") {
+			continue
+		}
+		if !found || len(m.Path) > len(mount.Path) {
+			mount = m
+			found = true
+		}
+	}
+	return mount, found
+}
+
+// FsName returns the part of the metric name for a mount path: / is "root",
+// else the path without the leading slash and with slashes replaced by
+// dashes, e.g. /var/lib/mysql is "var-lib-mysql".  Different paths can have
+// the same name, e.g. /var/lib-mysql, so use FsNames for all mounts.
+func FsName(path string) string {
+	if path == "/" {
+		return "root"
+	}
+	name := strings.Replace(strings.Trim(path, "/"), "/", "-", -1)
+	return strings.Replace(name, " ", "_", -1)
+}
+
+// FsNames returns the FsName of every mount path, keyed on path.  Paths with
+// the same FsName are made unique by adding a checksum of the path, e.g.
+// "var-lib-mysql-1a2b3c4d".  The system and MySQL monitors both use all
+// mounts, not just the ones reported, so they name filesystems the same way.
+func FsNames(mounts []Mount) map[string]string {
+	paths := make(map[string][]string) // name => paths
+	for _, mount := range mounts {
+		name := FsName(mount.Path)
+		paths[name] = append(paths[name], mount.Path)
+	}
+	names := make(map[string]string, len(mounts))
+	for name, p := range paths {
+		for _, path := range p {
+			if len(p) > 1 {
+				names[path] = fmt.Sprintf("%s-%08x", name, crc32.ChecksumIEEE([]byte(path)))
+			} else {
+				names[path] = name
+			}
+		}
+	}
+	return names
+}
+
+// StatfsMetrics returns fs/<name>/ bytes and inodes total, used and free.
+// Free bytes are bytes available to unprivileged users, like df reports,
+// so used + free can be less than total because of reserved blocks.
+func StatfsMetrics(name string, stat *syscall.Statfs_t) []mm.Metric {
+	prefix := "fs/" + name + "/"
+	bsize := float64(stat.Bsize)
+	return []mm.Metric{
+		{Name: prefix + "bytes_total", Type: "gauge", Number: float64(stat.Blocks) * bsize},
+		{Name: prefix + "bytes_used", Type: "gauge", Number: float64(stat.Blocks-stat.Bfree) * bsize},
+		{Name: prefix + "bytes_free", Type: "gauge", Number: float64(stat.Bavail) * bsize},
+		{Name: prefix + "inodes_total", Type: "gauge", Number: float64(stat.Files)},
+		{Name: prefix + "inodes_used", Type: "gauge", Number: float64(stat.Files - stat.Ffree)},
+		{Name: prefix + "inodes_free", Type: "gauge", Number: float64(stat.Ffree)},
+	}
+}
+
+func (m *Monitor) FsMetrics(mounts []Mount) []mm.Metric {
+	m.logger.Debug("FsMetrics:call")
+	defer m.logger.Debug("FsMetrics:return")
+
+	m.status.Update(m.name, "Getting filesystem metrics")
+
+	names := FsNames(mounts)
+	metrics := []mm.Metric{}
+	for _, mount := range mounts {
+		if !m.fsFilter.Match(mount) {
+			continue
+		}
+		stat, err := m.statfs(mount.Path)
+		if err != nil {
+			m.logger.Debug("FsMetrics:statfs:", mount.Path, err)
+			continue
+		}
+		if stat.Blocks == 0 {
+			continue // not a real filesystem
+		}
+		metrics = append(metrics, StatfsMetrics(names[mount.Path], stat)...)
+	}
+	return metrics
+}
+
+type statfsResult struct {
+	stat syscall.Statfs_t
+	err  error
+}
+
+/**
+ * statfs calls syscall.Statfs but waits at most STATFS_TIMEOUT so a hung
+ * mount doesn't stall every system metric.  The call is left to finish in
+ * the background, and until it does the mount is skipped, else every collect
+ * would leave another blocked goroutine.
+ */
+func (m *Monitor) statfs(path string) (*syscall.Statfs_t, error) {
+	if resultChan, ok := m.statfsPending[path]; ok {
+		select {
+		case <-resultChan:
+			delete(m.statfsPending, path) // late result, discard it
+		default:
+			return nil, fmt.Errorf("still blocked from previous collect")
+		}
+	}
+	resultChan := make(chan statfsResult, 1)
+	go func() {
+		var stat syscall.Statfs_t
+		err := syscall.Statfs(path, &stat)
+		resultChan <- statfsResult{stat, err}
+	}()
+	select {
+	case r := <-resultChan:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return &r.stat, nil
+	case <-time.After(STATFS_TIMEOUT * time.Second):
+		m.statfsPending[path] = resultChan
+		m.logger.Warn(fmt.Sprintf("statfs %s: timeout after %ds, skipping it until it returns", path, STATFS_TIMEOUT))
+		return nil, fmt.Errorf("timeout after %ds", STATFS_TIMEOUT)
+	}
+}
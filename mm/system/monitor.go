@@ -18,6 +18,7 @@
 package system
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/mm"
@@ -43,9 +44,22 @@ type Monitor struct {
 	// --
 	prevCPUval map[string][]float64 // [cpu0] => [user, nice, ...]
 	prevCPUsum map[string]float64   // [cpu0] => user + nice + ...
+	fsFilter   *FsFilter
+	cgroup     *Cgroup
 	sync       *pct.SyncChan
 	status     *pct.Status
 	running    bool
+	configChan chan newConfig // from Reconfigure()
+	// statfs calls that timed out but haven't returned yet, by mount path
+	statfsPending map[string]chan statfsResult
+	// when tcp/state/ metrics were last collected, see Config.TCPStatesInterval
+	lastTCPStates int64
+}
+
+type newConfig struct {
+	config   *Config
+	fsFilter *FsFilter
+	done     chan bool
 }
 
 func NewMonitor(name string, config *Config, logger *pct.Logger) *Monitor {
@@ -58,6 +72,9 @@ func NewMonitor(name string, config *Config, logger *pct.Logger) *Monitor {
 		prevCPUsum: make(map[string]float64),
 		status:     pct.NewStatus([]string{name}),
 		sync:       pct.NewSyncChan(),
+		configChan: make(chan newConfig),
+		// --
+		statfsPending: make(map[string]chan statfsResult),
 	}
 	return m
 }
@@ -75,6 +92,12 @@ func (m *Monitor) Start(tickChan chan time.Time, collectionChan chan *mm.Collect
 		return pct.ServiceIsRunningError{m.name}
 	}
 
+	fsFilter, err := NewFsFilter(m.config)
+	if err != nil {
+		return err
+	}
+	m.fsFilter = fsFilter
+
 	m.tickChan = tickChan
 	m.collectionChan = collectionChan
 
@@ -122,6 +145,32 @@ func (m *Monitor) Config() interface{} {
 	return m.config
 }
 
+// Reconfigure applies a new config before the next collect.
+// @goroutine[0]
+func (m *Monitor) Reconfigure(data []byte) error {
+	m.logger.Debug("Reconfigure:call")
+	defer m.logger.Debug("Reconfigure:return")
+
+	if !m.running {
+		return fmt.Errorf("Not running")
+	}
+
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return err
+	}
+	fsFilter, err := NewFsFilter(config)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan bool)
+	m.configChan <- newConfig{config, fsFilter, done}
+	<-done
+	m.logger.Info("Reconfigured")
+	return nil
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Implementation
 /////////////////////////////////////////////////////////////////////////////
@@ -205,6 +254,75 @@ func (m *Monitor) run() {
 				}
 			}
 
+			content, err = ioutil.ReadFile("/proc/net/dev")
+			if err == nil {
+				if metrics, err := m.ProcNetDev(content); err != nil {
+					m.logger.Warn("system:run:ProcNetDev:", err)
+				} else {
+					c.Metrics = append(c.Metrics, metrics...)
+				}
+			}
+
+			content, err = ioutil.ReadFile("/proc/net/snmp")
+			if err == nil {
+				if metrics, err := m.ProcNetSnmp(content); err != nil {
+					m.logger.Warn("system:run:ProcNetSnmp:", err)
+				} else {
+					c.Metrics = append(c.Metrics, metrics...)
+				}
+			}
+
+			content, err = ioutil.ReadFile("/proc/net/netstat")
+			if err == nil {
+				if metrics, err := m.ProcNetNetstat(content); err != nil {
+					m.logger.Warn("system:run:ProcNetNetstat:", err)
+				} else {
+					c.Metrics = append(c.Metrics, metrics...)
+				}
+			}
+
+			// IPv4 and IPv6 sockets.  sockstat6 and tcp6 don't exist if IPv6 is disabled.
+			content, err = ioutil.ReadFile("/proc/net/sockstat")
+			if err == nil {
+				if sockstat6, err := ioutil.ReadFile("/proc/net/sockstat6"); err == nil {
+					content = append(content, sockstat6...)
+				}
+				if metrics, err := m.ProcNetSockstat(content); err != nil {
+					m.logger.Warn("system:run:ProcNetSockstat:", err)
+				} else {
+					c.Metrics = append(c.Metrics, metrics...)
+				}
+			}
+
+			// Per-state counts read every socket, so they're optional and
+			// collected on their own, longer interval.
+			if m.config.TCPStatesInterval > 0 && c.Ts-m.lastTCPStates >= int64(m.config.TCPStatesInterval) {
+				content, err = ioutil.ReadFile("/proc/net/tcp")
+				if err == nil {
+					if tcp6, err := ioutil.ReadFile("/proc/net/tcp6"); err == nil {
+						content = append(content, tcp6...)
+					}
+					if metrics, err := m.ProcNetTcp(content); err != nil {
+						m.logger.Warn("system:run:ProcNetTcp:", err)
+					} else {
+						c.Metrics = append(c.Metrics, metrics...)
+						m.lastTCPStates = c.Ts
+					}
+				}
+			}
+
+			if cgroup := m.getCgroup(); cgroup != nil {
+				m.status.Update(m.name, "Getting cgroup metrics")
+				c.Metrics = append(c.Metrics, cgroup.Metrics("")...)
+			}
+
+			mounts, err := ReadMounts("/proc/mounts")
+			if err == nil {
+				c.Metrics = append(c.Metrics, m.FsMetrics(mounts)...)
+			} else {
+				m.logger.Warn("system:run:ReadMounts:", err)
+			}
+
 			// Send the metrics to the aggregator.
 			if len(c.Metrics) > 0 {
 				select {
@@ -213,12 +331,18 @@ func (m *Monitor) run() {
 				case <-time.After(500 * time.Millisecond):
 					// lost collection
 					m.logger.Debug("Lost system metrics; timeout spooling after 500ms")
+					pct.Metrics.Inc("mm/lost_collections", 1)
 				}
 			} else {
 				m.logger.Debug("run:no metrics") // shouldn't happen
 			}
 
 			m.logger.Debug("run:collect:stop")
+		case nc := <-m.configChan:
+			m.logger.Debug("run:config")
+			m.config = nc.config
+			m.fsFilter = nc.fsFilter
+			close(nc.done)
 		case <-m.sync.StopChan:
 			m.logger.Debug("run:stop")
 			return
@@ -226,6 +350,29 @@ func (m *Monitor) run() {
 	}
 }
 
+/**
+ * getCgroup returns the agent's cgroup, or nil if there's none.  It's only
+ * used if the agent is in a container, presumably MySQL's: on a host, the
+ * agent's cgroup is just the agent's service.  mysqld's own cgroup is reported
+ * by the MySQL monitor which knows mysqld's PID.
+ */
+func (m *Monitor) getCgroup() *Cgroup {
+	if m.cgroup != nil {
+		return m.cgroup
+	}
+	if !InContainer("/") {
+		return nil
+	}
+	cgroup, err := DetectCgroup("/", 0)
+	if err != nil {
+		m.logger.Debug("getCgroup:", err)
+		return nil
+	}
+	m.logger.Info(fmt.Sprintf("cgroup v%d", cgroup.Version))
+	m.cgroup = cgroup
+	return cgroup
+}
+
 func (m *Monitor) ProcStat(content []byte) ([]mm.Metric, error) {
 	m.logger.Debug("ProcStat:call")
 	defer m.logger.Debug("ProcStat:return")
@@ -0,0 +1,271 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"fmt"
+	"github.com/percona/percona-agent/mm"
+	"strconv"
+	"strings"
+)
+
+// TCP connection states in /proc/net/tcp, indexed by the hex st value.
+// https://github.com/torvalds/linux/blob/master/include/net/tcp_states.h
+var TCPStates []string = []string{
+	"", // 0 is not a state
+	"established",
+	"syn_sent",
+	"syn_recv",
+	"fin_wait1",
+	"fin_wait2",
+	"time_wait",
+	"close",
+	"close_wait",
+	"last_ack",
+	"listen",
+	"closing",
+}
+
+// /proc/net/snmp and /proc/net/netstat metrics we want, by section.
+// All are counters except tcp/CurrEstab.
+var snmpMetrics = map[string]map[string]string{
+	"Tcp": {
+		"ActiveOpens":  "counter",
+		"PassiveOpens": "counter",
+		"AttemptFails": "counter",
+		"EstabResets":  "counter",
+		"CurrEstab":    "gauge",
+		"InSegs":       "counter",
+		"OutSegs":      "counter",
+		"RetransSegs":  "counter",
+		"InErrs":       "counter",
+		"OutRsts":      "counter",
+	},
+	"Udp": {
+		"InDatagrams":  "counter",
+		"NoPorts":      "counter",
+		"InErrors":     "counter",
+		"OutDatagrams": "counter",
+		"RcvbufErrors": "counter",
+		"SndbufErrors": "counter",
+	},
+}
+
+var netstatMetrics = map[string]map[string]string{
+	"TcpExt": {
+		"SyncookiesSent":      "counter",
+		"ListenOverflows":     "counter",
+		"ListenDrops":         "counter",
+		"TCPLostRetransmit":   "counter",
+		"TCPFastRetrans":      "counter",
+		"TCPSlowStartRetrans": "counter",
+		"TCPTimeouts":         "counter",
+		"TCPAbortOnData":      "counter",
+		"TCPAbortOnClose":     "counter",
+		"TCPAbortOnMemory":    "counter",
+		"TCPAbortOnTimeout":   "counter",
+		"TCPBacklogDrop":      "counter",
+		"TCPSynRetrans":       "counter",
+	},
+}
+
+// Metric name prefix for each section.
+var netSections = map[string]string{
+	"Tcp":    "tcp/",
+	"Udp":    "udp/",
+	"TcpExt": "tcp-ext/",
+}
+
+func (m *Monitor) ProcNetDev(content []byte) ([]mm.Metric, error) {
+	m.logger.Debug("ProcNetDev:call")
+	defer m.logger.Debug("ProcNetDev:return")
+
+	m.status.Update(m.name, "Getting /proc/net/dev metrics")
+
+	/**
+	 * Inter-|   Receive                                                |  Transmit
+	 *  face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+	 *     lo: 20877333    2365    0    0    0     0          0         0 20877333    2365    0    0    0     0       0          0
+	 *   eth0:12847736271 38211476    3  107    0     0          0         0 98012347812 45519870    1    0    0     0       0          0
+	 *
+	 * Big numbers run into the interface name, so split on the colon first.
+	 * Then fields 0-7 are receive stats and 8-15 are transmit stats.
+	 */
+	metrics := []mm.Metric{}
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue // header
+		}
+		iface := strings.TrimSpace(line[0:colon])
+		fields := strings.Fields(line[colon+1:])
+		if len(fields) < 12 { // at least 12 fields expected
+			continue
+		}
+		prefix := "net/" + iface + "/"
+		metrics = append(metrics, mm.Metric{Name: prefix + "rx_bytes", Type: "counter", Number: StrToFloat(fields[0])})
+		metrics = append(metrics, mm.Metric{Name: prefix + "rx_packets", Type: "counter", Number: StrToFloat(fields[1])})
+		metrics = append(metrics, mm.Metric{Name: prefix + "rx_errors", Type: "counter", Number: StrToFloat(fields[2])})
+		metrics = append(metrics, mm.Metric{Name: prefix + "rx_drops", Type: "counter", Number: StrToFloat(fields[3])})
+		metrics = append(metrics, mm.Metric{Name: prefix + "tx_bytes", Type: "counter", Number: StrToFloat(fields[8])})
+		metrics = append(metrics, mm.Metric{Name: prefix + "tx_packets", Type: "counter", Number: StrToFloat(fields[9])})
+		metrics = append(metrics, mm.Metric{Name: prefix + "tx_errors", Type: "counter", Number: StrToFloat(fields[10])})
+		metrics = append(metrics, mm.Metric{Name: prefix + "tx_drops", Type: "counter", Number: StrToFloat(fields[11])})
+	}
+	return metrics, nil
+}
+
+func (m *Monitor) ProcNetSnmp(content []byte) ([]mm.Metric, error) {
+	m.logger.Debug("ProcNetSnmp:call")
+	defer m.logger.Debug("ProcNetSnmp:return")
+
+	m.status.Update(m.name, "Getting /proc/net/snmp metrics")
+
+	return procNetPairs(content, snmpMetrics), nil
+}
+
+func (m *Monitor) ProcNetNetstat(content []byte) ([]mm.Metric, error) {
+	m.logger.Debug("ProcNetNetstat:call")
+	defer m.logger.Debug("ProcNetNetstat:return")
+
+	m.status.Update(m.name, "Getting /proc/net/netstat metrics")
+
+	return procNetPairs(content, netstatMetrics), nil
+}
+
+func procNetPairs(content []byte, want map[string]map[string]string) []mm.Metric {
+	/**
+	 * Each section is two lines: names, then values:
+	 *   Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ActiveOpens PassiveOpens ...
+	 *   Tcp: 1 200 120000 -1 253119 1418624 ...
+	 *
+	 * The names vary by kernel version, so values are matched to names by
+	 * position in the line, not by a fixed offset.
+	 */
+	metrics := []mm.Metric{}
+	var names []string
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 { // at least two fields expected
+			names = nil
+			continue
+		}
+		if names == nil || names[0] != fields[0] {
+			names = fields
+			continue
+		}
+		// Values line for the previous names line.
+		values := fields
+		header := names
+		names = nil
+		section := strings.TrimRight(header[0], ":")
+		stats, ok := want[section]
+		if !ok {
+			continue
+		}
+		for i := 1; i < len(header) && i < len(values); i++ {
+			metricType, ok := stats[header[i]]
+			if !ok {
+				continue
+			}
+			metrics = append(metrics, mm.Metric{
+				Name:   netSections[section] + header[i],
+				Type:   metricType,
+				Number: StrToFloat(values[i]),
+			})
+		}
+	}
+	return metrics
+}
+
+func (m *Monitor) ProcNetSockstat(content []byte) ([]mm.Metric, error) {
+	m.logger.Debug("ProcNetSockstat:call")
+	defer m.logger.Debug("ProcNetSockstat:return")
+
+	m.status.Update(m.name, "Getting /proc/net/sockstat metrics")
+
+	/**
+	 *   sockets: used 290
+	 *   TCP: inuse 27 orphan 1 tw 3 alloc 29 mem 4
+	 *   UDP: inuse 4 mem 2
+	 *   TCP6: inuse 5
+	 *
+	 * content can be /proc/net/sockstat and /proc/net/sockstat6 together:
+	 * TCP6 inuse is added to TCP inuse.  These are kernel counters, so
+	 * unlike /proc/net/tcp the cost doesn't grow with the number of sockets.
+	 */
+	tcp := map[string]float64{}
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || (fields[0] != "TCP:" && fields[0] != "TCP6:") {
+			continue
+		}
+		for i := 1; i+1 < len(fields); i += 2 {
+			tcp[fields[i]] += StrToFloat(fields[i+1])
+		}
+	}
+	if len(tcp) == 0 {
+		return nil, fmt.Errorf("no TCP line")
+	}
+	metrics := []mm.Metric{
+		{Name: "tcp/sockets/inuse", Type: "gauge", Number: tcp["inuse"]},
+		{Name: "tcp/sockets/orphan", Type: "gauge", Number: tcp["orphan"]},
+		{Name: "tcp/sockets/time_wait", Type: "gauge", Number: tcp["tw"]},
+		{Name: "tcp/sockets/alloc", Type: "gauge", Number: tcp["alloc"]},
+	}
+	return metrics, nil
+}
+
+func (m *Monitor) ProcNetTcp(content []byte) ([]mm.Metric, error) {
+	m.logger.Debug("ProcNetTcp:call")
+	defer m.logger.Debug("ProcNetTcp:return")
+
+	m.status.Update(m.name, "Getting /proc/net/tcp metrics")
+
+	/**
+	 *   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+	 *    0: 00000000:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000    27        0 14421 1 ...
+	 *    1: 0A00000F:0CEA 0A000014:C5A2 01 00000000:00000000 02:0009E9A1 00000000    27        0 880215 2 ...
+	 *
+	 * One line per socket; field 3, st, is the hex TCP state (see TCPStates).
+	 * content can be /proc/net/tcp and /proc/net/tcp6 together: the header
+	 * lines are skipped.  Every state is reported, even if zero, so the
+	 * metrics don't come and go.
+	 */
+	counts := make([]float64, len(TCPStates))
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] == "sl" {
+			continue
+		}
+		st, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil || st == 0 || int(st) >= len(TCPStates) {
+			continue
+		}
+		counts[st]++
+	}
+	metrics := []mm.Metric{}
+	for st := 1; st < len(TCPStates); st++ {
+		metrics = append(metrics, mm.Metric{Name: "tcp/state/" + TCPStates[st], Type: "gauge", Number: counts[st]})
+	}
+	return metrics, nil
+}
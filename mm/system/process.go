@@ -0,0 +1,191 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"errors"
+	"github.com/percona/percona-agent/mm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Process metrics are per-process stats from /proc/<pid>, e.g. how much CPU
+ * and memory mysqld uses.  Unlike the other metrics in this package they are
+ * not host-wide, so the caller (e.g. the MySQL monitor) decides which process
+ * and which metric name prefix (e.g. mysql/process/) to use.
+ */
+
+// USER_HZ: "1/100ths of a second on most architectures" (see ProcStat).
+const CLOCK_TICKS = 100
+
+// ProcessMetrics returns metrics for process pid from procDir (usually /proc).
+// Only /proc/<pid>/stat is required; other files are usually readable only by
+// the process owner or root, so they are skipped if they can't be read.
+func ProcessMetrics(procDir string, pid int, prefix string) ([]mm.Metric, error) {
+	dir := filepath.Join(procDir, strconv.Itoa(pid))
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := ProcPidStat(content, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "status")); err == nil {
+		if m, err := ProcPidStatus(content, prefix); err == nil {
+			metrics = append(metrics, m...)
+		}
+	}
+
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "io")); err == nil {
+		if m, err := ProcPidIo(content, prefix); err == nil {
+			metrics = append(metrics, m...)
+		}
+	}
+
+	if fds, err := ioutil.ReadDir(filepath.Join(dir, "fd")); err == nil {
+		metrics = append(metrics, mm.Metric{Name: prefix + "open_fds", Type: "gauge", Number: float64(len(fds))})
+	}
+
+	return metrics, nil
+}
+
+// ProcessName returns the command name of process pid, e.g. "mysqld", or an
+// error if the process does not exist.
+func ProcessName(procDir string, pid int) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// ReadPidFile returns the PID in file, e.g. mysqld's pid_file.
+func ReadPidFile(file string) (int, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, errors.New("Invalid PID in " + file + ": " + err.Error())
+	}
+	if pid <= 0 {
+		return 0, errors.New("Invalid PID in " + file + ": " + strconv.Itoa(pid))
+	}
+	return pid, nil
+}
+
+func ProcPidStat(content []byte, prefix string) ([]mm.Metric, error) {
+	/**
+	 * One line:
+	 *   2345 (mysqld) S 1 2344 2344 0 -1 4202752 52870 0 31 0 1528 724 0 0 20 0 22 0 ...
+	 *
+	 * Field 2, the command name, is in parentheses and can contain spaces, so
+	 * split after the last ')'.  Then fields are, counting from 1 like proc(5):
+	 *   10 minflt, 12 majflt, 14 utime, 15 stime (clock ticks), 20 num_threads,
+	 *   22 starttime, 23 vsize (bytes), 24 rss (pages)
+	 * http://man7.org/linux/man-pages/man5/proc.5.html
+	 */
+	line := string(content)
+	end := strings.LastIndex(line, ")")
+	if end < 0 {
+		return nil, errors.New("Invalid /proc/<pid>/stat: no ')'")
+	}
+	// fields[0] is field 3 (state)
+	fields := strings.Fields(line[end+1:])
+	if len(fields) < 22 {
+		return nil, errors.New("Invalid /proc/<pid>/stat: " + strconv.Itoa(len(fields)+2) + " fields")
+	}
+	field := func(n int) float64 {
+		return StrToFloat(fields[n-3])
+	}
+	metrics := []mm.Metric{
+		{Name: prefix + "cpu_user", Type: "counter", Number: field(14) / CLOCK_TICKS},
+		{Name: prefix + "cpu_system", Type: "counter", Number: field(15) / CLOCK_TICKS},
+		{Name: prefix + "minor_faults", Type: "counter", Number: field(10)},
+		{Name: prefix + "major_faults", Type: "counter", Number: field(12)},
+		{Name: prefix + "threads", Type: "gauge", Number: field(20)},
+		{Name: prefix + "vsz", Type: "gauge", Number: field(23)},
+		{Name: prefix + "rss", Type: "gauge", Number: field(24) * float64(os.Getpagesize())},
+	}
+	return metrics, nil
+}
+
+func ProcPidStatus(content []byte, prefix string) ([]mm.Metric, error) {
+	/**
+	 * Name:	mysqld
+	 * ...
+	 * VmSwap:	       0 kB
+	 * ...
+	 * voluntary_ctxt_switches:	15387
+	 * nonvoluntary_ctxt_switches:	1023
+	 */
+	metrics := []mm.Metric{}
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.TrimRight(fields[0], ":") {
+		case "VmSwap":
+			// kB like /proc/meminfo, but report bytes like vsz and rss.
+			metrics = append(metrics, mm.Metric{Name: prefix + "swap", Type: "gauge", Number: StrToFloat(fields[1]) * 1024})
+		case "voluntary_ctxt_switches":
+			metrics = append(metrics, mm.Metric{Name: prefix + "voluntary_ctxt_switches", Type: "counter", Number: StrToFloat(fields[1])})
+		case "nonvoluntary_ctxt_switches":
+			metrics = append(metrics, mm.Metric{Name: prefix + "nonvoluntary_ctxt_switches", Type: "counter", Number: StrToFloat(fields[1])})
+		}
+	}
+	return metrics, nil
+}
+
+func ProcPidIo(content []byte, prefix string) ([]mm.Metric, error) {
+	/**
+	 * rchar: 323934931
+	 * wchar: 323929600
+	 * syscr: 632687
+	 * syscw: 632675
+	 * read_bytes: 0
+	 * write_bytes: 323932160
+	 * cancelled_write_bytes: 0
+	 *
+	 * read_bytes and write_bytes are actual storage I/O; rchar and wchar
+	 * include reads and writes satisfied by the page cache.
+	 */
+	metrics := []mm.Metric{}
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch s := strings.TrimRight(fields[0], ":"); s {
+		case "rchar", "wchar", "syscr", "syscw", "read_bytes", "write_bytes", "cancelled_write_bytes":
+			metrics = append(metrics, mm.Metric{Name: prefix + "io_" + s, Type: "counter", Number: StrToFloat(fields[1])})
+		}
+	}
+	return metrics, nil
+}
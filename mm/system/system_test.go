@@ -18,6 +18,8 @@
 package system_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"github.com/percona/cloud-protocol/proto"
 	"github.com/percona/percona-agent/mm"
 	"github.com/percona/percona-agent/mm/system"
@@ -25,7 +27,9 @@ import (
 	"github.com/percona/percona-agent/test"
 	"io/ioutil"
 	. "launchpad.net/gocheck"
+	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -445,6 +449,591 @@ func (s *ProcDiskstatsTestSuite) TestProcDiskstats001(t *C) {
 	}
 }
 
+/////////////////////////////////////////////////////////////////////////////
+// Network
+/////////////////////////////////////////////////////////////////////////////
+
+type ProcNetTestSuite struct {
+	logChan chan *proto.LogEntry
+	logger  *pct.Logger
+}
+
+var _ = Suite(&ProcNetTestSuite{})
+
+func (s *ProcNetTestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 10)
+	s.logger = pct.NewLogger(s.logChan, "system-monitor-test")
+}
+
+// --------------------------------------------------------------------------
+
+func (s *ProcNetTestSuite) TestProcNetDev001(t *C) {
+	m := system.NewMonitor("", &system.Config{}, s.logger)
+	content, err := ioutil.ReadFile(sample + "/proc/net-dev001.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.ProcNetDev(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []mm.Metric{
+		{Name: "net/lo/rx_bytes", Type: "counter", Number: 1946520385},
+		{Name: "net/lo/rx_packets", Type: "counter", Number: 6270314},
+		{Name: "net/lo/rx_errors", Type: "counter", Number: 0},
+		{Name: "net/lo/rx_drops", Type: "counter", Number: 0},
+		{Name: "net/lo/tx_bytes", Type: "counter", Number: 1946520385},
+		{Name: "net/lo/tx_packets", Type: "counter", Number: 6270314},
+		{Name: "net/lo/tx_errors", Type: "counter", Number: 0},
+		{Name: "net/lo/tx_drops", Type: "counter", Number: 0},
+		{Name: "net/eth0/rx_bytes", Type: "counter", Number: 83746201},
+		{Name: "net/eth0/rx_packets", Type: "counter", Number: 96874},
+		{Name: "net/eth0/rx_errors", Type: "counter", Number: 0},
+		{Name: "net/eth0/rx_drops", Type: "counter", Number: 12},
+		{Name: "net/eth0/tx_bytes", Type: "counter", Number: 15233947},
+		{Name: "net/eth0/tx_packets", Type: "counter", Number: 75012},
+		{Name: "net/eth0/tx_errors", Type: "counter", Number: 0},
+		{Name: "net/eth0/tx_drops", Type: "counter", Number: 0},
+		// eth1 rx_bytes runs into "eth1:"
+		{Name: "net/eth1/rx_bytes", Type: "counter", Number: 12847736271},
+		{Name: "net/eth1/rx_packets", Type: "counter", Number: 38211476},
+		{Name: "net/eth1/rx_errors", Type: "counter", Number: 3},
+		{Name: "net/eth1/rx_drops", Type: "counter", Number: 107},
+		{Name: "net/eth1/tx_bytes", Type: "counter", Number: 98012347812},
+		{Name: "net/eth1/tx_packets", Type: "counter", Number: 45519870},
+		{Name: "net/eth1/tx_errors", Type: "counter", Number: 1},
+		{Name: "net/eth1/tx_drops", Type: "counter", Number: 0},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
+func (s *ProcNetTestSuite) TestProcNetSnmp001(t *C) {
+	m := system.NewMonitor("", &system.Config{}, s.logger)
+	content, err := ioutil.ReadFile(sample + "/proc/net-snmp001.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.ProcNetSnmp(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Remember: the order of this array must match order in which each
+	// stat appears in the input file:
+	expect := []mm.Metric{
+		{Name: "tcp/ActiveOpens", Type: "counter", Number: 253119},
+		{Name: "tcp/PassiveOpens", Type: "counter", Number: 1418624},
+		{Name: "tcp/AttemptFails", Type: "counter", Number: 2093},
+		{Name: "tcp/EstabResets", Type: "counter", Number: 31860},
+		{Name: "tcp/CurrEstab", Type: "gauge", Number: 46},
+		{Name: "tcp/InSegs", Type: "counter", Number: 44763120},
+		{Name: "tcp/OutSegs", Type: "counter", Number: 52310553},
+		{Name: "tcp/RetransSegs", Type: "counter", Number: 14022},
+		{Name: "tcp/InErrs", Type: "counter", Number: 5},
+		{Name: "tcp/OutRsts", Type: "counter", Number: 37210},
+		{Name: "udp/InDatagrams", Type: "counter", Number: 40367},
+		{Name: "udp/NoPorts", Type: "counter", Number: 1311},
+		{Name: "udp/InErrors", Type: "counter", Number: 0},
+		{Name: "udp/OutDatagrams", Type: "counter", Number: 41790},
+		{Name: "udp/RcvbufErrors", Type: "counter", Number: 0},
+		{Name: "udp/SndbufErrors", Type: "counter", Number: 0},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
+func (s *ProcNetTestSuite) TestProcNetNetstat001(t *C) {
+	m := system.NewMonitor("", &system.Config{}, s.logger)
+	content, err := ioutil.ReadFile(sample + "/proc/net-netstat001.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.ProcNetNetstat(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []mm.Metric{
+		{Name: "tcp-ext/SyncookiesSent", Type: "counter", Number: 12},
+		{Name: "tcp-ext/ListenOverflows", Type: "counter", Number: 87},
+		{Name: "tcp-ext/ListenDrops", Type: "counter", Number: 87},
+		{Name: "tcp-ext/TCPLostRetransmit", Type: "counter", Number: 644},
+		{Name: "tcp-ext/TCPFastRetrans", Type: "counter", Number: 5211},
+		{Name: "tcp-ext/TCPSlowStartRetrans", Type: "counter", Number: 1822},
+		{Name: "tcp-ext/TCPTimeouts", Type: "counter", Number: 4102},
+		{Name: "tcp-ext/TCPAbortOnData", Type: "counter", Number: 24011},
+		{Name: "tcp-ext/TCPAbortOnClose", Type: "counter", Number: 1902},
+		{Name: "tcp-ext/TCPAbortOnMemory", Type: "counter", Number: 0},
+		{Name: "tcp-ext/TCPAbortOnTimeout", Type: "counter", Number: 341},
+		{Name: "tcp-ext/TCPBacklogDrop", Type: "counter", Number: 3},
+		{Name: "tcp-ext/TCPSynRetrans", Type: "counter", Number: 612},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
+func (s *ProcNetTestSuite) TestProcNetSockstat001(t *C) {
+	m := system.NewMonitor("", &system.Config{}, s.logger)
+	// /proc/net/sockstat and /proc/net/sockstat6 together, like the monitor reads them.
+	content, err := ioutil.ReadFile(sample + "/proc/net-sockstat001.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.ProcNetSockstat(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []mm.Metric{
+		{Name: "tcp/sockets/inuse", Type: "gauge", Number: 32},
+		{Name: "tcp/sockets/orphan", Type: "gauge", Number: 1},
+		{Name: "tcp/sockets/time_wait", Type: "gauge", Number: 3},
+		{Name: "tcp/sockets/alloc", Type: "gauge", Number: 29},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
+func (s *ProcNetTestSuite) TestProcNetTcp001(t *C) {
+	m := system.NewMonitor("", &system.Config{}, s.logger)
+	// /proc/net/tcp and /proc/net/tcp6 together, like the monitor reads them.
+	content, err := ioutil.ReadFile(sample + "/proc/net-tcp001.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.ProcNetTcp(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []mm.Metric{
+		{Name: "tcp/state/established", Type: "gauge", Number: 4},
+		{Name: "tcp/state/syn_sent", Type: "gauge", Number: 0},
+		{Name: "tcp/state/syn_recv", Type: "gauge", Number: 0},
+		{Name: "tcp/state/fin_wait1", Type: "gauge", Number: 0},
+		{Name: "tcp/state/fin_wait2", Type: "gauge", Number: 0},
+		{Name: "tcp/state/time_wait", Type: "gauge", Number: 2},
+		{Name: "tcp/state/close", Type: "gauge", Number: 0},
+		{Name: "tcp/state/close_wait", Type: "gauge", Number: 1},
+		{Name: "tcp/state/last_ack", Type: "gauge", Number: 0},
+		{Name: "tcp/state/listen", Type: "gauge", Number: 3},
+		{Name: "tcp/state/closing", Type: "gauge", Number: 0},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// cgroup
+/////////////////////////////////////////////////////////////////////////////
+
+type CgroupTestSuite struct {
+}
+
+var _ = Suite(&CgroupTestSuite{})
+
+// --------------------------------------------------------------------------
+
+func (s *CgroupTestSuite) TestCgroupV1(t *C) {
+	/**
+	 * The fake root has cpu,cpuacct and blkio hierarchies with the container's
+	 * cgroup path, and a memory hierarchy without it, like a container without
+	 * a cgroup namespace where the container's cgroup is mounted at the root.
+	 */
+	cgroup, err := system.DetectCgroup(sample+"/cgroup/v1", 0)
+	t.Assert(err, IsNil)
+	t.Check(cgroup.Version, Equals, 1)
+
+	got := cgroup.Metrics("")
+	expect := []mm.Metric{
+		{Name: "cgroup/cpu_limit", Type: "gauge", Number: 1.5},
+		{Name: "cgroup/cpu_nr_periods", Type: "counter", Number: 52310},
+		{Name: "cgroup/cpu_nr_throttled", Type: "counter", Number: 1204},
+		{Name: "cgroup/cpu_throttled_time", Type: "counter", Number: 83021456789 / 1e9},
+		{Name: "cgroup/cpu_usage", Type: "counter", Number: 912345678901 / 1e9},
+		{Name: "cgroup/cpu_user", Type: "counter", Number: 712.3},
+		{Name: "cgroup/cpu_system", Type: "counter", Number: 184.02},
+		{Name: "cgroup/memory_limit", Type: "gauge", Number: 4294967296},
+		{Name: "cgroup/memory_usage", Type: "gauge", Number: 3865470566},
+		{Name: "cgroup/memory_rss", Type: "gauge", Number: 2684354560},
+		{Name: "cgroup/memory_cache", Type: "gauge", Number: 1073741824},
+		{Name: "cgroup/memory_swap", Type: "gauge", Number: 0},
+		{Name: "cgroup/memory_limit_hits", Type: "counter", Number: 37},
+		{Name: "cgroup/memory_oom_kills", Type: "counter", Number: 2},
+		{Name: "cgroup/blkio_read_bytes", Type: "counter", Number: 1048576 + 4096},
+		{Name: "cgroup/blkio_write_bytes", Type: "counter", Number: 52428800},
+		{Name: "cgroup/blkio_reads", Type: "counter", Number: 256 + 1},
+		{Name: "cgroup/blkio_writes", Type: "counter", Number: 12800},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
+func (s *CgroupTestSuite) TestCgroupV2(t *C) {
+	// The cgroup of mysqld (PID 1234), not the agent.
+	cgroup, err := system.DetectCgroup(sample+"/cgroup/v2", 1234)
+	t.Assert(err, IsNil)
+	t.Check(cgroup.Version, Equals, 2)
+
+	// memory.max is "max", so no cgroup/memory_limit.
+	got := cgroup.Metrics("")
+	expect := []mm.Metric{
+		{Name: "cgroup/cpu_limit", Type: "gauge", Number: 2},
+		{Name: "cgroup/cpu_usage", Type: "counter", Number: 912345678 / 1e6},
+		{Name: "cgroup/cpu_user", Type: "counter", Number: 712.3},
+		{Name: "cgroup/cpu_system", Type: "counter", Number: 200045678 / 1e6},
+		{Name: "cgroup/cpu_nr_periods", Type: "counter", Number: 52310},
+		{Name: "cgroup/cpu_nr_throttled", Type: "counter", Number: 1204},
+		{Name: "cgroup/cpu_throttled_time", Type: "counter", Number: 83021456 / 1e6},
+		{Name: "cgroup/memory_usage", Type: "gauge", Number: 3865470566},
+		{Name: "cgroup/memory_rss", Type: "gauge", Number: 2684354560},
+		{Name: "cgroup/memory_cache", Type: "gauge", Number: 1073741824},
+		{Name: "cgroup/memory_swap", Type: "gauge", Number: 1048576},
+		{Name: "cgroup/memory_limit_hits", Type: "counter", Number: 37},
+		{Name: "cgroup/memory_oom_events", Type: "counter", Number: 3},
+		{Name: "cgroup/memory_oom_kills", Type: "counter", Number: 2},
+		{Name: "cgroup/blkio_read_bytes", Type: "counter", Number: 1048576 + 4096},
+		{Name: "cgroup/blkio_write_bytes", Type: "counter", Number: 52428800},
+		{Name: "cgroup/blkio_reads", Type: "counter", Number: 256 + 1},
+		{Name: "cgroup/blkio_writes", Type: "counter", Number: 12800},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
+func (s *CgroupTestSuite) TestNoCgroup(t *C) {
+	_, err := system.DetectCgroup(sample+"/cgroup/does-not-exist", 0)
+	t.Check(err, NotNil)
+
+	// No such process.
+	_, err = system.DetectCgroup(sample+"/cgroup/v2", 999)
+	t.Check(err, NotNil)
+
+	// mysqld (PID 5678) is in another container whose cgroup isn't mounted
+	// here: don't fall back to the root cgroup.
+	_, err = system.DetectCgroup(sample+"/cgroup/v2", 5678)
+	t.Check(err, NotNil)
+}
+
+func (s *CgroupTestSuite) TestInContainer(t *C) {
+	t.Check(system.InContainer(sample+"/cgroup/v1"), Equals, true)
+	t.Check(system.InContainer(sample+"/cgroup/v2"), Equals, false)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Process (/proc/<pid>)
+/////////////////////////////////////////////////////////////////////////////
+
+type ProcessTestSuite struct {
+	procDir string
+}
+
+var _ = Suite(&ProcessTestSuite{})
+
+func (s *ProcessTestSuite) SetUpSuite(t *C) {
+	// Fake /proc with only /proc/2345/ for mysqld.
+	dir, err := ioutil.TempDir("/tmp", "percona-agent-test-proc-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.procDir = dir
+	pidDir := filepath.Join(dir, "2345")
+	if err := os.MkdirAll(filepath.Join(pidDir, "fd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"stat":   "pid-stat001.txt",
+		"status": "pid-status001.txt",
+		"io":     "pid-io001.txt",
+	}
+	for name, file := range files {
+		content, err := ioutil.ReadFile(sample + "/proc/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(pidDir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(pidDir, "comm"), []byte("mysqld\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range []string{"0", "1", "2"} {
+		if err := ioutil.WriteFile(filepath.Join(pidDir, "fd", fd), []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (s *ProcessTestSuite) TearDownSuite(t *C) {
+	if err := os.RemoveAll(s.procDir); err != nil {
+		t.Error(err)
+	}
+}
+
+// --------------------------------------------------------------------------
+
+func (s *ProcessTestSuite) TestProcPidStat001(t *C) {
+	content, err := ioutil.ReadFile(sample + "/proc/pid-stat001.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := system.ProcPidStat(content, "mysql/process/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []mm.Metric{
+		{Name: "mysql/process/cpu_user", Type: "counter", Number: 15.28},
+		{Name: "mysql/process/cpu_system", Type: "counter", Number: 7.24},
+		{Name: "mysql/process/minor_faults", Type: "counter", Number: 52870},
+		{Name: "mysql/process/major_faults", Type: "counter", Number: 31},
+		{Name: "mysql/process/threads", Type: "gauge", Number: 22},
+		{Name: "mysql/process/vsz", Type: "gauge", Number: 1195712512},
+		{Name: "mysql/process/rss", Type: "gauge", Number: float64(38752 * os.Getpagesize())},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	// Command names can have spaces and parentheses.
+	content = bytes.Replace(content, []byte("(mysqld)"), []byte("(my (sql) d)"), 1)
+	got, err = system.ProcPidStat(content, "mysql/process/")
+	t.Check(err, IsNil)
+	t.Check(got, DeepEquals, expect)
+
+	_, err = system.ProcPidStat([]byte("2345 (mysqld) S 1"), "")
+	t.Check(err, NotNil)
+}
+
+func (s *ProcessTestSuite) TestProcPidStatus001(t *C) {
+	content, err := ioutil.ReadFile(sample + "/proc/pid-status001.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := system.ProcPidStatus(content, "mysql/process/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []mm.Metric{
+		{Name: "mysql/process/swap", Type: "gauge", Number: 512 * 1024},
+		{Name: "mysql/process/voluntary_ctxt_switches", Type: "counter", Number: 15387},
+		{Name: "mysql/process/nonvoluntary_ctxt_switches", Type: "counter", Number: 1023},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
+func (s *ProcessTestSuite) TestProcPidIo001(t *C) {
+	content, err := ioutil.ReadFile(sample + "/proc/pid-io001.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := system.ProcPidIo(content, "mysql/process/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []mm.Metric{
+		{Name: "mysql/process/io_rchar", Type: "counter", Number: 323934931},
+		{Name: "mysql/process/io_wchar", Type: "counter", Number: 323929600},
+		{Name: "mysql/process/io_syscr", Type: "counter", Number: 632687},
+		{Name: "mysql/process/io_syscw", Type: "counter", Number: 632675},
+		{Name: "mysql/process/io_read_bytes", Type: "counter", Number: 4096},
+		{Name: "mysql/process/io_write_bytes", Type: "counter", Number: 323932160},
+		{Name: "mysql/process/io_cancelled_write_bytes", Type: "counter", Number: 0},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
+func (s *ProcessTestSuite) TestProcessMetrics(t *C) {
+	name, err := system.ProcessName(s.procDir, 2345)
+	t.Assert(err, IsNil)
+	t.Check(name, Equals, "mysqld")
+
+	_, err = system.ProcessName(s.procDir, 1)
+	t.Check(err, NotNil)
+
+	got, err := system.ProcessMetrics(s.procDir, 2345, "mysql/process/")
+	t.Assert(err, IsNil)
+	// 7 stat + 3 status + 7 io + open_fds
+	t.Assert(got, HasLen, 18)
+	t.Check(got[len(got)-1], DeepEquals, mm.Metric{Name: "mysql/process/open_fds", Type: "gauge", Number: 3})
+
+	// No such process.
+	_, err = system.ProcessMetrics(s.procDir, 1, "mysql/process/")
+	t.Check(err, NotNil)
+}
+
+func (s *ProcessTestSuite) TestReadPidFile(t *C) {
+	file := filepath.Join(s.procDir, "mysqld.pid")
+
+	err := ioutil.WriteFile(file, []byte("2345\n"), 0644)
+	t.Assert(err, IsNil)
+	pid, err := system.ReadPidFile(file)
+	t.Check(err, IsNil)
+	t.Check(pid, Equals, 2345)
+
+	err = ioutil.WriteFile(file, []byte("\n"), 0644)
+	t.Assert(err, IsNil)
+	_, err = system.ReadPidFile(file)
+	t.Check(err, NotNil)
+
+	_, err = system.ReadPidFile(filepath.Join(s.procDir, "does-not-exist.pid"))
+	t.Check(err, NotNil)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Filesystems
+/////////////////////////////////////////////////////////////////////////////
+
+type FilesystemTestSuite struct {
+	mounts []system.Mount
+}
+
+var _ = Suite(&FilesystemTestSuite{})
+
+func (s *FilesystemTestSuite) SetUpSuite(t *C) {
+	mounts, err := system.ReadMounts(sample + "/proc/mounts001.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mounts = mounts
+}
+
+// --------------------------------------------------------------------------
+
+func (s *FilesystemTestSuite) TestProcMounts001(t *C) {
+	// rootfs on / is replaced by the later ext4 mount on /.
+	expect := []system.Mount{
+		{Device: "/dev/disk/by-uuid/2a0ad2e8-6c3f-4a4b-8e1d-0c5b0a3c1e2f", Path: "/", FsType: "ext4", Options: "rw,relatime,errors=remount-ro,data=ordered"},
+		{Device: "sysfs", Path: "/sys", FsType: "sysfs", Options: "rw,nosuid,nodev,noexec,relatime"},
+		{Device: "proc", Path: "/proc", FsType: "proc", Options: "rw,nosuid,nodev,noexec,relatime"},
+		{Device: "udev", Path: "/dev", FsType: "devtmpfs", Options: "rw,relatime,size=4010512k,nr_inodes=1002628,mode=755"},
+		{Device: "devpts", Path: "/dev/pts", FsType: "devpts", Options: "rw,nosuid,noexec,relatime,gid=5,mode=620,ptmxmode=000"},
+		{Device: "tmpfs", Path: "/run", FsType: "tmpfs", Options: "rw,nosuid,noexec,relatime,size=804692k,mode=755"},
+		{Device: "none", Path: "/sys/fs/cgroup", FsType: "tmpfs", Options: "rw,relatime,size=4k,mode=755"},
+		{Device: "/dev/sda2", Path: "/boot", FsType: "ext2", Options: "rw,relatime"},
+		{Device: "/dev/mapper/vg-mysql", Path: "/var/lib/mysql", FsType: "xfs", Options: "rw,noatime,attr2,inode64,noquota"},
+		{Device: "/dev/mapper/vg-logs", Path: "/var/log/mysql logs", FsType: "xfs", Options: "rw,noatime,attr2,inode64,noquota"},
+	}
+	if same, diff := test.IsDeeply(s.mounts, expect); !same {
+		test.Dump(s.mounts)
+		t.Error(diff)
+	}
+}
+
+func (s *FilesystemTestSuite) TestFsFilter(t *C) {
+	match := func(config *system.Config) []string {
+		f, err := system.NewFsFilter(config)
+		t.Assert(err, IsNil)
+		paths := []string{}
+		for _, mount := range s.mounts {
+			if f.Match(mount) {
+				paths = append(paths, mount.Path)
+			}
+		}
+		return paths
+	}
+
+	// Default: no virtual filesystems.
+	t.Check(match(&system.Config{}), DeepEquals, []string{"/", "/boot", "/var/lib/mysql", "/var/log/mysql logs"})
+
+	t.Check(match(&system.Config{FsTypeInclude: []string{"xfs", "tmpfs"}}), DeepEquals,
+		[]string{"/run", "/sys/fs/cgroup", "/var/lib/mysql", "/var/log/mysql logs"})
+
+	t.Check(match(&system.Config{FsTypeExclude: []string{"ext2"}}), DeepEquals,
+		[]string{"/", "/var/lib/mysql", "/var/log/mysql logs"})
+
+	t.Check(match(&system.Config{MountInclude: []string{"^/var/"}, MountExclude: []string{"logs$"}}), DeepEquals,
+		[]string{"/var/lib/mysql"})
+
+	_, err := system.NewFsFilter(&system.Config{MountExclude: []string{"("}})
+	t.Check(err, NotNil)
+
+	// Network filesystems can hang, so only if explicitly included.
+	nfs := system.Mount{Device: "nas:/backup", Path: "/backup", FsType: "nfs4"}
+	f, err := system.NewFsFilter(&system.Config{})
+	t.Assert(err, IsNil)
+	t.Check(f.Match(nfs), Equals, false)
+	f, err = system.NewFsFilter(&system.Config{FsTypeInclude: []string{"nfs4"}})
+	t.Assert(err, IsNil)
+	t.Check(f.Match(nfs), Equals, true)
+}
+
+func (s *FilesystemTestSuite) TestMountPoint(t *C) {
+	dirs := map[string]string{
+		"/var/lib/mysql":             "/var/lib/mysql",
+		"/var/lib/mysql/":            "/var/lib/mysql",
+		"/var/lib/mysql/test/t1.ibd": "/var/lib/mysql",
+		"/var/lib/mysql2":            "/",
+		"/var/log/mysql logs/slow":   "/var/log/mysql logs",
+		"/tmp":                       "/",
+	}
+	for dir, path := range dirs {
+		mount, ok := system.MountPoint(s.mounts, dir)
+		t.Check(ok, Equals, true)
+		t.Check(mount.Path, Equals, path, Commentf(dir))
+	}
+
+	_, ok := system.MountPoint(s.mounts, "mysql")
+	t.Check(ok, Equals, false)
+	_, ok = system.MountPoint([]system.Mount{}, "/tmp")
+	t.Check(ok, Equals, false)
+}
+
+func (s *FilesystemTestSuite) TestStatfsMetrics(t *C) {
+	t.Check(system.FsName("/"), Equals, "root")
+	t.Check(system.FsName("/var/lib/mysql"), Equals, "var-lib-mysql")
+	t.Check(system.FsName("/var/log/mysql logs"), Equals, "var-log-mysql_logs")
+
+	// Paths with the same FsName get unique names.
+	names := system.FsNames([]system.Mount{{Path: "/"}, {Path: "/var/lib/mysql"}, {Path: "/var/lib-mysql"}})
+	t.Check(names["/"], Equals, "root")
+	t.Check(names["/var/lib/mysql"], Matches, "var-lib-mysql-[0-9a-f]{8}")
+	t.Check(names["/var/lib-mysql"], Matches, "var-lib-mysql-[0-9a-f]{8}")
+	t.Check(names["/var/lib/mysql"], Not(Equals), names["/var/lib-mysql"])
+	names = system.FsNames(s.mounts)
+	t.Check(names["/var/lib/mysql"], Equals, "var-lib-mysql")
+
+	stat := &syscall.Statfs_t{
+		Bsize:  4096,
+		Blocks: 1000,
+		Bfree:  400,
+		Bavail: 350,
+		Files:  256,
+		Ffree:  200,
+	}
+	got := system.StatfsMetrics("var-lib-mysql", stat)
+	expect := []mm.Metric{
+		{Name: "fs/var-lib-mysql/bytes_total", Type: "gauge", Number: 1000 * 4096},
+		{Name: "fs/var-lib-mysql/bytes_used", Type: "gauge", Number: 600 * 4096},
+		{Name: "fs/var-lib-mysql/bytes_free", Type: "gauge", Number: 350 * 4096},
+		{Name: "fs/var-lib-mysql/inodes_total", Type: "gauge", Number: 256},
+		{Name: "fs/var-lib-mysql/inodes_used", Type: "gauge", Number: 56},
+		{Name: "fs/var-lib-mysql/inodes_free", Type: "gauge", Number: 200},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Manager
 /////////////////////////////////////////////////////////////////////////////
@@ -575,3 +1164,34 @@ func (s *ManagerTestSuite) TestStartCollectStop(t *C) {
 		t.Fatal("Monitor has stopped")
 	}
 }
+
+func (s *ManagerTestSuite) TestReconfigure(t *C) {
+	m := system.NewMonitor(s.name, &system.Config{}, s.logger)
+
+	// Can't reconfigure a monitor that isn't running.
+	err := m.Reconfigure([]byte(`{}`))
+	t.Check(err, NotNil)
+
+	err = m.Start(s.tickChan, s.collectionChan)
+	t.Assert(err, IsNil)
+	defer m.Stop()
+
+	config := &system.Config{
+		Config: mm.Config{
+			ServiceInstance: proto.ServiceInstance{Service: "server", InstanceId: 1},
+			Collect:         10,
+			Report:          60,
+		},
+		MountExclude: []string{"^/boot"},
+	}
+	data, err := json.Marshal(config)
+	t.Assert(err, IsNil)
+	err = m.Reconfigure(data)
+	t.Assert(err, IsNil)
+	t.Check(m.Config(), DeepEquals, config)
+
+	// Invalid config isn't applied.
+	err = m.Reconfigure([]byte(`{"MountInclude": ["("]}`))
+	t.Check(err, NotNil)
+	t.Check(m.Config(), DeepEquals, config)
+}
@@ -32,14 +32,16 @@ const (
 	CONFIG_DIR      = "config"
 	DATA_DIR        = "data"
 	BIN_DIR         = "bin"
+	SYSCONFIG_DIR   = "sysconfig"
 	START_LOCK_FILE = "start.lock"
 )
 
 type basedir struct {
-	path      string
-	configDir string
-	dataDir   string
-	binDir    string
+	path         string
+	configDir    string
+	dataDir      string
+	binDir       string
+	sysconfigDir string
 }
 
 var Basedir basedir
@@ -70,6 +72,11 @@ func (b *basedir) Init(path string) error {
 		return err
 	}
 
+	b.sysconfigDir = filepath.Join(b.path, SYSCONFIG_DIR)
+	if err := MakeDir(b.sysconfigDir); err != nil && !os.IsExist(err) {
+		return err
+	}
+
 	return nil
 }
 
@@ -85,6 +92,8 @@ func (b *basedir) Dir(service string) string {
 		return b.dataDir
 	case "bin":
 		return b.binDir
+	case "sysconfig":
+		return b.sysconfigDir
 	default:
 		log.Panic("Invalid service: " + service)
 	}
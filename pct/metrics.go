@@ -0,0 +1,94 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct
+
+import (
+	"sync"
+)
+
+/**
+ * Metrics is the registry of the agent's own metrics which the mm agent
+ * monitor collects.  Services increment counters, e.g. lost reports, and set
+ * gauge funcs for values that are read when collected, e.g. chan lengths.
+ * Names are like mm metric names without the agent/ prefix, e.g. spool/files.
+ */
+var Metrics = NewMetricRegistry()
+
+type MetricRegistry struct {
+	counters map[string]float64
+	gauges   map[string]func() float64
+	mux      *sync.Mutex
+}
+
+func NewMetricRegistry() *MetricRegistry {
+	r := &MetricRegistry{
+		counters: make(map[string]float64),
+		gauges:   make(map[string]func() float64),
+		mux:      &sync.Mutex{},
+	}
+	return r
+}
+
+// Inc increments the counter by n.
+func (r *MetricRegistry) Inc(name string, n float64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.counters[name] += n
+}
+
+// SetGauge sets the func that returns the gauge's current value.  It's called
+// from another goroutine, so it must be safe to call concurrently.
+func (r *MetricRegistry) SetGauge(name string, f func() float64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.gauges[name] = f
+}
+
+func (r *MetricRegistry) RemoveGauge(name string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	delete(r.gauges, name)
+}
+
+// Counters returns the current value of all counters.
+func (r *MetricRegistry) Counters() map[string]float64 {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	counters := make(map[string]float64, len(r.counters))
+	for name, val := range r.counters {
+		counters[name] = val
+	}
+	return counters
+}
+
+// Gauges returns the current value of all gauges.  The gauge funcs are
+// called without the registry locked, so they can be slow.
+func (r *MetricRegistry) Gauges() map[string]float64 {
+	r.mux.Lock()
+	funcs := make(map[string]func() float64, len(r.gauges))
+	for name, f := range r.gauges {
+		funcs[name] = f
+	}
+	r.mux.Unlock()
+
+	gauges := make(map[string]float64, len(funcs))
+	for name, f := range funcs {
+		gauges[name] = f()
+	}
+	return gauges
+}
@@ -0,0 +1,56 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pct_test
+
+import (
+	"github.com/percona/percona-agent/pct"
+	. "launchpad.net/gocheck"
+)
+
+/////////////////////////////////////////////////////////////////////////////
+// metrics.go test suite
+/////////////////////////////////////////////////////////////////////////////
+
+type MetricsTestSuite struct {
+}
+
+var _ = Suite(&MetricsTestSuite{})
+
+func (s *MetricsTestSuite) TestRegistry(t *C) {
+	r := pct.NewMetricRegistry()
+	t.Check(r.Counters(), HasLen, 0)
+	t.Check(r.Gauges(), HasLen, 0)
+
+	r.Inc("mm/lost_reports", 1)
+	r.Inc("mm/lost_reports", 2)
+	r.Inc("qan/parse_time", 0.5)
+	t.Check(r.Counters(), DeepEquals, map[string]float64{
+		"mm/lost_reports": 3,
+		"qan/parse_time":  0.5,
+	})
+
+	c := make(chan bool, 5)
+	c <- true
+	r.SetGauge("test/chan", func() float64 { return float64(len(c)) })
+	t.Check(r.Gauges(), DeepEquals, map[string]float64{"test/chan": 1})
+	c <- true
+	t.Check(r.Gauges(), DeepEquals, map[string]float64{"test/chan": 2})
+
+	r.RemoveGauge("test/chan")
+	t.Check(r.Gauges(), HasLen, 0)
+}
@@ -279,6 +279,7 @@ func (m *Manager) run(config Config) {
 			m.logger.Debug(fmt.Sprintf("%d workers running", runningWorkers))
 			if runningWorkers >= config.MaxWorkers {
 				m.logger.Warn("All workers busy, interval dropped")
+				pct.Metrics.Inc("qan/dropped_intervals", 1)
 				continue
 			}
 
@@ -323,9 +324,19 @@ func (m *Manager) run(config Config) {
 				}
 				result.RunTime = t1.Sub(t0).Seconds()
 
+				// Parse throughput is bytes_parsed / parse_time.
+				var queries uint64
+				for _, class := range result.Classes {
+					queries += class.TotalQueries
+				}
+				pct.Metrics.Inc("qan/bytes_parsed", float64(result.StopOffset-job.StartOffset))
+				pct.Metrics.Inc("qan/queries_parsed", float64(queries))
+				pct.Metrics.Inc("qan/parse_time", result.RunTime)
+
 				report := MakeReport(config.ServiceInstance, interval, result, config)
 				if err := m.spool.Write("qan", report); err != nil {
 					m.logger.Warn("Lost report:", err)
+					pct.Metrics.Inc("qan/lost_reports", 1)
 				}
 			}(interval)
 		case worker := <-m.workerDoneChan:
@@ -23,5 +23,7 @@ import (
 
 type Config struct {
 	proto.ServiceInstance
-	Report uint // how often to collect and send config (seconds)
+	Monitor  string `json:",omitempty"` // monitor type if not the service's default, e.g. mycnf
+	Report   uint   // how often to collect and send config (seconds)
+	Snapshot uint   // how often to send all settings, else only changes (seconds, default DEFAULT_SNAPSHOT)
 }
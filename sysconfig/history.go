@@ -0,0 +1,323 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sysconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_SNAPSHOT = 86400 // 1d
+	HISTORY_SIZE     = 1000  // max changes kept per instance
+)
+
+// A HistoryQuery is the data for the GetHistory command.
+type HistoryQuery struct {
+	Service    string    `json:",omitempty"` // any service if empty
+	InstanceId uint      `json:",omitempty"` // any instance if zero
+	System     string    `json:",omitempty"` // any system if empty
+	Var        string    `json:",omitempty"` // path.Match pattern, all vars if empty
+	Begin      time.Time `json:",omitempty"` // inclusive, oldest if zero
+	End        time.Time `json:",omitempty"` // inclusive, newest if zero
+}
+
+func (q HistoryQuery) Validate() error {
+	if _, err := path.Match(q.Var, ""); err != nil {
+		return fmt.Errorf("Invalid Var: %s", err)
+	}
+	if !q.Begin.IsZero() && !q.End.IsZero() && q.End.Before(q.Begin) {
+		return fmt.Errorf("End is before Begin")
+	}
+	return nil
+}
+
+// Diff returns the changes from prev to cur settings, sorted by var.
+func Diff(system string, ts int64, prev, cur []Setting) []Change {
+	old := make(map[string]string, len(prev))
+	for _, s := range prev {
+		old[s[0]] = s[1]
+	}
+	changes := []Change{}
+	seen := make(map[string]bool, len(cur))
+	for _, s := range cur {
+		if seen[s[0]] {
+			continue
+		}
+		seen[s[0]] = true
+		oldVal, ok := old[s[0]]
+		if !ok {
+			changes = append(changes, Change{Ts: ts, System: system, Var: s[0], Type: SETTING_ADDED, New: s[1]})
+		} else if oldVal != s[1] {
+			changes = append(changes, Change{Ts: ts, System: system, Var: s[0], Type: SETTING_CHANGED, Old: oldVal, New: s[1]})
+		}
+	}
+	for _, s := range prev {
+		if !seen[s[0]] {
+			seen[s[0]] = true
+			changes = append(changes, Change{Ts: ts, System: system, Var: s[0], Type: SETTING_REMOVED, Old: s[1]})
+		}
+	}
+	sort.Sort(byVar(changes))
+	return changes
+}
+
+type byVar []Change
+
+func (a byVar) Len() int           { return len(a) }
+func (a byVar) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byVar) Less(i, j int) bool { return a[i].Var < a[j].Var }
+
+/**
+ * History is the last snapshot and recent changes of one instance's settings,
+ * saved in dir as <name>.snapshot and <name>.history so that changes made
+ * while the agent was down are detected when it restarts.  Each system (a
+ * monitor can report several) has its own snapshot.
+ */
+type History struct {
+	name     string
+	si       proto.ServiceInstance
+	snapshot uint // how often to send full reports (seconds)
+	// --
+	snapshotFile string
+	historyFile  string
+	snapshots    map[string]*settingsSnapshot // keyed on Report.System
+	changes      []Change
+	mux          *sync.Mutex
+	removed      bool // files removed, don't save them again
+}
+
+type settingsSnapshot struct {
+	Report *Report
+	FullTs int64 // when last full report was sent
+}
+
+func NewHistory(dir, name string, si proto.ServiceInstance, snapshot uint) *History {
+	if snapshot == 0 {
+		snapshot = DEFAULT_SNAPSHOT
+	}
+	h := &History{
+		name:     name,
+		si:       si,
+		snapshot: snapshot,
+		// --
+		snapshotFile: filepath.Join(dir, name+".snapshot"),
+		historyFile:  filepath.Join(dir, name+".history"),
+		snapshots:    make(map[string]*settingsSnapshot),
+		changes:      []Change{},
+		mux:          &sync.Mutex{},
+	}
+	return h
+}
+
+// Load the saved snapshot and history, if any.
+func (h *History) Load() error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if err := readJSON(h.snapshotFile, &h.snapshots); err != nil {
+		return err
+	}
+	if err := readJSON(h.historyFile, &h.changes); err != nil {
+		return err
+	}
+	return nil
+}
+
+/**
+ * Update the snapshot with the given full report and return the report to
+ * send: r itself if it's time to send a full report, else a report with only
+ * the changes, or nil if nothing changed.  A returned report is returned even
+ * if there's an error saving the snapshot or history.
+ */
+func (h *History) Update(r *Report) (*Report, error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	last, ok := h.snapshots[r.System]
+	if !ok {
+		// First report for this system: nothing to diff against.
+		r.Full = true
+		h.snapshots[r.System] = &settingsSnapshot{Report: settingsOnly(r), FullTs: r.Ts}
+		return r, h.saveSnapshot()
+	}
+
+	changes := Diff(r.System, r.Ts, last.Report.Settings, r.Settings)
+	if len(changes) > 0 {
+		h.changes = append(h.changes, changes...)
+		if len(h.changes) > HISTORY_SIZE {
+			h.changes = h.changes[len(h.changes)-HISTORY_SIZE:]
+		}
+	}
+	last.Report = settingsOnly(r)
+
+	var send *Report
+	if r.Ts-last.FullTs >= int64(h.snapshot) {
+		r.Full = true
+		r.Changes = changes
+		last.FullTs = r.Ts
+		send = r
+	} else if len(changes) > 0 {
+		send = &Report{
+			ServiceInstance: r.ServiceInstance,
+			Ts:              r.Ts,
+			System:          r.System,
+			Settings:        []Setting{},
+			Changes:         changes,
+		}
+	}
+
+	if send == nil {
+		return nil, nil // nothing new, nothing to save
+	}
+	if h.removed {
+		return send, nil
+	}
+	var err error
+	if len(changes) > 0 {
+		err = writeJSON(h.historyFile, h.changes)
+	}
+	if serr := h.saveSnapshot(); serr != nil {
+		err = serr
+	}
+	return send, err
+}
+
+/**
+ * Snapshots returns the last full report of every system, for sending on
+ * request.  The next full report is scheduled from now.
+ */
+func (h *History) Snapshots(now int64) ([]*Report, error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	systems := []string{}
+	for system := range h.snapshots {
+		systems = append(systems, system)
+	}
+	sort.Strings(systems)
+	reports := make([]*Report, len(systems))
+	for i, system := range systems {
+		s := h.snapshots[system]
+		r := settingsOnly(s.Report)
+		r.Full = true
+		reports[i] = r
+		s.FullTs = now
+	}
+	if len(reports) == 0 {
+		return reports, nil
+	}
+	return reports, h.saveSnapshot()
+}
+
+// Changes returns the changes that match the query, oldest first.
+func (h *History) Changes(q HistoryQuery) []Change {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	var begin, end int64
+	if !q.Begin.IsZero() {
+		begin = q.Begin.Unix()
+	}
+	if !q.End.IsZero() {
+		end = q.End.Unix()
+	}
+
+	got := []Change{}
+	if (q.Service != "" && q.Service != h.si.Service) || (q.InstanceId != 0 && q.InstanceId != h.si.InstanceId) {
+		return got
+	}
+	for _, c := range h.changes {
+		if q.System != "" && q.System != c.System {
+			continue
+		}
+		if (begin != 0 && c.Ts < begin) || (end != 0 && c.Ts > end) {
+			continue
+		}
+		if q.Var != "" {
+			if ok, _ := path.Match(q.Var, c.Var); !ok {
+				continue
+			}
+		}
+		got = append(got, c)
+	}
+	return got
+}
+
+// settingsOnly returns a copy of r without the fields that aren't snapshot.
+func settingsOnly(r *Report) *Report {
+	return &Report{
+		ServiceInstance: r.ServiceInstance,
+		Ts:              r.Ts,
+		System:          r.System,
+		Settings:        r.Settings,
+	}
+}
+
+func (h *History) saveSnapshot() error {
+	if h.removed {
+		return nil
+	}
+	return writeJSON(h.snapshotFile, h.snapshots)
+}
+
+// Remove the saved snapshot and history when the monitor is stopped, so a
+// monitor started later with the same name doesn't report stale changes.
+func (h *History) Remove() error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.removed = true
+	if err := pct.RemoveFile(h.snapshotFile); err != nil {
+		return err
+	}
+	return pct.RemoveFile(h.historyFile)
+}
+
+func readJSON(file string, v interface{}) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("Decode %s: %s", file, err)
+	}
+	return nil
+}
+
+func writeJSON(file string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	// Write then rename so a crash doesn't leave a partial file.
+	tmpFile := file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, file)
+}
@@ -20,7 +20,9 @@ package sysconfig
 /**
  * sysconfig is a proxy manager for monitors.  It implements the service manager
  * interface (pct/service.go), but it's always running.  Its main job is done in
- * Handle(): keeping track of the monitors it starts and stops.
+ * Handle(): keeping track of the monitors it starts and stops.  Monitors report
+ * all settings every time; the manager keeps each monitor's History and only
+ * spools what changed, plus a full snapshot every Config.Snapshot seconds.
  */
 
 import (
@@ -33,6 +35,7 @@ import (
 	"github.com/percona/percona-agent/ticker"
 	"io/ioutil"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 )
@@ -45,9 +48,10 @@ type Manager struct {
 	im      *instance.Repo
 	// --
 	monitors       map[string]Monitor
+	history        map[string]*monitorHistory // keyed on monitor name like monitors
 	running        bool
-	mux            *sync.RWMutex // guards monitors and running
-	reportChan     chan *Report  // <- Report from monitor
+	mux            *sync.RWMutex // guards monitors, history, and running
+	reportChan     chan *Report  // <- Report from diff()
 	spoolerRunning bool
 	status         *pct.Status
 }
@@ -62,6 +66,7 @@ func NewManager(logger *pct.Logger, factory MonitorFactory, clock ticker.Manager
 		// --
 		reportChan: make(chan *Report, 3),
 		monitors:   make(map[string]Monitor),
+		history:    make(map[string]*monitorHistory),
 		status:     pct.NewStatus([]string{"sysconfig", "sysconfig-spooler"}),
 		mux:        &sync.RWMutex{},
 	}
@@ -131,7 +136,9 @@ func (m *Manager) Stop() error {
 			continue
 		}
 		m.clock.Remove(monitor.TickChan())
+		close(m.history[name].reportChan)
 		delete(m.monitors, name)
+		delete(m.history, name)
 	}
 	m.running = false
 	m.logger.Info("Stopped")
@@ -168,6 +175,16 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 			return cmd.Reply(nil, errors.New("Factory: "+err.Error()))
 		}
 
+		// Load the monitor's last snapshot and history so changes made
+		// while the agent was stopped are reported.
+		history := &monitorHistory{
+			History:    NewHistory(pct.Basedir.Dir("sysconfig"), name, c.ServiceInstance, c.Snapshot),
+			reportChan: make(chan *Report, 1),
+		}
+		if err := history.Load(); err != nil {
+			m.logger.Warn("Load " + name + " history: " + err.Error())
+		}
+
 		// Make unsynchronized (3rd arg=false) ticker for collect interval,
 		// it's unsynchronized because 1) we don't need sysconfig data to be
 		// synchronized, and 2) sysconfig monitors usually collect very slowly,
@@ -175,12 +192,16 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 		tickChan := make(chan time.Time)
 		m.clock.Add(tickChan, c.Report, false)
 
-		// Start the monitor.
-		if err = monitor.Start(tickChan, m.reportChan); err != nil {
+		// Start the monitor.  It sends full reports to diff() which sends
+		// only what changed to spooler().
+		go m.diff(name, history)
+		if err = monitor.Start(tickChan, history.reportChan); err != nil {
+			close(history.reportChan)
 			return cmd.Reply(nil, errors.New("Start "+name+": "+err.Error()))
 		}
 		m.mux.Lock()
 		m.monitors[name] = monitor
+		m.history[name] = history
 		m.mux.Unlock()
 
 		// Save the monitor-specific config to disk so agent starts on restart.
@@ -210,9 +231,50 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 			return cmd.Reply(nil, errors.New("Remove "+name+": "+err.Error()))
 		}
 		m.mux.Lock()
+		history := m.history[name]
+		close(history.reportChan)
 		delete(m.monitors, name)
+		delete(m.history, name)
 		m.mux.Unlock()
+		if err := history.Remove(); err != nil {
+			return cmd.Reply(nil, errors.New("Remove "+name+" history: "+err.Error()))
+		}
+		return cmd.Reply(nil) // success
+	case "SendSnapshot":
+		_, name, err := m.getMonitorConfig(cmd)
+		if err != nil {
+			return cmd.Reply(nil, err)
+		}
+		m.mux.RLock()
+		history, ok := m.history[name]
+		m.mux.RUnlock()
+		if !ok {
+			return cmd.Reply(nil, errors.New("Unknown monitor: "+name))
+		}
+		reports, err := history.Snapshots(time.Now().UTC().Unix())
+		if err != nil {
+			m.logger.Warn("Save " + name + " snapshot: " + err.Error())
+		}
+		if len(reports) == 0 {
+			return cmd.Reply(nil, errors.New("No snapshot yet for "+name))
+		}
+		for _, r := range reports {
+			if err := m.spool.Write("sysconfig", r); err != nil {
+				return cmd.Reply(nil, errors.New("Spool "+name+" snapshot: "+err.Error()))
+			}
+		}
 		return cmd.Reply(nil) // success
+	case "GetHistory":
+		q := HistoryQuery{}
+		if len(cmd.Data) > 0 {
+			if err := json.Unmarshal(cmd.Data, &q); err != nil {
+				return cmd.Reply(nil, errors.New("sysconfig.Handle:json.Unmarshal:"+err.Error()))
+			}
+		}
+		if err := q.Validate(); err != nil {
+			return cmd.Reply(nil, err)
+		}
+		return cmd.Reply(m.getHistory(q))
 	case "GetConfig":
 		config, errs := m.GetConfig()
 		return cmd.Reply(config, errs...)
@@ -286,10 +348,55 @@ func (m *Manager) spooler() {
 	for s := range m.reportChan {
 		if err := m.spool.Write("sysconfig", s); err != nil {
 			m.logger.Warn("Lost report:", err)
+			pct.Metrics.Inc("sysconfig/lost_reports", 1)
+		}
+	}
+}
+
+// A monitor's History and the chan it sends its full reports to.
+type monitorHistory struct {
+	*History
+	reportChan chan *Report // <- Report from monitor
+}
+
+// @goroutine[2]
+func (m *Manager) diff(name string, history *monitorHistory) {
+	for r := range history.reportChan {
+		r, err := history.Update(r)
+		if err != nil {
+			m.logger.Warn("Save " + name + " history: " + err.Error())
+		}
+		if r == nil {
+			m.logger.Debug("No changes from " + name)
+			continue
 		}
+		m.reportChan <- r
 	}
 }
 
+// Changes from all monitors that match the query, oldest first.
+func (m *Manager) getHistory(q HistoryQuery) []Change {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	names := []string{}
+	for name := range m.history {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	changes := []Change{}
+	for _, name := range names {
+		changes = append(changes, m.history[name].Changes(q)...)
+	}
+	sort.Stable(byTs(changes))
+	return changes
+}
+
+type byTs []Change
+
+func (a byTs) Len() int           { return len(a) }
+func (a byTs) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTs) Less(i, j int) bool { return a[i].Ts < a[j].Ts }
+
 func (m *Manager) getMonitorConfig(cmd *proto.Cmd) (*Config, string, error) {
 	/**
 	 * cmd.Data is a monitor-specific config, e.g. mysql.Config.  But monitor-specific
@@ -303,8 +410,12 @@ func (m *Manager) getMonitorConfig(cmd *proto.Cmd) (*Config, string, error) {
 		return nil, "", errors.New("sysconfig.Handle:json.Unmarshal:" + err.Error())
 	}
 
-	// The real name of the internal service, e.g. sysconfig-mysql-1:
+	// The real name of the internal service, e.g. sysconfig-mysql-1, or
+	// sysconfig-mysql-1-mycnf if it's not the service's default monitor:
 	name := "sysconfig-" + m.im.Name(c.Service, c.InstanceId)
+	if c.Monitor != "" {
+		name += "-" + c.Monitor
+	}
 
 	return c, name, nil
 }
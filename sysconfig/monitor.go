@@ -37,9 +37,31 @@ type MonitorFactory interface {
 // ["variable", "value"]
 type Setting [2]string
 
+/**
+ * A Report is either a full snapshot of all settings (Full=true) or only the
+ * changes since the previous report (Full=false, Settings empty).  Monitors
+ * always send full reports; the manager turns them into diffs.
+ */
 type Report struct {
 	proto.ServiceInstance
 	Ts       int64 // UTC Unix timestamp
 	System   string
 	Settings []Setting
+	Full     bool
+	Changes  []Change `json:",omitempty"`
+}
+
+const (
+	SETTING_ADDED   = "added"
+	SETTING_REMOVED = "removed"
+	SETTING_CHANGED = "changed"
+)
+
+type Change struct {
+	Ts     int64 // UTC Unix timestamp when detected
+	System string
+	Var    string
+	Type   string // SETTING_ADDED, SETTING_REMOVED, or SETTING_CHANGED
+	Old    string `json:",omitempty"`
+	New    string `json:",omitempty"`
 }
@@ -25,7 +25,9 @@ import (
 	mysqlConn "github.com/percona/percona-agent/mysql"
 	"github.com/percona/percona-agent/pct"
 	"github.com/percona/percona-agent/sysconfig"
+	"github.com/percona/percona-agent/sysconfig/mycnf"
 	"github.com/percona/percona-agent/sysconfig/mysql"
+	"github.com/percona/percona-agent/sysconfig/system"
 )
 
 type Factory struct {
@@ -42,8 +44,18 @@ func NewFactory(logChan chan *proto.LogEntry, ir *instance.Repo) *Factory {
 }
 
 func (f *Factory) Make(service string, instanceId uint, data []byte) (sysconfig.Monitor, error) {
+	// Most services have one monitor, but some have others, e.g. mysql-mycnf.
+	sysconfigConfig := &sysconfig.Config{}
+	if err := json.Unmarshal(data, sysconfigConfig); err != nil {
+		return nil, err
+	}
+	monitorType := service
+	if sysconfigConfig.Monitor != "" {
+		monitorType += "-" + sysconfigConfig.Monitor
+	}
+
 	var monitor sysconfig.Monitor
-	switch service {
+	switch monitorType {
 	case "mysql":
 		// Load the MySQL instance info (DSN, name, etc.).
 		mysqlIt := &proto.MySQLInstance{}
@@ -67,8 +79,45 @@ func (f *Factory) Make(service string, instanceId uint, data []byte) (sysconfig.
 			pct.NewLogger(f.logChan, alias),
 			mysqlConn.NewConnection(mysqlIt.DSN),
 		)
+	case "mysql-mycnf":
+		mysqlIt := &proto.MySQLInstance{}
+		if err := f.ir.Get(service, instanceId, mysqlIt); err != nil {
+			return nil, err
+		}
+
+		// Parse the option files config.
+		config := &mycnf.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		alias := "sysconfig-mysql-mycnf-" + mysqlIt.Hostname
+
+		// Make a MySQL option files monitor.
+		monitor = mycnf.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+			mysqlConn.NewConnection(mysqlIt.DSN),
+		)
+	case "server":
+		// Parse the OS settings config.
+		config := &system.Config{}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+
+		// Only one system for now, so no "-instanceName" suffix.
+		alias := "sysconfig-system"
+
+		// Make an OS settings monitor.
+		monitor = system.NewMonitor(
+			alias,
+			config,
+			pct.NewLogger(f.logChan, alias),
+		)
 	default:
-		return nil, errors.New("Unknown sysconfig monitor type: " + service)
+		return nil, errors.New("Unknown sysconfig monitor type: " + monitorType)
 	}
 	return monitor, nil
 }
@@ -0,0 +1,165 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mycnf
+
+import (
+	"fmt"
+	"github.com/percona/percona-agent/sysconfig"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Differences returns a setting for every option whose value differs from the
+ * running value of its variable, sorted by variable name.  Options that are
+ * not variables are ignored.  The setting value has both values and where
+ * the option is, e.g. "file: 100 (/etc/my.cnf:12), running: 500".
+ */
+func Differences(options map[string]Option, variables map[string]string) []sysconfig.Setting {
+	settings := []sysconfig.Setting{}
+	for _, o := range options {
+		name, value, ok := variableFor(o, variables)
+		if !ok {
+			continue
+		}
+		running := variables[name]
+		if valuesEqual(name, value, running) {
+			continue
+		}
+		settings = append(settings, sysconfig.Setting{
+			name,
+			fmt.Sprintf("file: %s (%s:%d), running: %s", value, o.File, o.Line, running),
+		})
+	}
+	sort.Sort(byName(settings))
+	return settings
+}
+
+type byName []sysconfig.Setting
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i][0] < a[j][0] }
+
+// variableFor returns the variable that the option sets and its value, which
+// for boolean options like skip-name-resolve is implied.
+func variableFor(o Option, variables map[string]string) (string, string, bool) {
+	if _, ok := variables[o.Name]; ok {
+		if !o.HasValue {
+			return o.Name, "ON", true
+		}
+		return o.Name, o.Value, true
+	}
+	prefixes := []struct {
+		prefix string
+		value  string
+	}{
+		{"skip_", "OFF"},
+		{"disable_", "OFF"},
+		{"enable_", "ON"},
+	}
+	for _, p := range prefixes {
+		if !strings.HasPrefix(o.Name, p.prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(o.Name, p.prefix)
+		if _, ok := variables[name]; ok {
+			return name, p.value, true
+		}
+	}
+	return "", "", false
+}
+
+var boolValues = map[string]string{
+	"on":    "on",
+	"true":  "on",
+	"yes":   "on",
+	"1":     "on",
+	"off":   "off",
+	"false": "off",
+	"no":    "off",
+	"0":     "off",
+}
+
+// Options that take a name, e.g. log-bin=mysql-bin, but whose variable is
+// ON or OFF on some versions.  Any name enables it.
+var nameOptions = map[string]bool{
+	"log_bin":          true,
+	"relay_log":        true,
+	"log_slow_queries": true, // MySQL 5.1
+	"log":              true, // MySQL 5.1 general log
+}
+
+// valuesEqual returns true if the option file value is the running value of
+// the variable, allowing for how they're written differently: ON vs. 1, 1G
+// vs. 1073741824, 1 vs. 1.000000, mysql-bin vs. ON for options in nameOptions,
+// and dirs with and without a trailing slash.
+func valuesEqual(name, file, running string) bool {
+	if strings.EqualFold(file, running) {
+		return true
+	}
+	b1, fileBool := boolValues[strings.ToLower(file)]
+	b2, runningBool := boolValues[strings.ToLower(running)]
+	if fileBool && runningBool {
+		return b1 == b2
+	}
+	if nameOptions[name] && !fileBool && runningBool {
+		return b2 == "on"
+	}
+	if n1, ok := parseSize(file); ok {
+		if n2, ok := parseSize(running); ok {
+			return n1 == n2
+		}
+	}
+	if f1, err := strconv.ParseFloat(file, 64); err == nil {
+		if f2, err := strconv.ParseFloat(running, 64); err == nil {
+			return f1 == f2
+		}
+	}
+	if strings.HasPrefix(file, "/") && strings.HasPrefix(running, "/") {
+		return strings.TrimRight(file, "/") == strings.TrimRight(running, "/")
+	}
+	return false
+}
+
+// parseSize parses numbers like 64M which mysqld allows for numeric options.
+func parseSize(s string) (uint64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	multiplier := uint64(1)
+	switch s[len(s)-1] {
+	case 'k', 'K':
+		multiplier = 1 << 10
+	case 'm', 'M':
+		multiplier = 1 << 20
+	case 'g', 'G':
+		multiplier = 1 << 30
+	case 't', 'T':
+		multiplier = 1 << 40
+	}
+	if multiplier > 1 {
+		s = s[0 : len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n * multiplier, true
+}
@@ -0,0 +1,35 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mycnf
+
+import (
+	"github.com/percona/percona-agent/sysconfig"
+)
+
+// Option files read if Config.Files is not set, like mysqld on Linux.
+var DEFAULT_FILES = []string{"/etc/my.cnf", "/etc/mysql/my.cnf"}
+
+// Option file groups read if Config.Groups is not set.  The version-specific
+// group, e.g. mysqld-5.6, is always read, too.
+var DEFAULT_GROUPS = []string{"mysqld", "server"}
+
+type Config struct {
+	sysconfig.Config
+	Files  []string `json:",omitempty"` // option files, DEFAULT_FILES if empty
+	Groups []string `json:",omitempty"` // option file groups, DEFAULT_GROUPS if empty
+}
@@ -0,0 +1,258 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mycnf
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/mysql"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/sysconfig"
+	"strings"
+	"time"
+)
+
+const SYSTEM = "mysql option files"
+
+/**
+ * Monitor reports option file values that differ from the running values,
+ * e.g. from SET GLOBAL changes that were never written to my.cnf and will be
+ * lost when MySQL restarts.  MySQL must be local to read its option files.
+ */
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	conn   mysql.Connector
+	// --
+	tickChan   chan time.Time
+	reportChan chan *sysconfig.Report
+	status     *pct.Status
+	sync       *pct.SyncChan
+	running    bool
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger, conn mysql.Connector) *Monitor {
+	if len(config.Files) == 0 {
+		config.Files = DEFAULT_FILES
+	}
+	if len(config.Groups) == 0 {
+		config.Groups = DEFAULT_GROUPS
+	}
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		conn:   conn,
+		// --
+		sync:   pct.NewSyncChan(),
+		status: pct.NewStatus([]string{name, name + "-mysql"}),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, reportChan chan *sysconfig.Report) error {
+	if m.running {
+		return pct.ServiceIsRunningError{m.name}
+	}
+
+	m.status.Update(m.name, "Starting")
+	m.tickChan = tickChan
+	m.reportChan = reportChan
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+	m.running = false
+	m.logger.Info("Stopped")
+	// Do not update status to "Stopped" here; run() does that on return.
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	defer func() {
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+	}()
+
+	var lastTs int64
+	for {
+		m.logger.Debug("run:wait")
+		m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s)", time.Unix(lastTs, 0)))
+
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:collect:start")
+			m.status.Update(m.name, "Running")
+
+			// Connect to MySQL.
+			m.status.Update(m.name+"-mysql", "Connecting")
+			if err := m.conn.Connect(2); err != nil {
+				m.logger.Warn(err)
+				m.status.Update(m.name+"-mysql", "Error: "+err.Error())
+				continue
+			}
+			m.status.Update(m.name+"-mysql", "Connected")
+
+			// Get SHOW GLOBAL VARIABLES.
+			variables, err := m.GetGlobalVariables(m.conn.DB())
+
+			// Disconnect from MySQL.
+			m.conn.Close()
+			m.status.Update(m.name+"-mysql", "Disconnected (OK)")
+
+			if err != nil {
+				m.logger.Warn(err)
+				continue
+			}
+
+			// Read the option files with the groups for this version of MySQL.
+			m.status.Update(m.name, "Reading option files")
+			groups := append(VersionGroups(variables["version"]), m.config.Groups...)
+			p := NewOptionFileParser(groups)
+			if err := m.parseFiles(p); err != nil {
+				m.logger.Warn(err)
+				continue
+			}
+			if len(p.Files()) == 0 {
+				m.logger.Warn("No option files: ", strings.Join(m.config.Files, ", "))
+				continue
+			}
+
+			// Every report has all the differences, so if there are none
+			// it means that earlier differences were fixed.
+			c := &sysconfig.Report{
+				ServiceInstance: proto.ServiceInstance{
+					Service:    m.config.Service,
+					InstanceId: m.config.InstanceId,
+				},
+				Ts:       now.UTC().Unix(),
+				System:   SYSTEM,
+				Settings: Differences(p.Options(), variables),
+				Full:     true,
+			}
+
+			select {
+			case m.reportChan <- c:
+				lastTs = c.Ts
+			case <-time.After(500 * time.Millisecond):
+				// lost sysconfig
+				m.logger.Debug("Lost MySQL option file differences; timeout spooling after 500ms")
+			}
+
+			m.logger.Debug("run:collect:stop")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
+
+// Option files that don't exist are skipped, like mysqld does, but files they
+// include must exist.
+// @goroutine[2]
+func (m *Monitor) parseFiles(p *OptionFileParser) error {
+	for _, file := range m.config.Files {
+		if !pct.FileExists(file) {
+			m.logger.Debug("No option file: " + file)
+			continue
+		}
+		if err := p.Parse(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// @goroutine[2]
+func (m *Monitor) GetGlobalVariables(conn *sql.DB) (map[string]string, error) {
+	m.status.Update(m.name, "Getting SHOW GLOBAL VARIABLES")
+	rows, err := conn.Query("SHOW /*!50002 GLOBAL */ VARIABLES")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	variables := make(map[string]string)
+	for rows.Next() {
+		var varName string
+		var varValue string
+		if err = rows.Scan(&varName, &varValue); err != nil {
+			return nil, err
+		}
+		variables[strings.ToLower(varName)] = varValue
+	}
+	return variables, rows.Err()
+}
+
+/**
+ * VersionGroups returns the option file groups that mysqld reads only for its
+ * version, e.g. mysqld-5.6 for version 5.6.19-log.  MariaDB also reads
+ * mariadb and mariadb-10.0, for example.
+ */
+func VersionGroups(version string) []string {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return []string{}
+	}
+	majorMinor := parts[0] + "." + parts[1]
+	groups := []string{"mysqld-" + majorMinor}
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		groups = append(groups, "mariadb", "mariadb-"+majorMinor)
+	}
+	return groups
+}
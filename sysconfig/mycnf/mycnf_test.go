@@ -0,0 +1,143 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mycnf_test
+
+import (
+	"github.com/percona/percona-agent/sysconfig"
+	"github.com/percona/percona-agent/sysconfig/mycnf"
+	"github.com/percona/percona-agent/test"
+	. "launchpad.net/gocheck"
+	"testing"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+var sample = test.RootDir + "/sysconfig/mycnf"
+
+type TestSuite struct {
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TestParse(t *C) {
+	p := mycnf.NewOptionFileParser([]string{"mysqld", "server", "mysqld-5.6"})
+	err := p.Parse(sample + "/my.cnf")
+	t.Assert(err, IsNil)
+
+	t.Check(p.Files(), DeepEquals, []string{
+		sample + "/my.cnf",
+		sample + "/extra.cnf",
+		sample + "/conf.d/a.cnf",
+		sample + "/conf.d/b.cnf",
+	})
+
+	got := p.Options()
+	expect := map[string]mycnf.Option{
+		"port":                      {"port", "3306", true, sample + "/my.cnf", 7},
+		"datadir":                   {"datadir", "/var/lib/mysql/", true, sample + "/my.cnf", 8},
+		"max_connections":           {"max_connections", "200", true, sample + "/my.cnf", 17},
+		"innodb_buffer_pool_size":   {"innodb_buffer_pool_size", "1G", true, sample + "/my.cnf", 10},
+		"skip_name_resolve":         {"skip_name_resolve", "", false, sample + "/my.cnf", 11},
+		"innodb_file_per_table":     {"innodb_file_per_table", "ON", true, sample + "/my.cnf", 12},
+		"init_connect":              {"init_connect", "SET NAMES utf8 # not a comment", true, sample + "/my.cnf", 13},
+		"query_cache_type":          {"query_cache_type", "0", true, sample + "/extra.cnf", 2},
+		"wait_timeout":              {"wait_timeout", "300", true, sample + "/conf.d/b.cnf", 2},
+		"enable_performance_schema": {"enable_performance_schema", "", false, sample + "/conf.d/b.cnf", 3},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	// Include loops are stopped.
+	p = mycnf.NewOptionFileParser([]string{"mysqld"})
+	err = p.Parse(sample + "/loop.cnf")
+	t.Check(err, NotNil)
+
+	// Included files must exist.
+	p = mycnf.NewOptionFileParser([]string{"mysqld"})
+	err = p.Parse(sample + "/does-not-exist.cnf")
+	t.Check(err, NotNil)
+}
+
+func (s *TestSuite) TestDifferences(t *C) {
+	p := mycnf.NewOptionFileParser([]string{"mysqld", "server", "mysqld-5.6"})
+	err := p.Parse(sample + "/my.cnf")
+	t.Assert(err, IsNil)
+
+	variables := map[string]string{
+		"port":                    "3306",
+		"datadir":                 "/var/lib/mysql",
+		"max_connections":         "500", // SET GLOBAL max_connections=500
+		"innodb_buffer_pool_size": "1073741824",
+		"skip_name_resolve":       "ON",
+		"innodb_file_per_table":   "OFF", // SET GLOBAL innodb_file_per_table=OFF
+		"init_connect":            "SET NAMES utf8 # not a comment",
+		"query_cache_type":        "OFF",
+		"wait_timeout":            "28800", // SET GLOBAL wait_timeout=28800
+		"performance_schema":      "ON",
+		"version":                 "5.6.19-log",
+	}
+	got := mycnf.Differences(p.Options(), variables)
+	expect := []sysconfig.Setting{
+		{"innodb_file_per_table", "file: ON (" + sample + "/my.cnf:12), running: OFF"},
+		{"max_connections", "file: 200 (" + sample + "/my.cnf:17), running: 500"},
+		{"wait_timeout", "file: 300 (" + sample + "/conf.d/b.cnf:2), running: 28800"},
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *TestSuite) TestDifferentValueFormats(t *C) {
+	options := map[string]mycnf.Option{
+		"log_bin":             {"log_bin", "mysql-bin", true, "my.cnf", 1},
+		"relay_log":           {"relay_log", "relay-bin", true, "my.cnf", 2},
+		"long_query_time":     {"long_query_time", "1", true, "my.cnf", 3},
+		"slow_query_log":      {"slow_query_log", "1", true, "my.cnf", 4},
+		"max_heap_table_size": {"max_heap_table_size", "16M", true, "my.cnf", 5},
+	}
+	variables := map[string]string{
+		"log_bin":             "ON",
+		"relay_log":           "relay-bin",
+		"long_query_time":     "1.000000",
+		"slow_query_log":      "ON",
+		"max_heap_table_size": "16777216",
+	}
+	t.Check(mycnf.Differences(options, variables), HasLen, 0)
+
+	// A name doesn't match OFF: binary logging should be enabled.
+	variables["log_bin"] = "OFF"
+	variables["long_query_time"] = "0.500000"
+	got := mycnf.Differences(options, variables)
+	expect := []sysconfig.Setting{
+		{"log_bin", "file: mysql-bin (my.cnf:1), running: OFF"},
+		{"long_query_time", "file: 1 (my.cnf:3), running: 0.500000"},
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *TestSuite) TestNormalizeName(t *C) {
+	t.Check(mycnf.NormalizeName(" loose-Innodb-Buffer-Pool-Size "), Equals, "innodb_buffer_pool_size")
+	t.Check(mycnf.NormalizeName("max_connections"), Equals, "max_connections")
+}
+
+func (s *TestSuite) TestVersionGroups(t *C) {
+	t.Check(mycnf.VersionGroups("5.6.19-log"), DeepEquals, []string{"mysqld-5.6"})
+	t.Check(mycnf.VersionGroups("10.0.12-MariaDB-log"), DeepEquals, []string{"mysqld-10.0", "mariadb", "mariadb-10.0"})
+	t.Check(mycnf.VersionGroups(""), DeepEquals, []string{})
+}
@@ -0,0 +1,204 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mycnf
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mysqld doesn't allow deeper !include nesting, and it stops include loops.
+const MAX_INCLUDE_DEPTH = 10
+
+// An Option is the last value of an option in the option files.
+type Option struct {
+	Name     string // normalized, e.g. max-connections is max_connections
+	Value    string
+	HasValue bool // false for options like skip-name-resolve
+	File     string
+	Line     int
+}
+
+/**
+ * OptionFileParser reads MySQL option files like mysqld: options in the given
+ * groups are read, later values override earlier ones, and !include and
+ * !includedir are followed.  Options are not validated, so options that are
+ * not variables (e.g. user) are read, too.
+ */
+type OptionFileParser struct {
+	groups  map[string]bool
+	options map[string]Option
+	files   []string
+}
+
+func NewOptionFileParser(groups []string) *OptionFileParser {
+	p := &OptionFileParser{
+		groups:  make(map[string]bool),
+		options: make(map[string]Option),
+		files:   []string{},
+	}
+	for _, group := range groups {
+		p.groups[strings.ToLower(group)] = true
+	}
+	return p
+}
+
+// Parse reads the option file and the files it includes.
+func (p *OptionFileParser) Parse(file string) error {
+	return p.parse(file, 0)
+}
+
+// Options returns the options read so far, keyed on name.
+func (p *OptionFileParser) Options() map[string]Option {
+	return p.options
+}
+
+// Files returns the option files read so far, in the order read.
+func (p *OptionFileParser) Files() []string {
+	return p.files
+}
+
+func (p *OptionFileParser) parse(file string, depth int) error {
+	if depth > MAX_INCLUDE_DEPTH {
+		return fmt.Errorf("%s: too many nested !include", file)
+	}
+	fh, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	p.files = append(p.files, file)
+
+	inGroup := false
+	lineNo := 0
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		// !include and !includedir apply regardless of group.
+		if line[0] == '!' {
+			directive := strings.Fields(line)[0]
+			arg := strings.TrimSpace(line[len(directive):])
+			if arg == "" {
+				return fmt.Errorf("%s:%d: %s without a file or dir", file, lineNo, directive)
+			}
+			if !filepath.IsAbs(arg) {
+				arg = filepath.Join(filepath.Dir(file), arg)
+			}
+			switch directive {
+			case "!include":
+				if err := p.parse(arg, depth+1); err != nil {
+					return fmt.Errorf("%s:%d: %s", file, lineNo, err)
+				}
+			case "!includedir":
+				if err := p.parseDir(arg, depth+1); err != nil {
+					return fmt.Errorf("%s:%d: %s", file, lineNo, err)
+				}
+			default:
+				return fmt.Errorf("%s:%d: invalid directive: %s", file, lineNo, directive)
+			}
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				return fmt.Errorf("%s:%d: invalid group: %s", file, lineNo, line)
+			}
+			group := strings.ToLower(strings.TrimSpace(line[1:end]))
+			inGroup = p.groups[group]
+			continue
+		}
+
+		if !inGroup {
+			continue
+		}
+
+		o := Option{File: file, Line: lineNo}
+		if i := strings.Index(line, "="); i >= 0 {
+			o.Name = line[0:i]
+			o.Value = parseValue(line[i+1:])
+			o.HasValue = true
+		} else {
+			o.Name = line
+			if i := strings.Index(line, "#"); i >= 0 {
+				o.Name = line[0:i]
+			}
+		}
+		o.Name = NormalizeName(o.Name)
+		if o.Name == "" {
+			return fmt.Errorf("%s:%d: option without name: %s", file, lineNo, line)
+		}
+		p.options[o.Name] = o
+	}
+	return scanner.Err()
+}
+
+// mysqld reads only *.cnf files in an !includedir, in no particular order.
+// They're read in name order here to be consistent.
+func (p *OptionFileParser) parseDir(dir string, depth int) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".cnf") {
+			continue
+		}
+		if err := p.parse(filepath.Join(dir, fi.Name()), depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * NormalizeName returns the option name like the variable name: lowercase,
+ * with underscores instead of dashes, and without the loose- prefix, e.g.
+ * "loose-Innodb-Buffer-Pool-Size" is "innodb_buffer_pool_size".
+ */
+func NormalizeName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.Replace(name, "-", "_", -1)
+	return strings.TrimPrefix(name, "loose_")
+}
+
+var escapes = strings.NewReplacer(`\b`, "\b", `\t`, "\t", `\n`, "\n", `\r`, "\r", `\\`, `\`, `\s`, " ")
+
+// parseValue returns the value without quotes and comments, and with escape
+// sequences replaced.
+func parseValue(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return escapes.Replace(value[1 : end+1])
+		}
+	}
+	if i := strings.Index(value, "#"); i >= 0 {
+		value = strings.TrimSpace(value[0:i])
+	}
+	return escapes.Replace(value)
+}
@@ -144,6 +144,7 @@ func (m *Monitor) run() {
 				Ts:       now.UTC().Unix(),
 				System:   "mysql global variables",
 				Settings: []sysconfig.Setting{},
+				Full:     true,
 			}
 
 			// Get SHOW GLOBAL VARIABLES.
@@ -102,6 +102,16 @@ func (s *ManagerTestSuite) SetUpTest(t *C) {
 			t.Fatal(err)
 		}
 	}
+	glob = filepath.Join(pct.Basedir.Dir("sysconfig"), "*")
+	files, err = filepath.Glob(glob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			t.Fatal(err)
+		}
+	}
 }
 
 func (s *ManagerTestSuite) TearDownSuite(t *C) {
@@ -339,3 +349,181 @@ func (s *ManagerTestSuite) TestGetConfig(t *C) {
 		t.Error(diff)
 	}
 }
+
+func (s *ManagerTestSuite) TestChanges(t *C) {
+	m := sysconfig.NewManager(s.logger, s.factory, s.clock, s.spool, s.im)
+	t.Assert(m, NotNil)
+
+	err := m.Start()
+	t.Assert(err, IsNil)
+	defer m.Stop()
+
+	// Full snapshot every 2 reports.
+	sysconfigConfig := &mysql.Config{
+		Config: sysconfig.Config{
+			ServiceInstance: proto.ServiceInstance{
+				Service:    "mysql",
+				InstanceId: 1,
+			},
+			Report:   60,
+			Snapshot: 120,
+		},
+	}
+	sysconfigConfigData, err := json.Marshal(sysconfigConfig)
+	t.Assert(err, IsNil)
+	s.mockMonitor.SetConfig(sysconfigConfig)
+	cmd := &proto.Cmd{
+		Service: "sysconfig",
+		Cmd:     "StartService",
+		Data:    sysconfigConfigData,
+	}
+	reply := m.Handle(cmd)
+	t.Assert(reply.Error, Equals, "")
+
+	report := func(ts int64, settings ...sysconfig.Setting) *sysconfig.Report {
+		return &sysconfig.Report{
+			ServiceInstance: sysconfigConfig.ServiceInstance,
+			Ts:              ts,
+			System:          "mysql global variables",
+			Settings:        settings,
+			Full:            true,
+		}
+	}
+
+	// 1st report is always full.
+	s.mockMonitor.ReportChan <- report(60, sysconfig.Setting{"a", "1"}, sysconfig.Setting{"b", "2"})
+	got := test.WaitData(s.dataChan)
+	t.Assert(got, HasLen, 1)
+	r := got[0].(*sysconfig.Report)
+	t.Check(r.Full, Equals, true)
+	t.Check(r.Settings, HasLen, 2)
+	t.Check(r.Changes, HasLen, 0)
+
+	// No changes, so nothing sent.  Then b changed and c added, so only
+	// those changes are sent.
+	s.mockMonitor.ReportChan <- report(120, sysconfig.Setting{"a", "1"}, sysconfig.Setting{"b", "2"})
+	s.mockMonitor.ReportChan <- report(150, sysconfig.Setting{"a", "1"}, sysconfig.Setting{"b", "3"}, sysconfig.Setting{"c", "4"})
+	got = test.WaitData(s.dataChan)
+	t.Assert(got, HasLen, 1)
+	r = got[0].(*sysconfig.Report)
+	t.Check(r.Full, Equals, false)
+	t.Check(r.Settings, HasLen, 0)
+	expectChanges := []sysconfig.Change{
+		{Ts: 150, System: "mysql global variables", Var: "b", Type: sysconfig.SETTING_CHANGED, Old: "2", New: "3"},
+		{Ts: 150, System: "mysql global variables", Var: "c", Type: sysconfig.SETTING_ADDED, New: "4"},
+	}
+	if same, diff := test.IsDeeply(r.Changes, expectChanges); !same {
+		test.Dump(r.Changes)
+		t.Error(diff)
+	}
+
+	// Snapshot interval elapsed: full report with the changes, a removed.
+	s.mockMonitor.ReportChan <- report(180, sysconfig.Setting{"b", "3"}, sysconfig.Setting{"c", "4"})
+	got = test.WaitData(s.dataChan)
+	t.Assert(got, HasLen, 1)
+	r = got[0].(*sysconfig.Report)
+	t.Check(r.Full, Equals, true)
+	t.Check(r.Settings, HasLen, 2)
+	expectRemoved := []sysconfig.Change{
+		{Ts: 180, System: "mysql global variables", Var: "a", Type: sysconfig.SETTING_REMOVED, Old: "1"},
+	}
+	if same, diff := test.IsDeeply(r.Changes, expectRemoved); !same {
+		test.Dump(r.Changes)
+		t.Error(diff)
+	}
+
+	// All changes are in the history.
+	q := sysconfig.HistoryQuery{Service: "mysql", InstanceId: 1}
+	qData, _ := json.Marshal(q)
+	reply = m.Handle(&proto.Cmd{Service: "sysconfig", Cmd: "GetHistory", Data: qData})
+	t.Assert(reply.Error, Equals, "")
+	gotChanges := []sysconfig.Change{}
+	err = json.Unmarshal(reply.Data, &gotChanges)
+	t.Assert(err, IsNil)
+	if same, diff := test.IsDeeply(gotChanges, append(expectChanges, expectRemoved...)); !same {
+		test.Dump(gotChanges)
+		t.Error(diff)
+	}
+
+	q = sysconfig.HistoryQuery{Var: "b", Begin: time.Unix(100, 0)}
+	qData, _ = json.Marshal(q)
+	reply = m.Handle(&proto.Cmd{Service: "sysconfig", Cmd: "GetHistory", Data: qData})
+	t.Assert(reply.Error, Equals, "")
+	gotChanges = []sysconfig.Change{}
+	err = json.Unmarshal(reply.Data, &gotChanges)
+	t.Assert(err, IsNil)
+	if same, diff := test.IsDeeply(gotChanges, expectChanges[0:1]); !same {
+		test.Dump(gotChanges)
+		t.Error(diff)
+	}
+
+	// Full snapshot on request.
+	reply = m.Handle(&proto.Cmd{Service: "sysconfig", Cmd: "SendSnapshot", Data: sysconfigConfigData})
+	t.Assert(reply.Error, Equals, "")
+	got = test.WaitData(s.dataChan)
+	t.Assert(got, HasLen, 1)
+	r = got[0].(*sysconfig.Report)
+	t.Check(r.Full, Equals, true)
+	t.Check(r.Ts, Equals, int64(180))
+	t.Check(r.Settings, DeepEquals, []sysconfig.Setting{{"b", "3"}, {"c", "4"}})
+
+	// The snapshot and history are saved, so a new manager (e.g. after
+	// the agent restarts) reports changes made while it was stopped.
+	m.Stop()
+
+	s.factory.Set([]sysconfig.Monitor{s.mockMonitor})
+	m = sysconfig.NewManager(s.logger, s.factory, s.clock, s.spool, s.im)
+	err = m.Start()
+	t.Assert(err, IsNil)
+
+	s.mockMonitor.ReportChan <- report(200, sysconfig.Setting{"b", "3"}, sysconfig.Setting{"c", "5"})
+	got = test.WaitData(s.dataChan)
+	t.Assert(got, HasLen, 1)
+	r = got[0].(*sysconfig.Report)
+	t.Check(r.Full, Equals, false)
+	t.Check(r.Changes, DeepEquals, []sysconfig.Change{
+		{Ts: 200, System: "mysql global variables", Var: "c", Type: sysconfig.SETTING_CHANGED, Old: "4", New: "5"},
+	})
+
+	reply = m.Handle(&proto.Cmd{Service: "sysconfig", Cmd: "GetHistory"})
+	t.Assert(reply.Error, Equals, "")
+	gotChanges = []sysconfig.Change{}
+	err = json.Unmarshal(reply.Data, &gotChanges)
+	t.Assert(err, IsNil)
+	t.Check(gotChanges, HasLen, 4)
+
+	// Stopping the monitor removes its snapshot and history.
+	files, _ := filepath.Glob(filepath.Join(pct.Basedir.Dir("sysconfig"), "*"))
+	t.Check(files, Not(HasLen), 0)
+	reply = m.Handle(&proto.Cmd{Service: "sysconfig", Cmd: "StopService", Data: sysconfigConfigData})
+	t.Assert(reply.Error, Equals, "")
+	files, _ = filepath.Glob(filepath.Join(pct.Basedir.Dir("sysconfig"), "*"))
+	t.Check(files, HasLen, 0)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Diff test suite
+/////////////////////////////////////////////////////////////////////////////
+
+type DiffTestSuite struct {
+}
+
+var _ = Suite(&DiffTestSuite{})
+
+func (s *DiffTestSuite) TestDiff(t *C) {
+	prev := []sysconfig.Setting{{"a", "1"}, {"b", "2"}, {"c", "3"}}
+	cur := []sysconfig.Setting{{"d", "4"}, {"c", "3"}, {"a", "0"}}
+	got := sysconfig.Diff("sys", 10, prev, cur)
+	expect := []sysconfig.Change{
+		{Ts: 10, System: "sys", Var: "a", Type: sysconfig.SETTING_CHANGED, Old: "1", New: "0"},
+		{Ts: 10, System: "sys", Var: "b", Type: sysconfig.SETTING_REMOVED, Old: "2"},
+		{Ts: 10, System: "sys", Var: "d", Type: sysconfig.SETTING_ADDED, New: "4"},
+	}
+	if same, diff := test.IsDeeply(got, expect); !same {
+		test.Dump(got)
+		t.Error(diff)
+	}
+
+	got = sysconfig.Diff("sys", 10, prev, prev)
+	t.Check(got, HasLen, 0)
+}
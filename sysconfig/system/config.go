@@ -0,0 +1,51 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"github.com/percona/percona-agent/sysconfig"
+)
+
+// Sysctls reported if Config.Sysctls is not set: memory, I/O, and network
+// settings that matter for databases.
+var DEFAULT_SYSCTLS = []string{
+	"vm.swappiness",
+	"vm.dirty_ratio",
+	"vm.dirty_background_ratio",
+	"vm.dirty_expire_centisecs",
+	"vm.overcommit_memory",
+	"vm.zone_reclaim_mode",
+	"vm.nr_hugepages",
+	"kernel.numa_balancing",
+	"kernel.shmmax",
+	"fs.file-max",
+	"fs.aio-max-nr",
+	"net.core.somaxconn",
+	"net.ipv4.tcp_max_syn_backlog",
+	"net.ipv4.ip_local_port_range",
+}
+
+// Processes whose ulimits are reported if Config.Processes is not set.
+var DEFAULT_PROCESSES = []string{"mysqld"}
+
+type Config struct {
+	sysconfig.Config
+	Root      string   `json:",omitempty"` // filesystem root, "/" if empty; /proc and /sys are under it
+	Sysctls   []string `json:",omitempty"` // DEFAULT_SYSCTLS if empty
+	Processes []string `json:",omitempty"` // process names, DEFAULT_PROCESSES if empty
+}
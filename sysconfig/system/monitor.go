@@ -0,0 +1,174 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"fmt"
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/sysconfig"
+	"time"
+)
+
+const SYSTEM = "os settings"
+
+// Monitor reports OS settings that matter for databases: sysctls, transparent
+// huge pages, block device queues, NUMA, CPU governors, mounts, and ulimits.
+type Monitor struct {
+	name   string
+	config *Config
+	logger *pct.Logger
+	// --
+	root       string
+	tickChan   chan time.Time
+	reportChan chan *sysconfig.Report
+	status     *pct.Status
+	sync       *pct.SyncChan
+	running    bool
+}
+
+func NewMonitor(name string, config *Config, logger *pct.Logger) *Monitor {
+	if len(config.Sysctls) == 0 {
+		config.Sysctls = DEFAULT_SYSCTLS
+	}
+	if len(config.Processes) == 0 {
+		config.Processes = DEFAULT_PROCESSES
+	}
+	root := config.Root
+	if root == "" {
+		root = "/"
+	}
+	m := &Monitor{
+		name:   name,
+		config: config,
+		logger: logger,
+		// --
+		root:   root,
+		sync:   pct.NewSyncChan(),
+		status: pct.NewStatus([]string{name}),
+	}
+	return m
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Interface
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[0]
+func (m *Monitor) Start(tickChan chan time.Time, reportChan chan *sysconfig.Report) error {
+	if m.running {
+		return pct.ServiceIsRunningError{m.name}
+	}
+
+	m.status.Update(m.name, "Starting")
+	m.tickChan = tickChan
+	m.reportChan = reportChan
+	go m.run()
+	m.running = true
+	m.logger.Info("Started")
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Stop() error {
+	if !m.running {
+		return nil // already stopped
+	}
+
+	// Stop run().  When it returns, it updates status to "Stopped".
+	m.status.Update(m.name, "Stopping")
+	m.sync.Stop()
+	m.sync.Wait()
+	m.running = false
+	m.logger.Info("Stopped")
+	// Do not update status to "Stopped" here; run() does that on return.
+
+	return nil
+}
+
+// @goroutine[0]
+func (m *Monitor) Status() map[string]string {
+	return m.status.All()
+}
+
+// @goroutine[0]
+func (m *Monitor) TickChan() chan time.Time {
+	return m.tickChan
+}
+
+// @goroutine[0]
+func (m *Monitor) Config() interface{} {
+	return m.config
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// @goroutine[2]
+func (m *Monitor) run() {
+	defer func() {
+		m.status.Update(m.name, "Stopped")
+		m.sync.Done()
+	}()
+
+	var lastTs int64
+	for {
+		m.logger.Debug("run:wait")
+		m.status.Update(m.name, fmt.Sprintf("Idle (last collected at %s)", time.Unix(lastTs, 0)))
+
+		select {
+		case now := <-m.tickChan:
+			m.logger.Debug("run:collect:start")
+			m.status.Update(m.name, "Running")
+
+			settings, errs := Settings(m.root, m.config)
+			for _, err := range errs {
+				m.logger.Warn(err)
+			}
+
+			c := &sysconfig.Report{
+				ServiceInstance: proto.ServiceInstance{
+					Service:    m.config.Service,
+					InstanceId: m.config.InstanceId,
+				},
+				Ts:       now.UTC().Unix(),
+				System:   SYSTEM,
+				Settings: settings,
+				Full:     true,
+			}
+
+			if len(c.Settings) > 0 {
+				select {
+				case m.reportChan <- c:
+					lastTs = c.Ts
+				case <-time.After(500 * time.Millisecond):
+					// lost sysconfig
+					m.logger.Debug("Lost OS settings; timeout spooling after 500ms")
+				}
+			} else {
+				m.logger.Debug("No settings") // shouldn't happen
+			}
+
+			m.logger.Debug("run:collect:stop")
+		case <-m.sync.StopChan:
+			m.logger.Debug("run:stop")
+			return
+		}
+	}
+}
@@ -0,0 +1,354 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system
+
+import (
+	"fmt"
+	mmSystem "github.com/percona/percona-agent/mm/system"
+	"github.com/percona/percona-agent/sysconfig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Every func reads its settings from /proc and /sys under root, usually "/",
+ * so tests can use a fake root.  Files that don't exist are skipped because
+ * not every system has every setting, e.g. a VM usually has no cpufreq.
+ * Files that can't be read are errors, but the other files are still read.
+ */
+
+// Settings returns all OS settings and the errors reading them, if any.
+// An error only means that some settings are missing.
+func Settings(root string, config *Config) ([]sysconfig.Setting, []error) {
+	all := []sysconfig.Setting{}
+	errs := []error{}
+	add := func(settings []sysconfig.Setting, fileErrs []error) {
+		all = append(all, settings...)
+		errs = append(errs, fileErrs...)
+	}
+	add(Sysctls(root, config.Sysctls))
+	add(TransparentHugepages(root))
+	add(BlockDevices(root))
+	add(NUMA(root))
+	add(CPUs(root))
+	add(Mounts(root))
+	add(Ulimits(root, config.Processes))
+	return all, errs
+}
+
+// Sysctls returns the sysctls like sysctl -n, e.g. vm.swappiness=60.
+func Sysctls(root string, names []string) ([]sysconfig.Setting, []error) {
+	settings := []sysconfig.Setting{}
+	errs := []error{}
+	for _, name := range names {
+		file := filepath.Join(root, "proc", "sys", strings.Replace(name, ".", "/", -1))
+		value, ok, err := readValue(file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			settings = append(settings, sysconfig.Setting{name, value})
+		}
+	}
+	return settings, errs
+}
+
+// TransparentHugepages returns transparent_hugepage/enabled and defrag.  Red Hat
+// 6 calls the dir redhat_transparent_hugepage.
+func TransparentHugepages(root string) ([]sysconfig.Setting, []error) {
+	settings := []sysconfig.Setting{}
+	errs := []error{}
+	for _, dir := range []string{"transparent_hugepage", "redhat_transparent_hugepage"} {
+		for _, name := range []string{"enabled", "defrag"} {
+			value, ok, err := readValue(filepath.Join(root, "sys", "kernel", "mm", dir, name))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if ok {
+				settings = append(settings, sysconfig.Setting{"transparent_hugepage/" + name, selected(value)})
+			}
+		}
+		if len(settings) > 0 {
+			break
+		}
+	}
+	return settings, errs
+}
+
+// Loop and RAM disks aren't real storage.
+var virtualBlockDevice = regexp.MustCompile(`^(loop|ram|zram)[0-9]+$`)
+
+// BlockDevices returns the I/O scheduler and read-ahead of every block device,
+// e.g. block/sda/scheduler=deadline.
+func BlockDevices(root string) ([]sysconfig.Setting, []error) {
+	settings := []sysconfig.Setting{}
+	errs := []error{}
+	devices, err := readDir(filepath.Join(root, "sys", "block"))
+	if err != nil {
+		return settings, append(errs, err)
+	}
+	for _, device := range devices {
+		if virtualBlockDevice.MatchString(device) {
+			continue
+		}
+		queue := filepath.Join(root, "sys", "block", device, "queue")
+		for _, name := range []string{"scheduler", "read_ahead_kb"} {
+			value, ok, err := readValue(filepath.Join(queue, name))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if ok {
+				settings = append(settings, sysconfig.Setting{"block/" + device + "/" + name, selected(value)})
+			}
+		}
+	}
+	return settings, errs
+}
+
+var nodeDir = regexp.MustCompile(`^node([0-9]+)$`)
+
+// NUMA returns the number of NUMA nodes and each node's CPUs and memory,
+// e.g. numa/node0/cpus=0-7 and numa/node0/memory=16333852 kB.
+func NUMA(root string) ([]sysconfig.Setting, []error) {
+	settings := []sysconfig.Setting{}
+	errs := []error{}
+	dir := filepath.Join(root, "sys", "devices", "system", "node")
+	names, err := readDir(dir)
+	if err != nil {
+		return settings, append(errs, err)
+	}
+	if len(names) == 0 {
+		return settings, errs
+	}
+	nodes := []int{}
+	for _, name := range names {
+		if m := nodeDir.FindStringSubmatch(name); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Ints(nodes)
+	settings = append(settings, sysconfig.Setting{"numa/nodes", strconv.Itoa(len(nodes))})
+	for _, n := range nodes {
+		node := fmt.Sprintf("node%d", n)
+		cpus, ok, err := readValue(filepath.Join(dir, node, "cpulist"))
+		if err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			settings = append(settings, sysconfig.Setting{"numa/" + node + "/cpus", cpus})
+		}
+		// Node 0 MemTotal:       16333852 kB
+		content, err := ioutil.ReadFile(filepath.Join(dir, node, "meminfo"))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[2] == "MemTotal:" {
+				settings = append(settings, sysconfig.Setting{"numa/" + node + "/memory", strings.Join(fields[3:], " ")})
+				break
+			}
+		}
+	}
+	return settings, errs
+}
+
+// CPUs returns the online CPUs and their frequency governors.  CPUs usually
+// have the same governor, so cpu/governor is every governor used, e.g.
+// "performance" or "ondemand,performance".
+func CPUs(root string) ([]sysconfig.Setting, []error) {
+	settings := []sysconfig.Setting{}
+	errs := []error{}
+	dir := filepath.Join(root, "sys", "devices", "system", "cpu")
+	online, ok, err := readValue(filepath.Join(dir, "online"))
+	if err != nil {
+		errs = append(errs, err)
+	} else if ok {
+		settings = append(settings, sysconfig.Setting{"cpu/online", online})
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "cpu[0-9]*", "cpufreq", "scaling_governor"))
+	if err != nil {
+		return settings, append(errs, err)
+	}
+	seen := make(map[string]bool)
+	governors := []string{}
+	for _, file := range files {
+		governor, ok, err := readValue(file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok && !seen[governor] {
+			seen[governor] = true
+			governors = append(governors, governor)
+		}
+	}
+	if len(governors) > 0 {
+		sort.Strings(governors)
+		settings = append(settings, sysconfig.Setting{"cpu/governor", strings.Join(governors, ",")})
+	}
+	return settings, errs
+}
+
+// Mounts returns the device, fstype, and options of every real filesystem,
+// e.g. mount/var/lib/mysql=/dev/sdb1 xfs rw,noatime.
+func Mounts(root string) ([]sysconfig.Setting, []error) {
+	settings := []sysconfig.Setting{}
+	mounts, err := mmSystem.ReadMounts(filepath.Join(root, "proc", "mounts"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return settings, nil
+		}
+		return settings, []error{err}
+	}
+	for _, m := range mounts {
+		if mmSystem.VirtualFsTypes[m.FsType] {
+			continue
+		}
+		settings = append(settings, sysconfig.Setting{"mount" + m.Path, m.Device + " " + m.FsType + " " + m.Options})
+	}
+	return settings, nil
+}
+
+/**
+ * Ulimits returns the soft and hard limits of the first process with each
+ * name, e.g. ulimit/mysqld/max_open_files=1024 4096.  Processes are found by
+ * /proc/<pid>/comm, so names are at most 15 characters.
+ */
+func Ulimits(root string, processes []string) ([]sysconfig.Setting, []error) {
+	settings := []sysconfig.Setting{}
+	want := make(map[string]bool)
+	for _, name := range processes {
+		want[name] = true
+	}
+	names, err := readDir(filepath.Join(root, "proc"))
+	if err != nil {
+		return settings, []error{err}
+	}
+	pids := []int{}
+	for _, name := range names {
+		if pid, err := strconv.Atoi(name); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	for _, pid := range pids {
+		procDir := filepath.Join(root, "proc", strconv.Itoa(pid))
+		comm, ok, err := readValue(filepath.Join(procDir, "comm"))
+		if err != nil || !ok || !want[comm] {
+			continue // process probably exited
+		}
+		want[comm] = false
+		content, err := ioutil.ReadFile(filepath.Join(procDir, "limits"))
+		if err != nil {
+			continue
+		}
+		for _, limit := range ProcLimits(content) {
+			settings = append(settings, sysconfig.Setting{"ulimit/" + comm + "/" + limit[0], limit[1]})
+		}
+	}
+	return settings, nil
+}
+
+/**
+ * ProcLimits returns [name, "soft hard"] for every limit in /proc/<pid>/limits:
+ *   Limit                     Soft Limit           Hard Limit           Units
+ *   Max open files            1024                 4096                 files
+ * Names are lowercase with underscores, e.g. max_open_files.
+ */
+func ProcLimits(content []byte) [][2]string {
+	limits := [][2]string{}
+	lines := strings.Split(string(content), "\n")
+	if len(lines) == 0 {
+		return limits
+	}
+	// The columns are aligned, and limit names have spaces.
+	header := lines[0]
+	softCol := strings.Index(header, "Soft Limit")
+	hardCol := strings.Index(header, "Hard Limit")
+	unitsCol := strings.Index(header, "Units")
+	if softCol < 0 || hardCol < softCol || unitsCol < hardCol {
+		return limits
+	}
+	for _, line := range lines[1:] {
+		if len(line) <= hardCol {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(line[0:softCol]))
+		name = strings.Join(strings.Fields(name), "_")
+		soft := strings.TrimSpace(line[softCol:hardCol])
+		end := unitsCol
+		if len(line) < end {
+			end = len(line)
+		}
+		hard := strings.TrimSpace(line[hardCol:end])
+		limits = append(limits, [2]string{name, soft + " " + hard})
+	}
+	return limits
+}
+
+// readValue returns the content of a /proc or /sys file with whitespace
+// collapsed, e.g. "32768\t60999" is "32768 60999", or false if it doesn't exist.
+func readValue(file string) (string, bool, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return strings.Join(strings.Fields(string(content)), " "), true, nil
+}
+
+// readDir returns the names in dir, or none if it doesn't exist.
+func readDir(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	names := make([]string, len(files))
+	for i, fi := range files {
+		names[i] = fi.Name()
+	}
+	return names, nil
+}
+
+// selected returns the selected value of a list like "always madvise [never]",
+// or the value if nothing is selected.
+func selected(value string) string {
+	i := strings.Index(value, "[")
+	j := strings.Index(value, "]")
+	if i >= 0 && j > i {
+		return value[i+1 : j]
+	}
+	return value
+}
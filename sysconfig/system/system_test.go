@@ -0,0 +1,168 @@
+/*
+   Copyright (c) 2014, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package system_test
+
+import (
+	"github.com/percona/cloud-protocol/proto"
+	"github.com/percona/percona-agent/pct"
+	"github.com/percona/percona-agent/sysconfig"
+	"github.com/percona/percona-agent/sysconfig/system"
+	"github.com/percona/percona-agent/test"
+	"io/ioutil"
+	. "launchpad.net/gocheck"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+// A fake filesystem root with /proc and /sys files.
+var root = test.RootDir + "/sysconfig/root"
+
+type TestSuite struct {
+	logChan    chan *proto.LogEntry
+	logger     *pct.Logger
+	tickChan   chan time.Time
+	reportChan chan *sysconfig.Report
+	name       string
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpSuite(t *C) {
+	s.logChan = make(chan *proto.LogEntry, 10)
+	s.logger = pct.NewLogger(s.logChan, "sysconfig-system-test")
+	s.tickChan = make(chan time.Time)
+	s.reportChan = make(chan *sysconfig.Report, 1)
+	s.name = "sysconfig-system"
+}
+
+// --------------------------------------------------------------------------
+
+func (s *TestSuite) TestSettings(t *C) {
+	config := &system.Config{
+		Sysctls:   []string{"vm.swappiness", "vm.dirty_ratio", "vm.nr_hugepages", "net.ipv4.ip_local_port_range", "fs.file-max"},
+		Processes: []string{"mysqld"},
+	}
+	got, errs := system.Settings(root, config)
+	t.Check(errs, HasLen, 0)
+	expect := []sysconfig.Setting{
+		// vm.nr_hugepages doesn't exist
+		{"vm.swappiness", "1"},
+		{"vm.dirty_ratio", "20"},
+		{"net.ipv4.ip_local_port_range", "32768 60999"},
+		{"fs.file-max", "6815744"},
+		{"transparent_hugepage/enabled", "never"},
+		{"transparent_hugepage/defrag", "madvise"},
+		// loop0 is not reported
+		{"block/nvme0n1/scheduler", "none"},
+		{"block/nvme0n1/read_ahead_kb", "4096"},
+		{"block/sda/scheduler", "deadline"},
+		{"block/sda/read_ahead_kb", "128"},
+		{"numa/nodes", "2"},
+		{"numa/node0/cpus", "0-3"},
+		{"numa/node0/memory", "16333852 kB"},
+		{"numa/node1/cpus", "4-7"},
+		{"numa/node1/memory", "16777216 kB"},
+		{"cpu/online", "0-7"},
+		{"cpu/governor", "performance,powersave"},
+		{"mount/", "/dev/disk/by-uuid/2a0ad2e8-6c3f-4a4b-8e1d-0c5b0a3c1e2f ext4 rw,relatime,errors=remount-ro,data=ordered"},
+		{"mount/boot", "/dev/sda2 ext2 rw,relatime"},
+		{"mount/var/lib/mysql", "/dev/mapper/vg-mysql xfs rw,noatime,attr2,inode64,noquota"},
+		{"mount/var/log/mysql logs", "/dev/mapper/vg-logs xfs rw,noatime,attr2,inode64,noquota"},
+		// Only the first mysqld process
+		{"ulimit/mysqld/max_cpu_time", "unlimited unlimited"},
+		{"ulimit/mysqld/max_open_files", "65535 65535"},
+		{"ulimit/mysqld/max_processes", "63707 63707"},
+		{"ulimit/mysqld/max_locked_memory", "65536 65536"},
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *TestSuite) TestNoSettings(t *C) {
+	// Nothing exists, but that's not an error.
+	got, errs := system.Settings(t.MkDir(), &system.Config{Sysctls: system.DEFAULT_SYSCTLS})
+	t.Check(errs, HasLen, 0)
+	t.Check(got, HasLen, 0)
+}
+
+func (s *TestSuite) TestUnreadableFiles(t *C) {
+	// A file that can't be read, here because it's a dir, is an error,
+	// but the other files are still read.
+	dir := t.MkDir()
+	files := map[string]string{
+		"proc/sys/vm/dirty_ratio":           "20",
+		"sys/block/sda/queue/read_ahead_kb": "128",
+		"sys/block/sdb/queue/scheduler":     "noop [deadline] cfq",
+	}
+	for file, content := range files {
+		file = filepath.Join(dir, file)
+		t.Assert(os.MkdirAll(filepath.Dir(file), 0755), IsNil)
+		t.Assert(ioutil.WriteFile(file, []byte(content), 0644), IsNil)
+	}
+	for _, unreadable := range []string{"proc/sys/vm/swappiness", "sys/block/sda/queue/scheduler"} {
+		t.Assert(os.MkdirAll(filepath.Join(dir, unreadable), 0755), IsNil)
+	}
+
+	got, errs := system.Settings(dir, &system.Config{Sysctls: []string{"vm.swappiness", "vm.dirty_ratio"}})
+	t.Check(errs, HasLen, 2)
+	expect := []sysconfig.Setting{
+		{"vm.dirty_ratio", "20"},
+		{"block/sda/read_ahead_kb", "128"},
+		{"block/sdb/scheduler", "deadline"},
+	}
+	t.Check(got, DeepEquals, expect)
+}
+
+func (s *TestSuite) TestStartCollectStop(t *C) {
+	config := &system.Config{
+		Config: sysconfig.Config{
+			ServiceInstance: proto.ServiceInstance{
+				Service:    "server",
+				InstanceId: 1,
+			},
+		},
+		Root: root,
+	}
+	m := system.NewMonitor(s.name, config, s.logger)
+	t.Assert(m, NotNil)
+
+	err := m.Start(s.tickChan, s.reportChan)
+	t.Assert(err, IsNil)
+	if ok := test.WaitStatusPrefix(1, m, s.name, "Idle"); !ok {
+		t.Fatal("Monitor is ready")
+	}
+
+	now := time.Now().UTC()
+	s.tickChan <- now
+	got := test.WaitSystemConfig(s.reportChan, 1)
+	t.Assert(got, HasLen, 1)
+	c := got[0]
+	t.Check(c.Ts, Equals, now.Unix())
+	t.Check(c.System, Equals, system.SYSTEM)
+	t.Check(c.Full, Equals, true)
+	t.Check(c.Settings[0], Equals, sysconfig.Setting{"vm.swappiness", "1"})
+
+	m.Stop()
+	if ok := test.WaitStatus(1, m, s.name, "Stopped"); !ok {
+		t.Fatal("Monitor has stopped")
+	}
+}
@@ -18,6 +18,7 @@
 package mock
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/percona/percona-agent/mm"
 	"time"
@@ -49,10 +50,12 @@ func (f *MmMonitorFactory) Make(service string, id uint, data []byte) (mm.Monito
 // --------------------------------------------------------------------------
 
 type MmMonitor struct {
-	tickChan  chan time.Time
-	ReadyChan chan bool
-	running   bool
-	config    interface{}
+	tickChan       chan time.Time
+	ReadyChan      chan bool
+	CollectionChan chan *mm.Collection
+	running        bool
+	config         interface{}
+	Unsynchronized bool
 }
 
 func NewMmMonitor() *MmMonitor {
@@ -64,6 +67,7 @@ func (m *MmMonitor) Start(tickChan chan time.Time, collectionChan chan *mm.Colle
 	if m.ReadyChan != nil {
 		<-m.ReadyChan
 	}
+	m.CollectionChan = collectionChan
 	m.running = true
 	return nil
 }
@@ -94,3 +98,16 @@ func (m *MmMonitor) Config() interface{} {
 func (m *MmMonitor) SetConfig(v interface{}) {
 	m.config = v
 }
+
+func (m *MmMonitor) Reconfigure(data []byte) error {
+	config := make(map[string]interface{})
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+	m.config = config
+	return nil
+}
+
+func (m *MmMonitor) Synchronized() bool {
+	return !m.Unsynchronized
+}
@@ -60,6 +60,8 @@ type SysconfigMonitor struct {
 	ReadyChan chan bool
 	running   bool
 	config    interface{}
+	// Set when started, to send reports like a real monitor:
+	ReportChan chan *sysconfig.Report
 }
 
 func NewSysconfigMonitor() *SysconfigMonitor {
@@ -71,6 +73,7 @@ func (m *SysconfigMonitor) Start(tickChan chan time.Time, reportChan chan *sysco
 	if m.ReadyChan != nil {
 		<-m.ReadyChan
 	}
+	m.ReportChan = reportChan
 	m.running = true
 	return nil
 }